@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 )
 
 type AgentNode struct {
@@ -12,6 +14,10 @@ type AgentNode struct {
 	Provider  LLMProvider
 	StateKey  string
 	OutputKey string
+
+	// Tools, if set, are listed in the prompt (with their descriptions for
+	// DescribedTools) in place of the default web_search and docker_exec.
+	Tools map[string]Tool
 }
 
 func NewAgentNode(provider LLMProvider, stateKey, outputKey string) *AgentNode {
@@ -58,13 +64,13 @@ func (n *AgentNode) Run(ctx context.Context, mem *Memory) ([]Trigger, error) {
 	// 3. Build improved agent prompt
 	// ----------------------------
 
+	toolList, toolNames := n.describeTools()
+
 	prompt := `
-You are an autonomous agent with access to two tools:
+You are an autonomous agent with access to these tools:
 
 TOOLS:
-1) web_search(query: string) -> returns JSON
-2) docker_exec(cmd: string) -> runs commands inside a persistent Ubuntu container at /workspace
-
+` + toolList + `
 REQUIREMENTS:
 - Think step-by-step.
 - Use tools when necessary.
@@ -73,7 +79,7 @@ REQUIREMENTS:
 - When the task is complete, return:
   {"action":"final","response":"..."}
 - Otherwise use:
-  {"action":"tool","tool":"web_search"|"docker_exec","input": ...}
+  {"action":"tool","tool":` + toolNames + `,"input": ...}
 
 HISTORY:
 ` + buildHistory(state) + `
@@ -141,6 +147,34 @@ Now produce the next action strictly in JSON format.
 	}
 }
 
+// describeTools returns the numbered tool list for the prompt and the tool
+// names as a JSON alternative ("a"|"b").
+func (n *AgentNode) describeTools() (string, string) {
+	if len(n.Tools) == 0 {
+		return "1) web_search(query: string) -> returns JSON\n" +
+				"2) docker_exec(cmd: string) -> runs commands inside a persistent Ubuntu container at /workspace\n",
+			`"web_search"|"docker_exec"`
+	}
+
+	names := make([]string, 0, len(n.Tools))
+	for name := range n.Tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var list strings.Builder
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		desc := name
+		if d, ok := n.Tools[name].(DescribedTool); ok {
+			desc = d.Description()
+		}
+		fmt.Fprintf(&list, "%d) %s\n", i+1, desc)
+		quoted[i] = `"` + name + `"`
+	}
+	return list.String(), strings.Join(quoted, "|")
+}
+
 // Helper to format history text
 func buildHistory(lines []string) string {
 	out := ""
@@ -0,0 +1,148 @@
+package nodechain
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// BM25Index is an in-memory keyword index over Document.Text. It complements
+// vector search for exact identifiers (error codes, SKUs, function names)
+// that embeddings tend to blur.
+type BM25Index struct {
+	K1 float64 // term frequency saturation
+	B  float64 // length normalisation
+
+	mu       sync.RWMutex
+	docs     []Document
+	lengths  []int
+	postings map[string]map[int]int // term -> doc index -> term frequency
+	totalLen int
+}
+
+func NewBM25Index() *BM25Index {
+	return &BM25Index{
+		K1:       1.2,
+		B:        0.75,
+		postings: make(map[string]map[int]int),
+	}
+}
+
+func (idx *BM25Index) Add(ctx context.Context, docs []Document) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for _, d := range docs {
+		i := len(idx.docs)
+		terms := tokenizeBM25(d.Text)
+
+		idx.docs = append(idx.docs, d)
+		idx.lengths = append(idx.lengths, len(terms))
+		idx.totalLen += len(terms)
+
+		for _, t := range terms {
+			p, ok := idx.postings[t]
+			if !ok {
+				p = make(map[int]int)
+				idx.postings[t] = p
+			}
+			p[i]++
+		}
+	}
+	return nil
+}
+
+// Len returns the number of indexed documents.
+func (idx *BM25Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.docs)
+}
+
+// Search returns up to k documents ranked by BM25 score. Documents that share
+// no terms with the query are not returned.
+func (idx *BM25Index) Search(ctx context.Context, query string, k int) ([]ScoredDocument, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	terms := tokenizeBM25(query)
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("BM25Index: query has no searchable terms")
+	}
+	if len(idx.docs) == 0 {
+		return nil, nil
+	}
+
+	n := float64(len(idx.docs))
+	avgLen := float64(idx.totalLen) / n
+
+	scores := make(map[int]float64)
+	seen := make(map[string]bool, len(terms))
+	for _, t := range terms {
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+
+		p := idx.postings[t]
+		if len(p) == 0 {
+			continue
+		}
+		df := float64(len(p))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+
+		for i, tf := range p {
+			f := float64(tf)
+			norm := 1 - idx.B + idx.B*float64(idx.lengths[i])/avgLen
+			scores[i] += idf * f * (idx.K1 + 1) / (f + idx.K1*norm)
+		}
+	}
+
+	results := make([]ScoredDocument, 0, len(scores))
+	for i, s := range scores {
+		results = append(results, ScoredDocument{Document: idx.docs[i], Score: s})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score == results[j].Score {
+			return results[i].ID < results[j].ID
+		}
+		return results[i].Score > results[j].Score
+	})
+
+	if k > 0 && k < len(results) {
+		results = results[:k]
+	}
+	return results, nil
+}
+
+// tokenizeBM25 lowercases text and splits it into terms. Identifiers joined by
+// '-', '_' or '.' (e.g. "ERR-1042", "sku_88.b") are kept whole and also
+// indexed by their parts, so both exact and partial lookups match.
+func tokenizeBM25(text string) []string {
+	isJoiner := func(r rune) bool { return r == '-' || r == '_' || r == '.' }
+
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !isJoiner(r)
+	})
+
+	var out []string
+	for _, f := range fields {
+		f = strings.TrimFunc(f, isJoiner)
+		if f == "" {
+			continue
+		}
+		out = append(out, f)
+
+		if strings.IndexFunc(f, isJoiner) >= 0 {
+			for _, part := range strings.FieldsFunc(f, isJoiner) {
+				out = append(out, part)
+			}
+		}
+	}
+	return out
+}
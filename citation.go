@@ -0,0 +1,179 @@
+package nodechain
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DocumentSource returns a human-readable origin for d: its "source"
+// metadata if present, otherwise its ID.
+func DocumentSource(d Document) string {
+	if src, ok := d.Metadata["source"].(string); ok && src != "" {
+		return src
+	}
+	return d.ID
+}
+
+// Citation links a "[n]" marker in an answer to the context document it
+// refers to.
+type Citation struct {
+	Number     int    `json:"number"`
+	DocumentID string `json:"document_id"`
+	Source     string `json:"source"`
+}
+
+// CitedAnswer is an LLM answer with its citations resolved against the
+// context documents the prompt was built from.
+type CitedAnswer struct {
+	Answer    string     `json:"answer"`
+	Citations []Citation `json:"citations"` // distinct, in order of first use
+
+	// InvalidCitations are numbers that match no context document, i.e.
+	// hallucinated references.
+	InvalidCitations []int `json:"invalid_citations,omitempty"`
+	// UncitedSentences are answer sentences carrying no citation.
+	UncitedSentences []string `json:"uncited_sentences,omitempty"`
+	// UnusedDocuments are IDs of context documents the answer never cites.
+	UnusedDocuments []string `json:"unused_documents,omitempty"`
+}
+
+// Grounded reports whether every sentence is cited and every citation
+// resolves to a context document.
+func (a CitedAnswer) Grounded() bool {
+	return len(a.InvalidCitations) == 0 && len(a.UncitedSentences) == 0
+}
+
+var (
+	citationRe     = regexp.MustCompile(`\[(\d+(?:\s*,\s*\d+)*)\]`)
+	sentenceEndRe  = regexp.MustCompile(`[.!?](?:\s*\[\d+(?:\s*,\s*\d+)*\])*(?:\s+|$)|\n+`)
+	citationOnlyRe = regexp.MustCompile(`^(?:\s*\[\d+(?:\s*,\s*\d+)*\])+\s*$`)
+)
+
+// ParseCitations resolves the "[n]" / "[n, m]" markers in answer against
+// docs, numbered from 1 as RAGPromptNode presents them.
+func ParseCitations(answer string, docs []Document) CitedAnswer {
+	out := CitedAnswer{Answer: answer}
+
+	seen := map[int]bool{}
+	invalid := map[int]bool{}
+	for _, m := range citationRe.FindAllStringSubmatch(answer, -1) {
+		for _, part := range strings.Split(m[1], ",") {
+			num, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+			if num < 1 || num > len(docs) {
+				invalid[num] = true
+				continue
+			}
+			if seen[num] {
+				continue
+			}
+			seen[num] = true
+			d := docs[num-1]
+			out.Citations = append(out.Citations, Citation{
+				Number:     num,
+				DocumentID: d.ID,
+				Source:     DocumentSource(d),
+			})
+		}
+	}
+
+	for num := range invalid {
+		out.InvalidCitations = append(out.InvalidCitations, num)
+	}
+	sort.Ints(out.InvalidCitations)
+
+	for i, d := range docs {
+		if !seen[i+1] {
+			out.UnusedDocuments = append(out.UnusedDocuments, d.ID)
+		}
+	}
+
+	for _, s := range splitSentences(answer) {
+		if !citationRe.MatchString(s) {
+			out.UncitedSentences = append(out.UncitedSentences, s)
+		}
+	}
+
+	return out
+}
+
+// splitSentences breaks text at sentence ends, keeping any citation markers
+// that follow the punctuation with the sentence they close.
+func splitSentences(text string) []string {
+	var out []string
+	start := 0
+	for _, loc := range sentenceEndRe.FindAllStringIndex(text, -1) {
+		if s := strings.TrimSpace(text[start:loc[1]]); s != "" && !citationOnlyRe.MatchString(s) {
+			out = append(out, s)
+		}
+		start = loc[1]
+	}
+	if s := strings.TrimSpace(text[start:]); s != "" && !citationOnlyRe.MatchString(s) {
+		out = append(out, s)
+	}
+	return out
+}
+
+// CitationNode: resolves citations in an LLM answer and stores a CitedAnswer.
+//
+// ContextKey must hold the documents exactly as numbered in the prompt. When
+// RAGPromptNode has a Budget it may drop documents, so point ContextKey at
+// its UsedKey rather than at the retrieved documents.
+type CitationNode struct {
+	BaseNode
+	AnswerKey  string // LLM answer text
+	ContextKey string // []Document the prompt was built from (RAGPromptNode.UsedKey)
+	ResultKey  string // where the CitedAnswer is stored
+
+	// UngroundedAction, if set, is triggered instead of DefaultAction when
+	// the answer is not grounded, so flows can retry or flag it.
+	UngroundedAction Action
+}
+
+func NewCitationNode(answerKey, contextKey, resultKey string) *CitationNode {
+	return &CitationNode{
+		BaseNode:   NewBaseNode(),
+		AnswerKey:  answerKey,
+		ContextKey: contextKey,
+		ResultKey:  resultKey,
+	}
+}
+
+func (n *CitationNode) TypeName() string { return "CitationNode" }
+
+func (n *CitationNode) Run(ctx context.Context, mem *Memory) ([]Trigger, error) {
+	rawA, ok := mem.Get(n.AnswerKey)
+	if !ok {
+		return nil, fmt.Errorf("CitationNode: answer not found at key '%s'", n.AnswerKey)
+	}
+	answer, ok := rawA.(string)
+	if !ok {
+		return nil, fmt.Errorf("CitationNode: answer at key '%s' is not a string", n.AnswerKey)
+	}
+
+	rawCtx, ok := mem.Get(n.ContextKey)
+	if !ok {
+		return nil, fmt.Errorf("CitationNode: contexts not found at key '%s'", n.ContextKey)
+	}
+	docs, ok := rawCtx.([]Document)
+	if !ok {
+		return nil, fmt.Errorf("CitationNode: value at key '%s' is not []Document", n.ContextKey)
+	}
+
+	cited := ParseCitations(answer, docs)
+	mem.Local[n.ResultKey] = cited
+
+	action := DefaultAction
+	if n.UngroundedAction != "" && !cited.Grounded() {
+		action = n.UngroundedAction
+	}
+	return []Trigger{
+		{Action: action, ForkingData: map[string]any{}},
+	}, nil
+}
@@ -0,0 +1,145 @@
+package nodechain
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseCitations(t *testing.T) {
+	docs := []Document{
+		{ID: "a", Metadata: map[string]any{"source": "a.md"}},
+		{ID: "b"},
+		{ID: "c"},
+	}
+
+	tests := []struct {
+		name      string
+		answer    string
+		cited     []string
+		invalid   []int
+		uncited   []string
+		unused    []string
+		grounded  bool
+		wantFirst string // Source of the first citation, if any
+	}{
+		{
+			name:      "all cited",
+			answer:    "Go is compiled [1]. It has goroutines [2, 3].",
+			cited:     []string{"a", "b", "c"},
+			grounded:  true,
+			wantFirst: "a.md",
+		},
+		{
+			name:     "marker after the full stop",
+			answer:   "Go is compiled. [2] It is fast.[2]",
+			cited:    []string{"b"},
+			unused:   []string{"a", "c"},
+			grounded: true,
+		},
+		{
+			name:    "hallucinated reference",
+			answer:  "Go is compiled [4]. It is fast [1].",
+			cited:   []string{"a"},
+			invalid: []int{4},
+			unused:  []string{"b", "c"},
+		},
+		{
+			name:    "uncited sentence",
+			answer:  "Go is compiled [3]. Nobody knows why!",
+			cited:   []string{"c"},
+			uncited: []string{"Nobody knows why!"},
+			unused:  []string{"a", "b"},
+		},
+		{
+			name:    "no citations",
+			answer:  "I don't know.",
+			uncited: []string{"I don't know."},
+			unused:  []string{"a", "b", "c"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseCitations(tt.answer, docs)
+			var cited []string
+			for _, c := range got.Citations {
+				cited = append(cited, c.DocumentID)
+			}
+			if !slices.Equal(cited, tt.cited) {
+				t.Errorf("citations %v, want %v", cited, tt.cited)
+			}
+			if !slices.Equal(got.InvalidCitations, tt.invalid) {
+				t.Errorf("invalid %v, want %v", got.InvalidCitations, tt.invalid)
+			}
+			if !slices.Equal(got.UncitedSentences, tt.uncited) {
+				t.Errorf("uncited %q, want %q", got.UncitedSentences, tt.uncited)
+			}
+			if !slices.Equal(got.UnusedDocuments, tt.unused) {
+				t.Errorf("unused %v, want %v", got.UnusedDocuments, tt.unused)
+			}
+			if got.Grounded() != tt.grounded {
+				t.Errorf("Grounded() = %v, want %v", got.Grounded(), tt.grounded)
+			}
+			if tt.wantFirst != "" && got.Citations[0].Source != tt.wantFirst {
+				t.Errorf("source %q, want %q", got.Citations[0].Source, tt.wantFirst)
+			}
+		})
+	}
+}
+
+func TestCitationNodeUngroundedAction(t *testing.T) {
+	docs := []Document{{ID: "a"}}
+	tests := []struct {
+		answer string
+		want   Action
+	}{
+		{"Grounded [1].", DefaultAction},
+		{"Made up [2].", "retry"},
+	}
+	for _, tt := range tests {
+		n := NewCitationNode("answer", "docs", "cited")
+		n.UngroundedAction = "retry"
+		mem := NewMemory(map[string]any{"answer": tt.answer, "docs": docs})
+		triggers, err := n.Run(context.Background(), mem)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if triggers[0].Action != tt.want {
+			t.Errorf("%q: action %q, want %q", tt.answer, triggers[0].Action, tt.want)
+		}
+		if _, ok := mem.Local["cited"].(CitedAnswer); !ok {
+			t.Errorf("%q: no CitedAnswer stored", tt.answer)
+		}
+	}
+}
+
+func TestCitationNodeWithPromptBudget(t *testing.T) {
+	docs := []Document{
+		{ID: "short", Text: "fits"},
+		{ID: "long", Text: strings.Repeat("x", 100)},
+	}
+	prompt := NewRAGPromptNode("query", "contexts", "prompt")
+	prompt.Budget, prompt.UsedKey = 10, "used"
+	cite := NewCitationNode("answer", "used", "cited")
+
+	mem := NewMemory(map[string]any{"query": "q", "contexts": docs})
+	if _, err := prompt.Run(context.Background(), mem); err != nil {
+		t.Fatal(err)
+	}
+	if p := mem.Local["prompt"].(string); !strings.Contains(p, "[1] (source: short) fits") {
+		t.Fatalf("prompt does not number the document that fit as [1]:\n%s", p)
+	}
+
+	mem.Local["answer"] = "It fits [1]. It is long [2]."
+	if _, err := cite.Run(context.Background(), mem); err != nil {
+		t.Fatal(err)
+	}
+	got := mem.Local["cited"].(CitedAnswer)
+	if len(got.Citations) != 1 || got.Citations[0].DocumentID != "short" {
+		t.Fatalf("[1] resolved to %+v, want the document in the prompt", got.Citations)
+	}
+	if !slices.Equal(got.InvalidCitations, []int{2}) {
+		t.Fatalf("invalid citations %v: [2] was not in the prompt", got.InvalidCitations)
+	}
+}
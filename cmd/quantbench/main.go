@@ -0,0 +1,167 @@
+// Command quantbench compares the float32 InMemoryVectorStore with int8 and
+// binary QuantizedVectorStores on synthetic clustered embeddings, reporting
+// heap used by the index, search latency and recall@k against exact search.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"text/tabwriter"
+	"time"
+
+	nc "nodechain"
+)
+
+func main() {
+	n := flag.Int("n", 50000, "number of documents")
+	dim := flag.Int("dim", 1536, "embedding dimension")
+	queries := flag.Int("queries", 100, "number of queries")
+	k := flag.Int("k", 10, "results per query")
+	flag.Parse()
+
+	ctx := context.Background()
+	rng := rand.New(rand.NewSource(1))
+
+	fmt.Printf("generating %d x %d vectors...\n", *n, *dim)
+	docs, qs := syntheticData(rng, *n, *dim, *queries)
+
+	tmp, err := os.MkdirTemp("", "quantbench")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	type variant struct {
+		name  string
+		build func() (nc.VectorStore, error)
+	}
+	variants := []variant{
+		{"float32", func() (nc.VectorStore, error) { return nc.NewInMemoryVectorStore(), nil }},
+		{"int8", func() (nc.VectorStore, error) { return nc.NewQuantizedVectorStore(nc.QuantizeInt8, nil), nil }},
+		{"int8+rescore(file)", func() (nc.VectorStore, error) {
+			a, err := nc.NewFileVectorArchive(filepath.Join(tmp, "int8.f32"), *dim)
+			return nc.NewQuantizedVectorStore(nc.QuantizeInt8, a), err
+		}},
+		{"binary", func() (nc.VectorStore, error) { return nc.NewQuantizedVectorStore(nc.QuantizeBinary, nil), nil }},
+		{"binary+rescore(file)", func() (nc.VectorStore, error) {
+			a, err := nc.NewFileVectorArchive(filepath.Join(tmp, "binary.f32"), *dim)
+			return nc.NewQuantizedVectorStore(nc.QuantizeBinary, a), err
+		}},
+	}
+
+	// exact results from the float32 store are the recall baseline
+	var exact [][]string
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "STORE\tHEAP MB\tSEARCH ms/q\tRECALL@%d\n", *k)
+
+	for _, v := range variants {
+		before := heapInUse()
+		store, err := v.build()
+		if err != nil {
+			panic(err)
+		}
+		// copy so each store owns its vectors and the heap delta is honest
+		batch := make([]nc.Document, len(docs))
+		for i, d := range docs {
+			d.Embedding = append([]float32(nil), d.Embedding...)
+			batch[i] = d
+		}
+		if err := store.Add(ctx, batch); err != nil {
+			panic(err)
+		}
+		batch = nil
+		heap := heapInUse() - before
+
+		start := time.Now()
+		results := make([][]string, len(qs))
+		for i, q := range qs {
+			found, err := store.Search(ctx, q, *k)
+			if err != nil {
+				panic(err)
+			}
+			for _, d := range found {
+				results[i] = append(results[i], d.ID)
+			}
+		}
+		perQuery := time.Since(start) / time.Duration(len(qs))
+
+		if exact == nil {
+			exact = results
+		}
+
+		fmt.Fprintf(tw, "%s\t%.1f\t%.2f\t%.3f\n", v.name,
+			float64(heap)/(1<<20), float64(perQuery.Microseconds())/1000, recall(exact, results))
+		runtime.KeepAlive(store)
+	}
+	tw.Flush()
+}
+
+// syntheticData draws documents and queries around shared cluster centres so
+// nearest neighbours are meaningful.
+func syntheticData(rng *rand.Rand, n, dim, queries int) ([]nc.Document, [][]float32) {
+	const clusters = 256
+	centres := make([][]float32, clusters)
+	for i := range centres {
+		centres[i] = randomVector(rng, dim, nil, 1)
+	}
+
+	docs := make([]nc.Document, n)
+	for i := range docs {
+		docs[i] = nc.Document{
+			ID:        fmt.Sprintf("doc-%d", i),
+			Embedding: randomVector(rng, dim, centres[rng.Intn(clusters)], 0.6),
+		}
+	}
+
+	qs := make([][]float32, queries)
+	for i := range qs {
+		qs[i] = randomVector(rng, dim, centres[rng.Intn(clusters)], 0.6)
+	}
+	return docs, qs
+}
+
+func randomVector(rng *rand.Rand, dim int, centre []float32, noise float64) []float32 {
+	v := make([]float32, dim)
+	for i := range v {
+		x := rng.NormFloat64() * noise
+		if centre != nil {
+			x += float64(centre[i])
+		}
+		v[i] = float32(x)
+	}
+	return v
+}
+
+func recall(exact, got [][]string) float64 {
+	var hit, total int
+	for i := range exact {
+		want := map[string]bool{}
+		for _, id := range exact[i] {
+			want[id] = true
+		}
+		for _, id := range got[i] {
+			if want[id] {
+				hit++
+			}
+		}
+		total += len(exact[i])
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(hit) / float64(total)
+}
+
+func heapInUse() int64 {
+	runtime.GC()
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return int64(m.HeapInuse)
+}
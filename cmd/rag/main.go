@@ -14,17 +14,23 @@ import (
 func main() {
 	ctx := context.Background()
 
+	// Without OPENAI_API_KEY the demo runs offline: documents are embedded
+	// with the local HashingEmbedder and the flow stops at the prompt.
 	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		panic("Please set OPENAI_API_KEY")
-	}
-
-	client := openai.NewClient(apiKey)
-
-	embedder := nc.NewOpenAIEmbedder(client, "text-embedding-3-small")
-	provider := &nc.OpenAIProvider{
-		Client: client,
-		Model:  "gpt-4o-mini",
+	offline := apiKey == ""
+
+	var embedder nc.Embedder
+	var provider nc.LLMProvider
+	if offline {
+		fmt.Println("OPENAI_API_KEY not set, running offline")
+		embedder = nc.NewHashingEmbedder(256)
+	} else {
+		client := openai.NewClient(apiKey)
+		embedder = nc.NewOpenAIEmbedder(client, "text-embedding-3-small")
+		provider = &nc.OpenAIProvider{
+			Client: client,
+			Model:  "gpt-4o-mini",
+		}
 	}
 
 	store := nc.NewInMemoryVectorStore()
@@ -34,22 +40,32 @@ func main() {
 
 	// Flow:
 	// ValueNode("query") -> EmbedQueryNode -> RetrieveNode -> RAGPromptNode
-	// -> LLMNode -> PrintNode
+	// -> LLMNode -> CitationNode -> PrintNode
 
 	queryNode := nc.NewValueNode("query",
 		"How does NodeChain help build RAG systems?")
 
 	embedNode := nc.NewEmbedQueryNode(embedder, "query", "query_embedding")
+	embedNode.Store = store
 	retrieveNode := nc.NewRetrieveNode(store, "query_embedding", "contexts", 3)
 	ragPromptNode := nc.NewRAGPromptNode("query", "contexts", "prompt")
-	llmNode := nc.NewLLMNode(provider, "prompt", "answer")
-	printNode := &nc.PrintNode{Keys: []string{"answer"}}
+	ragPromptNode.UsedKey = "used_contexts" // what citations are numbered against
 
 	queryNode.On(nc.DefaultAction, embedNode)
 	embedNode.On(nc.DefaultAction, retrieveNode)
 	retrieveNode.On(nc.DefaultAction, ragPromptNode)
-	ragPromptNode.On(nc.DefaultAction, llmNode)
-	llmNode.On(nc.DefaultAction, printNode)
+
+	if offline {
+		ragPromptNode.On(nc.DefaultAction, &nc.PrintNode{Keys: []string{"prompt"}})
+	} else {
+		llmNode := nc.NewLLMNode(provider, "prompt", "answer")
+		citationNode := nc.NewCitationNode("answer", "used_contexts", "cited_answer")
+		printNode := &nc.PrintNode{Keys: []string{"answer", "cited_answer"}}
+
+		ragPromptNode.On(nc.DefaultAction, llmNode)
+		llmNode.On(nc.DefaultAction, citationNode)
+		citationNode.On(nc.DefaultAction, printNode)
+	}
 
 	flow := nc.NewFlow(queryNode)
 
@@ -70,20 +86,16 @@ func indexDemoDocs(ctx context.Context, embedder nc.Embedder, store nc.VectorSto
 		"NodeChain supports nodes for embedding, retrieval, and LLM calls, making it easy to build RAG systems.",
 	}
 
-	embs, err := embedder.EmbedText(ctx, texts)
-	if err != nil {
-		return err
-	}
-
-	docs := make([]nc.Document, len(texts))
+	docs := make(nc.StaticLoader, len(texts))
 	for i, t := range texts {
 		docs[i] = nc.Document{
-			ID:        fmt.Sprintf("doc-%d", i+1),
-			Text:      t,
-			Metadata:  map[string]any{"source": "demo"},
-			Embedding: embs[i],
+			ID:       fmt.Sprintf("doc-%d", i+1),
+			Text:     t,
+			Metadata: map[string]any{"source": "demo"},
 		}
 	}
 
-	return store.Add(ctx, docs)
+	pipeline := nc.NewIngestPipeline(embedder, store)
+	_, err := pipeline.Run(ctx, docs)
+	return err
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 
 	nc "nodechain"
 
@@ -27,23 +28,52 @@ func main() {
 	}
 
 	// Docker
+	if removed, err := nc.CleanupOrphanedContainers(); err != nil {
+		fmt.Println("orphan cleanup:", err)
+	} else if len(removed) > 0 {
+		fmt.Println("removed orphaned containers:", removed)
+	}
+
 	docker := nc.NewDockerManager("ubuntu:latest", "./workspace")
-	if err := docker.Start(); err != nil {
+	// The task browses the web, so the sandbox needs general egress
+	docker.Network = nc.NetworkBridge
+	docker.Provision = &nc.ProvisionSpec{
+		Packages: []string{"ca-certificates", "curl", "wget", "python3", "python3-pip", "file"},
+		Cache:    true,
+	}
+	if err := docker.Start(ctx); err != nil {
 		panic(err)
 	}
-	defer docker.Stop()
+	defer docker.Stop(ctx)
+
+	// a clean state the agent can always roll back to
+	if _, err := docker.Snapshot(ctx, "initial"); err != nil {
+		panic(err)
+	}
+
+	// one persistent shell, so cd and exports carry over between steps
+	shell := nc.NewShellSession(docker)
+	defer shell.Close()
 
-	// Optional bootstrap
-	docker.Run(`apt-get update -y`)
-	docker.Run(`apt-get install -y curl wget python3 python3-pip`)
+	// a Python interpreter whose variables persist between steps
+	python := nc.NewPythonSession(docker)
+	defer python.Close()
 
 	// Tools
 	tools := map[string]nc.Tool{
-		"docker_exec": &nc.DockerExecTool{Docker: docker},
-		"web_search":  &nc.SerperSearchTool{},
+		"docker_exec":       &nc.DockerExecTool{Sandbox: shell, Options: nc.ExecOptions{Timeout: 2 * time.Minute}},
+		"python_exec":       &nc.PythonExecTool{Session: python, Options: nc.ExecOptions{Timeout: 2 * time.Minute}},
+		"read_file":         &nc.ReadFileTool{Sandbox: docker},
+		"write_file":        &nc.WriteFileTool{Sandbox: docker},
+		"list_dir":          &nc.ListDirTool{Sandbox: docker},
+		"download_artifact": &nc.DownloadArtifactTool{Sandbox: docker, Dir: "./artifacts"},
+		"snapshot":          &nc.SnapshotTool{Sandbox: docker},
+		"rollback":          &nc.RollbackTool{Sandbox: docker},
+		"web_search":        &nc.SerperSearchTool{},
 	}
 
 	agent := nc.NewAgentNode(provider, "state", "agent_output")
+	agent.Tools = tools
 	toolNode := nc.NewToolNode(tools, "tool", "input", "tool_result")
 	finalLLM := nc.NewLLMNode(provider, "final_answer", "final_response")
 	printNode := &nc.PrintNode{Keys: []string{"final_response"}}
@@ -56,7 +86,7 @@ func main() {
 
 	// Seed task
 	startTask := nc.NewValueNode("task",
-		"Find a website about koalas and download the largest image to /workspace.",
+		"Find a website about koalas, download the largest image to /workspace and save it as an artifact.",
 	)
 
 	startTask.On(nc.DefaultAction, agent)
@@ -2,27 +2,119 @@ package nodechain
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
+// Labels set on every container a DockerManager creates.
+const (
+	LabelManaged = "nodechain.managed" // always "true"
+	LabelOwner   = "nodechain.owner"   // "<hostname>:<pid>" of the creating process
+	LabelNamed   = "nodechain.named"   // "true" if the name was user-supplied
+	LabelProxy   = "nodechain.proxy"   // egress proxy address in NetworkAllowlist mode
+	LabelConfig  = "nodechain.config"  // hash of the security, network and workspace settings
+)
+
+// NetworkMode controls what a DockerManager container can reach.
+type NetworkMode string
+
+const (
+	// NetworkNone gives the container no network at all. It is the default,
+	// and what the zero value means.
+	NetworkNone NetworkMode = "none"
+	// NetworkAllowlist puts the container on an internal network with no
+	// route out, and points HTTP_PROXY/HTTPS_PROXY at an EgressProxy run by
+	// this process that only reaches EgressAllowlist. Tools that ignore the
+	// proxy variables get no network. The proxy listens on the network's
+	// gateway address, so this process must run on the Docker host.
+	NetworkAllowlist NetworkMode = "allowlist"
+	// NetworkBridge is Docker's default bridge network: unrestricted egress.
+	NetworkBridge NetworkMode = "bridge"
+)
+
+// DockerManager is a Sandbox backed by a long-lived container driven through
+// the docker CLI, with Workspace bind-mounted at /workspace.
+//
+// The limits and security options below apply when Start creates the
+// container; an adopted container keeps the options it was created with.
 type DockerManager struct {
 	Image     string
 	Workspace string // local directory to mount
+
+	// Name is the container name. If empty, Start generates a unique
+	// "nodechain-agent-<random>" name. If a container with this name already
+	// exists, Start adopts it instead of replacing it, but only if a
+	// DockerManager created it with the same limits, security options,
+	// network settings and Workspace; otherwise Start fails.
+	Name   string
+	Labels map[string]string // extra labels for the container; the nodechain.* labels cannot be overridden
+
+	// Resource limits; zero means unlimited.
+	CPUs   float64 // --cpus, e.g. 1.5
+	Memory string  // --memory, e.g. "1g"; swap is capped to the same value
+	PIDs   int     // --pids-limit
+
+	ReadOnlyRoot    bool     // read-only root filesystem; /tmp is a tmpfs and /workspace stays writable
+	CapDrop         []string // capabilities to drop, e.g. "ALL"
+	CapAdd          []string // capabilities to add back after CapDrop
+	NoNewPrivileges bool     // block setuid privilege escalation
+	User            string   // "uid:gid" or name to run as; "" uses the image's user
+
+	Network         NetworkMode
+	EgressAllowlist []string // hosts reachable in NetworkAllowlist mode; see EgressProxy
+
+	// Provision, if set, prepares the image before the container is created;
+	// see ProvisionSpec. It is not applied to adopted containers.
+	Provision *ProvisionSpec
+
 	Container string // name or id assigned after start
 	mu        sync.Mutex
 	running   bool
+	named     bool // Name was user-supplied; Stop keeps the container
+	network   string
+	proxy     *EgressProxy
+	runArgs   []string // docker run options, for recreating the container; nil if adopted
+	image     string   // image the container was created from
+	snapshots snapshotStore
 }
 
+// NewDockerManager returns a manager with restrictive defaults: 1 CPU, 1 GiB
+// of memory, 256 processes, a read-only root filesystem, all capabilities
+// dropped, no privilege escalation, uid 1000 and no network. Relax them by
+// setting the fields before Start.
 func NewDockerManager(image, workspace string) *DockerManager {
 	return &DockerManager{
-		Image:     image,
-		Workspace: workspace,
+		Image:           image,
+		Workspace:       workspace,
+		Labels:          map[string]string{},
+		CPUs:            1,
+		Memory:          "1g",
+		PIDs:            256,
+		ReadOnlyRoot:    true,
+		CapDrop:         []string{"ALL"},
+		NoNewPrivileges: true,
+		User:            "1000:1000",
+		Network:         NetworkNone,
 	}
 }
 
-func (m *DockerManager) Start() error {
+func (m *DockerManager) Start(ctx context.Context) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -30,30 +122,221 @@ func (m *DockerManager) Start() error {
 		return nil
 	}
 
-	// Always attempt to remove existing container with this name
-	exec.Command("docker", "rm", "-f", "nodechain-agent").Run()
+	if m.Name == "" && m.Container == "" {
+		m.Name = "nodechain-agent-" + randomSuffix()
+	} else if m.Container == "" {
+		m.named = true
+	}
 
-	// Now run a fresh container
-	cmd := exec.Command(
-		"docker", "run",
+	// Adopt an existing container with this name rather than deleting it
+	if info, err := inspectContainer(m.Name); err == nil {
+		if info.Labels[LabelManaged] != "true" {
+			return fmt.Errorf("container %s was not created by nodechain; refusing to adopt it", m.Name)
+		}
+		if info.Labels[LabelConfig] != m.configHash() {
+			return fmt.Errorf("container %s was created with different security, network or workspace settings; remove it to recreate it", m.Name)
+		}
+		if err := m.resumeProxy(info.Labels[LabelProxy]); err != nil {
+			return err
+		}
+		if info.State != "running" {
+			if out, err := exec.CommandContext(ctx, "docker", "start", m.Name).CombinedOutput(); err != nil {
+				m.closeProxy()
+				return fmt.Errorf("failed to start existing container %s: %s (%v)", m.Name, out, err)
+			}
+		}
+		m.Container = m.Name
+		m.runArgs, m.image = nil, ""
+		m.running = true
+		return nil
+	}
+
+	// Create the workspace ourselves so it is not created root-owned by docker
+	if err := os.MkdirAll(m.Workspace, 0o755); err != nil {
+		return fmt.Errorf("failed to create workspace: %w", err)
+	}
+
+	image := m.Image
+	if m.Provision != nil {
+		var err error
+		if image, err = m.Provision.build(ctx, m.Image, m.Name); err != nil {
+			return err
+		}
+		if err := m.Provision.seedWorkspace(m.Workspace); err != nil {
+			return err
+		}
+	}
+
+	args := []string{
+		"run",
 		"-d",
-		"--name=nodechain-agent",
+		"--name=" + m.Name,
 		"-v", fmt.Sprintf("%s:/workspace", m.Workspace),
-		m.Image,
-		"sleep", "infinity",
-	)
+		"--workdir", "/workspace",
+	}
+	args = append(args, m.securityArgs()...)
+	if m.Provision != nil {
+		args = append(args, m.Provision.envArgs()...)
+	}
 
-	out, err := cmd.CombinedOutput()
+	netArgs, err := m.networkArgs(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to start container: %s (%v)", out, err)
+		return err
 	}
+	args = append(args, netArgs...)
 
-	m.Container = "nodechain-agent"
+	for _, l := range m.labelArgs() {
+		args = append(args, "--label", l)
+	}
+
+	m.runArgs, m.image = args, image
+	if err := m.run(ctx, image); err != nil {
+		m.closeProxy()
+		m.removeNetwork()
+		return err
+	}
+
+	m.Container = m.Name
 	m.running = true
 	return nil
 }
 
-func (m *DockerManager) Stop() error {
+// run creates the container from image with the options Start chose.
+func (m *DockerManager) run(ctx context.Context, image string) error {
+	args := append(append([]string{}, m.runArgs...), image, "sleep", "infinity")
+	if out, err := exec.CommandContext(ctx, "docker", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to start container: %s (%v)", out, err)
+	}
+	return nil
+}
+
+func (m *DockerManager) securityArgs() []string {
+	var args []string
+	if m.CPUs > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(m.CPUs, 'f', -1, 64))
+	}
+	if m.Memory != "" {
+		args = append(args, "--memory", m.Memory, "--memory-swap", m.Memory)
+	}
+	if m.PIDs > 0 {
+		args = append(args, "--pids-limit", strconv.Itoa(m.PIDs))
+	}
+	if m.ReadOnlyRoot {
+		args = append(args, "--read-only", "--tmpfs", "/tmp:rw,nosuid,nodev,size=256m")
+	}
+	for _, c := range m.CapDrop {
+		args = append(args, "--cap-drop", c)
+	}
+	for _, c := range m.CapAdd {
+		args = append(args, "--cap-add", c)
+	}
+	if m.NoNewPrivileges {
+		args = append(args, "--security-opt", "no-new-privileges")
+	}
+	if m.User != "" {
+		// the user may not exist in the image, so give it a writable home
+		args = append(args, "--user", m.User, "-e", "HOME=/workspace")
+	}
+	return args
+}
+
+// networkArgs returns the docker run flags for m.Network. For
+// NetworkAllowlist it creates the internal network and starts the proxy.
+func (m *DockerManager) networkArgs(ctx context.Context) ([]string, error) {
+	switch m.Network {
+	case "", NetworkNone:
+		return []string{"--network", "none"}, nil
+	case NetworkBridge:
+		return []string{"--network", "bridge"}, nil
+	case NetworkAllowlist:
+	default:
+		return nil, fmt.Errorf("unknown network mode %q", m.Network)
+	}
+
+	network := m.Name + "-net"
+	create := []string{"network", "create", "--internal"}
+	for _, l := range m.labelArgs() {
+		create = append(create, "--label", l)
+	}
+	create = append(create, network)
+	if out, err := exec.CommandContext(ctx, "docker", create...).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to create network %s: %s (%v)", network, bytes.TrimSpace(out), err)
+	}
+	m.network = network
+
+	out, err := exec.CommandContext(ctx, "docker", "network", "inspect",
+		"-f", "{{range .IPAM.Config}}{{.Gateway}} {{end}}", network).Output()
+	gateway, _, _ := strings.Cut(strings.TrimSpace(string(out)), " ")
+	if err != nil || gateway == "" {
+		m.removeNetwork()
+		return nil, fmt.Errorf("network %s has no gateway address for the egress proxy", network)
+	}
+
+	m.proxy = NewEgressProxy(m.EgressAllowlist)
+	addr, err := m.proxy.Start(net.JoinHostPort(gateway, "0"))
+	if err != nil {
+		m.proxy = nil
+		m.removeNetwork()
+		return nil, err
+	}
+
+	proxyURL := "http://" + addr
+	args := []string{"--network", network, "--label", LabelProxy + "=" + addr}
+	for _, k := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+		args = append(args, "-e", k+"="+proxyURL)
+	}
+	return append(args, "-e", "NO_PROXY=localhost,127.0.0.1", "-e", "no_proxy=localhost,127.0.0.1"), nil
+}
+
+// resumeProxy restarts the egress proxy for an adopted NetworkAllowlist
+// container on addr, the address its proxy variables point at.
+func (m *DockerManager) resumeProxy(addr string) error {
+	if addr == "" {
+		return nil
+	}
+	m.proxy = NewEgressProxy(m.EgressAllowlist)
+	if _, err := m.proxy.Start(addr); err != nil {
+		m.proxy = nil
+		return fmt.Errorf("failed to restart egress proxy for %s: %w", m.Name, err)
+	}
+	return nil
+}
+
+func (m *DockerManager) closeProxy() {
+	if m.proxy != nil {
+		m.proxy.Close()
+		m.proxy = nil
+	}
+}
+
+func (m *DockerManager) removeNetwork() {
+	if m.network != "" {
+		exec.Command("docker", "network", "rm", m.network).Run()
+		m.network = ""
+	}
+}
+
+func (m *DockerManager) labelArgs() []string {
+	labels := map[string]string{}
+	for k, v := range m.Labels {
+		labels[k] = v
+	}
+	labels[LabelManaged] = "true"
+	labels[LabelOwner] = ownerID()
+	labels[LabelConfig] = m.configHash()
+	if m.named {
+		labels[LabelNamed] = "true"
+	}
+
+	out := make([]string, 0, len(labels))
+	for k, v := range labels {
+		out = append(out, k+"="+v)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (m *DockerManager) Stop(ctx context.Context) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -61,26 +344,442 @@ func (m *DockerManager) Stop() error {
 		return nil
 	}
 
-	exec.Command("docker", "rm", "-f", m.Container).Run()
+	// Containers with a user-supplied name are only stopped, so a later
+	// Start can adopt them; generated ones are removed.
+	if m.named {
+		exec.CommandContext(ctx, "docker", "stop", m.Container).Run()
+	} else {
+		exec.CommandContext(ctx, "docker", "rm", "-f", m.Container).Run()
+		m.removeNetwork()
+	}
+	m.closeProxy()
+	for _, snap := range m.snapshots.clear() {
+		if snap.Image != "" {
+			exec.Command("docker", "rmi", "-f", snap.Image).Run()
+		}
+	}
 	m.running = false
 	return nil
 }
 
-func (m *DockerManager) Run(cmdStr string) (string, string, error) {
+// Run is Exec for scripted use: commands that exit non-zero or time out
+// fail with *ExitError.
+func (m *DockerManager) Run(ctx context.Context, cmdStr string, opts ExecOptions) (ExecResult, error) {
+	res, err := m.Exec(ctx, cmdStr, opts)
+	if err == nil && (res.ExitCode != 0 || res.TimedOut) {
+		err = &ExitError{Result: res}
+	}
+	return res, err
+}
+
+// execMarkerEnv tags every process started by Exec so the tree can be found
+// and killed inside the container; killing the docker CLI does not stop it.
+const execMarkerEnv = "NODECHAIN_EXEC"
+
+// Exec is safe to call concurrently; each call is a separate docker exec.
+func (m *DockerManager) Exec(ctx context.Context, cmdStr string, opts ExecOptions) (ExecResult, error) {
+	m.mu.Lock()
+	running, container := m.running, m.Container
+	m.mu.Unlock()
+
+	if !running {
+		return ExecResult{}, fmt.Errorf("docker container not running")
+	}
+
+	return dockerExec(ctx, container, nil, []string{"bash", "-lc", cmdStr}, opts)
+}
+
+// dockerExec runs argv in container with extra docker exec flags, applying
+// opts and killing the process tree on timeout or cancellation.
+func dockerExec(ctx context.Context, container string, flags, argv []string, opts ExecOptions) (ExecResult, error) {
+	runCtx := ctx
+	if t := opts.timeout(); t > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, t)
+		defer cancel()
+	}
+
+	marker := randomSuffix()
+	args := append([]string{"exec", "-e", execMarkerEnv + "=" + marker}, flags...)
+	args = append(append(args, container), argv...)
+	cmd := exec.CommandContext(runCtx, "docker", args...)
+	cmd.WaitDelay = time.Second
+
+	stdout, stderr := newCappedBuffer(opts.maxOutput()), newCappedBuffer(opts.maxOutput())
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	res := ExecResult{
+		Stdout:    stdout.String(),
+		Stderr:    stderr.String(),
+		Truncated: stdout.Truncated() || stderr.Truncated(),
+	}
+
+	if runCtx.Err() != nil {
+		killExec(container, marker)
+		res.ExitCode = -1
+		if ctx.Err() != nil {
+			return res, ctx.Err()
+		}
+		res.TimedOut = true
+		return res, nil
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		res.ExitCode = exitErr.ExitCode()
+		err = nil
+	}
+	return res, err
+}
+
+// Command returns an unstarted docker exec -i of argv, for long-lived
+// processes such as ShellSession's shell. Cancelling ctx kills the process
+// tree inside the container.
+func (m *DockerManager) Command(ctx context.Context, argv ...string) (*exec.Cmd, error) {
+	m.mu.Lock()
+	running, container := m.running, m.Container
+	m.mu.Unlock()
+
+	if !running {
+		return nil, fmt.Errorf("docker container not running")
+	}
+
+	marker := randomSuffix()
+	args := append([]string{"exec", "-i", "-e", execMarkerEnv + "=" + marker, container}, argv...)
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Cancel = func() error {
+		killExec(container, marker)
+		return cmd.Process.Kill()
+	}
+	cmd.WaitDelay = time.Second
+	return cmd, nil
+}
+
+// killExec kills every process in container carrying marker in its
+// environment.
+func killExec(container, marker string) {
+	script := `for p in /proc/[0-9]*; do
+	if tr '\0' '\n' 2>/dev/null <"$p/environ" | grep -qx "$1"; then kill -9 "${p#/proc/}" 2>/dev/null; fi
+done`
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	exec.CommandContext(ctx, "docker", "exec", container,
+		"sh", "-c", script, "sh", execMarkerEnv+"="+marker).Run()
+}
+
+// CopyIn copies a host file or directory into the container. Paths under
+// /workspace are written through the bind mount, so they keep the host
+// user's ownership; others go through docker cp.
+func (m *DockerManager) CopyIn(ctx context.Context, hostPath, sandboxPath string) error {
+	if dst, ok, err := m.workspaceHostPath(sandboxPath); ok || err != nil {
+		if err != nil {
+			return err
+		}
+		return copyPath(hostPath, dst)
+	}
+	return m.copy(ctx, hostPath, m.containerPath(sandboxPath))
+}
+
+// CopyOut copies a file or directory from the container to the host.
+func (m *DockerManager) CopyOut(ctx context.Context, sandboxPath, hostPath string) error {
+	if src, ok, err := m.workspaceHostPath(sandboxPath); ok || err != nil {
+		if err != nil {
+			return err
+		}
+		return copyPath(src, hostPath)
+	}
+	return m.copy(ctx, m.containerPath(sandboxPath), hostPath)
+}
+
+// workspaceHostPath maps a container path under /workspace onto Workspace.
+// ok is false for paths elsewhere in the container.
+func (m *DockerManager) workspaceHostPath(p string) (string, bool, error) {
+	m.mu.Lock()
+	running, workspace := m.running, m.Workspace
+	m.mu.Unlock()
+
+	if !path.IsAbs(p) {
+		p = path.Join("/workspace", p)
+	}
+	p = path.Clean(p)
+	if workspace == "" || (p != "/workspace" && !strings.HasPrefix(p, "/workspace/")) {
+		return "", false, nil
+	}
+	if !running {
+		return "", true, fmt.Errorf("docker container not running")
+	}
+	host, err := resolveIn(workspace, p)
+	return host, true, err
+}
+
+func (m *DockerManager) containerPath(p string) string {
+	if !path.IsAbs(p) {
+		p = path.Join("/workspace", p)
+	}
+	return m.Container + ":" + p
+}
+
+func (m *DockerManager) copy(ctx context.Context, src, dst string) error {
+	m.mu.Lock()
+	running := m.running
+	m.mu.Unlock()
+	if !running {
+		return fmt.Errorf("docker container not running")
+	}
+
+	if out, err := exec.CommandContext(ctx, "docker", "cp", src, dst).CombinedOutput(); err != nil {
+		return fmt.Errorf("docker cp %s %s: %s (%v)", src, dst, bytes.TrimSpace(out), err)
+	}
+	return nil
+}
+
+// SnapshotImageRepo is the repository DockerManager snapshots are committed
+// in.
+const SnapshotImageRepo = "nodechain-snapshot"
+
+// Snapshot saves the workspace and, unless the root filesystem is
+// read-only, commits the container to an image, so Restore also undoes
+// package installs and other changes outside /workspace. The container is
+// paused while it is committed. Snapshots of an adopted container cover the
+// workspace only.
+func (m *DockerManager) Snapshot(ctx context.Context, label string) (SandboxSnapshot, error) {
+	m.mu.Lock()
+	running, container := m.running, m.Container
+	commit := m.runArgs != nil && !m.ReadOnlyRoot
+	m.mu.Unlock()
+
+	if !running {
+		return SandboxSnapshot{}, fmt.Errorf("container not running")
+	}
+
+	snap, err := m.snapshots.save(m.Workspace, label, func(snap *SandboxSnapshot) error {
+		if !commit {
+			return nil
+		}
+		image := SnapshotImageRepo + ":" + container + "-" + snap.ID
+		cmd := exec.CommandContext(ctx, "docker", "commit",
+			"--change", "LABEL "+LabelManaged+"=true",
+			container, image)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("committing container: %s (%v)", out, err)
+		}
+		snap.Image = image
+		return nil
+	})
+	if err != nil && snap.Image != "" {
+		exec.Command("docker", "rmi", "-f", snap.Image).Run()
+	}
+	return snap, err
+}
+
+// Restore rolls the sandbox back to a snapshot. The container is recreated
+// from the snapshot's image, or from the image it was started from, which
+// also kills running commands and clears /tmp; ShellSessions and
+// PythonSessions on it start afresh on their next call. An adopted
+// container keeps running and only its workspace is restored.
+//
+// The snapshot is extracted before anything is changed, and the old
+// container is kept aside until the new one runs, so a failed Restore
+// leaves the sandbox as it was. If even putting it back fails, the manager
+// is left stopped and must be started again.
+func (m *DockerManager) Restore(ctx context.Context, ref string) error {
+	snap, err := m.snapshots.find(ref)
+	if err != nil {
+		return err
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	if !m.running {
-		return "", "", fmt.Errorf("docker container not running")
+		return fmt.Errorf("container not running")
+	}
+	if m.runArgs == nil {
+		return restoreDir(m.Workspace, snap.archive)
 	}
 
-	args := append([]string{"exec", m.Container, "bash", "-lc"}, cmdStr)
-	cmd := exec.Command("docker", args...)
+	ws, err := stageRestore(m.Workspace, snap.archive)
+	if err != nil {
+		return fmt.Errorf("restoring workspace: %w", err)
+	}
+	defer ws.finish()
 
-	var out, errOut bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &errOut
+	// stop the old container, so nothing writes to the workspace during the
+	// swap, and keep it under another name in case the new one fails
+	aside := m.Container + "-restoring"
+	exec.Command("docker", "rm", "-f", aside).Run()
+	if out, err := exec.CommandContext(ctx, "docker", "stop", "-t", "0", m.Container).CombinedOutput(); err != nil {
+		return fmt.Errorf("stopping container: %s (%v)", out, err)
+	}
+	if out, err := exec.CommandContext(ctx, "docker", "rename", m.Container, aside).CombinedOutput(); err != nil {
+		m.putBack(m.Container)
+		return fmt.Errorf("renaming container: %s (%v)", out, err)
+	}
 
-	err := cmd.Run()
-	return out.String(), errOut.String(), err
+	if err := ws.swap(); err != nil {
+		m.putBack(aside)
+		return fmt.Errorf("restoring workspace: %w", err)
+	}
+	image := snap.Image
+	if image == "" {
+		image = m.image
+	}
+	if err := m.run(ctx, image); err != nil {
+		if uerr := ws.undo(); uerr != nil {
+			err = errors.Join(err, fmt.Errorf("putting the workspace back: %w", uerr))
+		}
+		m.putBack(aside)
+		return err
+	}
+	exec.Command("docker", "rm", "-f", aside).Run()
+	return nil
+}
+
+// putBack restarts the container a failed Restore set aside under name.
+// If that fails the manager is marked stopped, so the next Start creates a
+// fresh container instead of Exec failing against a missing one. m.mu must
+// be held.
+func (m *DockerManager) putBack(name string) {
+	if name != m.Container {
+		exec.Command("docker", "rm", "-f", m.Container).Run()
+		if exec.Command("docker", "rename", name, m.Container).Run() != nil {
+			m.markStopped()
+			return
+		}
+	}
+	if exec.Command("docker", "start", m.Container).Run() != nil {
+		m.markStopped()
+	}
+}
+
+// markStopped releases what Stop would for a container that is gone.
+// m.mu must be held.
+func (m *DockerManager) markStopped() {
+	exec.Command("docker", "rm", "-f", m.Container, m.Container+"-restoring").Run()
+	m.closeProxy()
+	m.removeNetwork()
+	m.running = false
+}
+
+func (m *DockerManager) DeleteSnapshot(ctx context.Context, ref string) error {
+	snap, err := m.snapshots.remove(ref)
+	if err != nil {
+		return err
+	}
+	if snap.Image != "" {
+		if out, err := exec.CommandContext(ctx, "docker", "rmi", "-f", snap.Image).CombinedOutput(); err != nil {
+			return fmt.Errorf("removing snapshot image: %s (%v)", out, err)
+		}
+	}
+	return nil
+}
+
+func (m *DockerManager) Snapshots() []SandboxSnapshot {
+	return m.snapshots.list()
+}
+
+// CleanupOrphanedContainers removes containers labelled by a DockerManager
+// on this host whose owning process has exited. Containers started with a
+// user-supplied Name are kept, since they are meant to be adopted later.
+// It returns the names of the removed containers.
+func CleanupOrphanedContainers() ([]string, error) {
+	out, err := exec.Command(
+		"docker", "ps", "-a",
+		"--filter", "label="+LabelManaged+"=true",
+		"--format", `{{.Names}}|{{.Label "`+LabelOwner+`"}}|{{.Label "`+LabelNamed+`"}}`,
+	).Output()
+	if err != nil {
+		return nil, fmt.Errorf("listing containers: %w", err)
+	}
+
+	host, _ := os.Hostname()
+	var removed []string
+	var errs []error
+
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		parts := strings.Split(line, "|")
+		if len(parts) != 3 || parts[2] == "true" {
+			continue
+		}
+		name, owner := parts[0], parts[1]
+
+		ownerHost, pidStr, ok := strings.Cut(owner, ":")
+		if !ok || ownerHost != host {
+			continue
+		}
+		pid, err := strconv.Atoi(pidStr)
+		if err != nil || processAlive(pid) {
+			continue
+		}
+
+		if out, err := exec.Command("docker", "rm", "-f", name).CombinedOutput(); err != nil {
+			errs = append(errs, fmt.Errorf("removing %s: %s (%v)", name, out, err))
+			continue
+		}
+		removed = append(removed, name)
+	}
+
+	// allowlist networks left behind by removed containers
+	if len(removed) > 0 {
+		exec.Command("docker", "network", "prune", "-f", "--filter", "label="+LabelManaged+"=true").Run()
+	}
+	return removed, errors.Join(errs...)
+}
+
+// configHash identifies the settings a container is created with that
+// decide what it can reach: limits, security options, network and the
+// mounted workspace. Start only adopts containers labelled with the same.
+func (m *DockerManager) configHash() string {
+	network := m.Network
+	if network == "" {
+		network = NetworkNone
+	}
+	allow := slices.Sorted(slices.Values(m.EgressAllowlist))
+	workspace, err := filepath.Abs(m.Workspace)
+	if err != nil {
+		workspace = m.Workspace
+	}
+
+	h := sha256.New()
+	for _, part := range [][]string{m.securityArgs(), {string(network)}, allow, {workspace}} {
+		for _, s := range part {
+			h.Write([]byte(s))
+			h.Write([]byte{0})
+		}
+		h.Write([]byte{1})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+type containerInfo struct {
+	State  string // "running", "exited", ...
+	Labels map[string]string
+}
+
+// inspectContainer returns the container's state and labels, or an error if
+// it does not exist.
+func inspectContainer(name string) (containerInfo, error) {
+	out, err := exec.Command("docker", "inspect", "-f", "{{.State.Status}} {{json .Config.Labels}}", name).Output()
+	if err != nil {
+		return containerInfo{}, err
+	}
+	state, labels, _ := strings.Cut(strings.TrimSpace(string(out)), " ")
+	info := containerInfo{State: state}
+	if err := json.Unmarshal([]byte(labels), &info.Labels); err != nil {
+		return containerInfo{}, fmt.Errorf("inspecting container %s: %w", name, err)
+	}
+	return info, nil
+}
+
+func ownerID() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
+func randomSuffix() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
@@ -0,0 +1,476 @@
+//go:build unix
+
+package nodechain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeDocker puts a docker script on PATH that logs each invocation and
+// fails `docker run` while failRun exists. It returns the log path. Other
+// replies are set with fakeDockerReply.
+func fakeDocker(t *testing.T) (log, failRun string) {
+	t.Helper()
+	bin := t.TempDir()
+	log = filepath.Join(bin, "log")
+	failRun = filepath.Join(bin, "fail-run")
+	script := `#!/bin/sh
+echo "$*" >> "` + log + `"
+if [ "$1" = run ] && [ -e "` + failRun + `" ]; then
+	echo "run failed" >&2
+	exit 1
+fi
+if [ -e "` + bin + `/$1.out" ]; then
+	cat "` + bin + `/$1.out"
+fi
+if [ -e "` + bin + `/$1.fail" ]; then
+	exit 1
+fi
+`
+	if err := os.WriteFile(filepath.Join(bin, "docker"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return log, failRun
+}
+
+// fakeDockerReply makes the fake docker print out for the subcommand cmd
+// ("inspect", "ps", "network", ...) and, if fail is set, exit 1.
+func fakeDockerReply(t *testing.T, log, cmd, out string, fail bool) {
+	t.Helper()
+	bin := filepath.Dir(log)
+	if err := os.WriteFile(filepath.Join(bin, cmd+".out"), []byte(out), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(bin, cmd+".fail"))
+	if fail {
+		if err := os.WriteFile(filepath.Join(bin, cmd+".fail"), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func dockerCalls(t *testing.T, log string) []string {
+	t.Helper()
+	data, err := os.ReadFile(log)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	os.Remove(log)
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func newFakeDockerManager(t *testing.T) *DockerManager {
+	t.Helper()
+	m := NewDockerManager("img", t.TempDir())
+	m.Container = "c1"
+	m.running = true
+	m.runArgs = []string{"run", "-d", "--name", "c1"}
+	m.image = "img"
+	t.Cleanup(func() { m.snapshots.clear() })
+	return m
+}
+
+func TestDockerManagerRestore(t *testing.T) {
+	log, _ := fakeDocker(t)
+	m := newFakeDockerManager(t)
+	writeTree(t, m.Workspace, map[string]string{"saved.txt": "saved"})
+	if _, err := m.Snapshot(context.Background(), "before"); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(m.Workspace, "saved.txt"))
+	writeTree(t, m.Workspace, map[string]string{"broken.txt": "broken"})
+	dockerCalls(t, log)
+
+	if err := m.Restore(context.Background(), "before"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTree(t, m.Workspace); !sameTree(got, map[string]string{"saved.txt": "saved"}) {
+		t.Fatalf("workspace after restore: %v", got)
+	}
+	want := []string{
+		"rm -f c1-restoring",
+		"stop -t 0 c1",
+		"rename c1 c1-restoring",
+		"run -d --name c1 img sleep infinity",
+		"rm -f c1-restoring",
+	}
+	if got := dockerCalls(t, log); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("docker calls:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if !m.running {
+		t.Fatal("manager not running after restore")
+	}
+}
+
+func TestDockerManagerRestoreRunFails(t *testing.T) {
+	log, failRun := fakeDocker(t)
+	m := newFakeDockerManager(t)
+	writeTree(t, m.Workspace, map[string]string{"saved.txt": "saved"})
+	if _, err := m.Snapshot(context.Background(), "before"); err != nil {
+		t.Fatal(err)
+	}
+	live := map[string]string{"live.txt": "live"}
+	os.Remove(filepath.Join(m.Workspace, "saved.txt"))
+	writeTree(t, m.Workspace, live)
+	os.WriteFile(failRun, nil, 0o644)
+	dockerCalls(t, log)
+
+	if err := m.Restore(context.Background(), "before"); err == nil {
+		t.Fatal("restore succeeded although docker run failed")
+	}
+	if got := readTree(t, m.Workspace); !sameTree(got, live) {
+		t.Fatalf("workspace not put back: %v", got)
+	}
+	calls := dockerCalls(t, log)
+	tail := strings.Join(calls[len(calls)-3:], "\n")
+	if want := "rm -f c1\nrename c1-restoring c1\nstart c1"; tail != want {
+		t.Fatalf("old container not put back; calls:\n%s", strings.Join(calls, "\n"))
+	}
+	if !m.running {
+		t.Fatal("manager marked stopped although the old container was put back")
+	}
+}
+
+func TestDockerManagerRestoreCorruptArchive(t *testing.T) {
+	log, _ := fakeDocker(t)
+	m := newFakeDockerManager(t)
+	snap, err := m.Snapshot(context.Background(), "before")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(snap.archive, []byte("not a tarball"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	live := map[string]string{"live.txt": "live"}
+	writeTree(t, m.Workspace, live)
+	dockerCalls(t, log)
+
+	if err := m.Restore(context.Background(), "before"); err == nil {
+		t.Fatal("restore of a corrupt archive succeeded")
+	}
+	if got := readTree(t, m.Workspace); !sameTree(got, live) {
+		t.Fatalf("workspace changed: %v", got)
+	}
+	if calls := dockerCalls(t, log); len(calls) != 1 || calls[0] != "" {
+		t.Fatalf("container touched before the archive was read: %v", calls)
+	}
+}
+
+// inspectReply is what `docker inspect` prints for a container with labels.
+func inspectReply(t *testing.T, state string, labels map[string]string) string {
+	t.Helper()
+	data, err := json.Marshal(labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state + " " + string(data) + "\n"
+}
+
+func TestDockerManagerStartCreatesLabelledContainer(t *testing.T) {
+	log, _ := fakeDocker(t)
+	fakeDockerReply(t, log, "inspect", "", true)
+
+	m := NewDockerManager("img", t.TempDir())
+	m.Labels = map[string]string{"team": "a", LabelManaged: "false"}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(m.Container, "nodechain-agent-") {
+		t.Fatalf("generated name %q", m.Container)
+	}
+
+	calls := dockerCalls(t, log)
+	run := calls[len(calls)-1]
+	for _, want := range []string{
+		"--name=" + m.Container,
+		"--label " + LabelManaged + "=true",
+		"--label " + LabelConfig + "=" + m.configHash(),
+		"--label " + LabelOwner + "=" + ownerID(),
+		"--label team=a",
+	} {
+		if !strings.Contains(run, want) {
+			t.Errorf("docker %s\nlacks %q", run, want)
+		}
+	}
+	if strings.Contains(run, LabelNamed) {
+		t.Errorf("generated name labelled as user-supplied: %s", run)
+	}
+
+	if err := m.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if calls := dockerCalls(t, log); !slices.Contains(calls, "rm -f "+m.Container) {
+		t.Fatalf("generated container not removed on Stop: %v", calls)
+	}
+}
+
+func TestDockerManagerStartAdoption(t *testing.T) {
+	workspace := t.TempDir()
+	want := NewDockerManager("img", workspace).configHash()
+	relaxed := NewDockerManager("img", workspace)
+	relaxed.Network = NetworkBridge
+
+	tests := []struct {
+		name    string
+		state   string
+		labels  map[string]string
+		wantErr string
+		calls   []string // after the inspect
+	}{
+		{
+			name:   "running",
+			state:  "running",
+			labels: map[string]string{LabelManaged: "true", LabelConfig: want},
+		},
+		{
+			name:   "stopped",
+			state:  "exited",
+			labels: map[string]string{LabelManaged: "true", LabelConfig: want},
+			calls:  []string{"start box"},
+		},
+		{
+			name:    "not created by nodechain",
+			state:   "running",
+			labels:  map[string]string{"other": "label"},
+			wantErr: "not created by nodechain",
+		},
+		{
+			name:    "no labels",
+			state:   "running",
+			wantErr: "not created by nodechain",
+		},
+		{
+			name:    "different settings",
+			state:   "running",
+			labels:  map[string]string{LabelManaged: "true", LabelConfig: relaxed.configHash()},
+			wantErr: "different security",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log, _ := fakeDocker(t)
+			fakeDockerReply(t, log, "inspect", inspectReply(t, tt.state, tt.labels), false)
+
+			m := NewDockerManager("img", workspace)
+			m.Name = "box"
+			err := m.Start(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				if m.running {
+					t.Fatal("manager running after a refused adoption")
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			calls := dockerCalls(t, log)
+			if calls[0] != "inspect -f {{.State.Status}} {{json .Config.Labels}} box" {
+				t.Fatalf("first call %q", calls[0])
+			}
+			if got := calls[1:]; strings.Join(got, "\n") != strings.Join(tt.calls, "\n") {
+				t.Fatalf("calls after inspect: %v, want %v", got, tt.calls)
+			}
+		})
+	}
+}
+
+func TestDockerManagerConfigHash(t *testing.T) {
+	workspace := t.TempDir()
+	base := NewDockerManager("img", workspace).configHash()
+
+	tests := []struct {
+		name   string
+		change func(m *DockerManager)
+		same   bool
+	}{
+		{"image", func(m *DockerManager) { m.Image = "other" }, true},
+		{"labels", func(m *DockerManager) { m.Labels["x"] = "y" }, true},
+		{"zero network is none", func(m *DockerManager) { m.Network = "" }, true},
+		{"memory", func(m *DockerManager) { m.Memory = "2g" }, false},
+		{"user", func(m *DockerManager) { m.User = "" }, false},
+		{"capabilities", func(m *DockerManager) { m.CapAdd = []string{"NET_RAW"} }, false},
+		{"network", func(m *DockerManager) { m.Network = NetworkBridge }, false},
+		{"allowlist", func(m *DockerManager) { m.EgressAllowlist = []string{"example.com"} }, false},
+		{"workspace", func(m *DockerManager) { m.Workspace = t.TempDir() }, false},
+	}
+	for _, tt := range tests {
+		m := NewDockerManager("img", workspace)
+		tt.change(m)
+		if got := m.configHash(); (got == base) != tt.same {
+			t.Errorf("%s: hash %s, base %s; want same: %v", tt.name, got, base, tt.same)
+		}
+	}
+}
+
+func TestCleanupOrphanedContainers(t *testing.T) {
+	log, _ := fakeDocker(t)
+	host, _ := os.Hostname()
+	dead := fmt.Sprintf("%s:%d", host, 1<<30)
+	fakeDockerReply(t, log, "ps", strings.Join([]string{
+		"orphan|" + dead + "|",
+		"live|" + ownerID() + "|",
+		"named|" + dead + "|true",
+		"elsewhere|other-host:1|",
+		"unowned||",
+	}, "\n")+"\n", false)
+
+	removed, err := CleanupOrphanedContainers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(removed, []string{"orphan"}) {
+		t.Fatalf("removed %v, want [orphan]", removed)
+	}
+
+	calls := dockerCalls(t, log)
+	if !strings.Contains(calls[0], "--filter label="+LabelManaged+"=true") {
+		t.Fatalf("listed containers without the managed filter: %s", calls[0])
+	}
+	want := []string{"rm -f orphan", "network prune -f --filter label=" + LabelManaged + "=true"}
+	if !slices.Equal(calls[1:], want) {
+		t.Fatalf("calls %v, want %v", calls[1:], want)
+	}
+}
+
+func TestDockerManagerSecurityFlags(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(m *DockerManager)
+		want   []string
+	}{
+		{
+			name:   "defaults",
+			change: func(m *DockerManager) {},
+			want: []string{
+				"--cpus 1", "--memory 1g --memory-swap 1g", "--pids-limit 256",
+				"--read-only --tmpfs /tmp:rw,nosuid,nodev,size=256m",
+				"--cap-drop ALL", "--security-opt no-new-privileges",
+				"--user 1000:1000 -e HOME=/workspace", "--network none",
+			},
+		},
+		{
+			name: "relaxed",
+			change: func(m *DockerManager) {
+				m.CPUs, m.Memory, m.PIDs = 0, "", 0
+				m.ReadOnlyRoot, m.NoNewPrivileges, m.User = false, false, ""
+				m.CapDrop, m.CapAdd = []string{"ALL"}, []string{"CHOWN"}
+				m.Network = NetworkBridge
+			},
+			want: []string{"--cap-drop ALL --cap-add CHOWN", "--network bridge"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log, _ := fakeDocker(t)
+			fakeDockerReply(t, log, "inspect", "", true)
+
+			m := NewDockerManager("img", t.TempDir())
+			tt.change(m)
+			if err := m.Start(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			defer m.Stop(context.Background())
+
+			calls := dockerCalls(t, log)
+			run := calls[len(calls)-1]
+			if !strings.HasPrefix(run, "run -d ") || !strings.HasSuffix(run, " img sleep infinity") {
+				t.Fatalf("last call %q", run)
+			}
+			for _, flag := range tt.want {
+				if !strings.Contains(run, " "+flag+" ") {
+					t.Errorf("docker %s\nlacks %q", run, flag)
+				}
+			}
+			all := []string{"--cpus", "--memory", "--pids-limit", "--read-only", "--cap-drop", "--cap-add", "no-new-privileges", "--user"}
+			for _, flag := range all {
+				if strings.Contains(run, flag) != strings.Contains(strings.Join(tt.want, " "), flag) {
+					t.Errorf("docker %s\nhas %q: %v", run, flag, strings.Contains(run, flag))
+				}
+			}
+		})
+	}
+}
+
+func TestDockerManagerAllowlistNetwork(t *testing.T) {
+	log, _ := fakeDocker(t)
+	fakeDockerReply(t, log, "inspect", "", true)
+	fakeDockerReply(t, log, "network", "127.0.0.1 \n", false)
+
+	m := NewDockerManager("img", t.TempDir())
+	m.Name = "box"
+	m.Network = NetworkAllowlist
+	m.EgressAllowlist = []string{"api.example.com"}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(context.Background())
+
+	if m.proxy == nil || !m.proxy.Allowed("api.example.com:443") || m.proxy.Allowed("example.com:443") {
+		t.Fatal("proxy not started with the allowlist")
+	}
+	addr := m.proxy.ln.Addr().String()
+	if !strings.HasPrefix(addr, "127.0.0.1:") {
+		t.Fatalf("proxy on %s, not the network gateway", addr)
+	}
+
+	calls := dockerCalls(t, log)
+	create := calls[1]
+	if !strings.HasPrefix(create, "network create --internal ") || !strings.HasSuffix(create, " box-net") ||
+		!strings.Contains(create, "--label "+LabelManaged+"=true") {
+		t.Fatalf("network created with %q", create)
+	}
+	run := calls[len(calls)-1]
+	for _, flag := range []string{
+		"--network box-net",
+		"--label " + LabelProxy + "=" + addr,
+		"-e HTTPS_PROXY=http://" + addr,
+		"-e https_proxy=http://" + addr,
+		"-e HTTP_PROXY=http://" + addr,
+		"-e NO_PROXY=localhost,127.0.0.1",
+	} {
+		if !strings.Contains(run, " "+flag+" ") {
+			t.Errorf("docker %s\nlacks %q", run, flag)
+		}
+	}
+}
+
+func TestDockerManagerAllowlistWithoutGateway(t *testing.T) {
+	log, _ := fakeDocker(t)
+	fakeDockerReply(t, log, "inspect", "", true)
+	fakeDockerReply(t, log, "network", "", false)
+
+	m := NewDockerManager("img", t.TempDir())
+	m.Name = "box"
+	m.Network = NetworkAllowlist
+	if err := m.Start(context.Background()); err == nil || !strings.Contains(err.Error(), "no gateway") {
+		t.Fatalf("err = %v", err)
+	}
+	calls := dockerCalls(t, log)
+	if calls[len(calls)-1] != "network rm box-net" || slices.ContainsFunc(calls, func(c string) bool { return strings.HasPrefix(c, "run ") }) {
+		t.Fatalf("calls %v", calls)
+	}
+	if m.proxy != nil || m.running {
+		t.Fatal("proxy or container left behind")
+	}
+}
+
+func TestDockerManagerUnknownNetwork(t *testing.T) {
+	log, _ := fakeDocker(t)
+	fakeDockerReply(t, log, "inspect", "", true)
+
+	m := NewDockerManager("img", t.TempDir())
+	m.Network = "host"
+	if err := m.Start(context.Background()); err == nil || !strings.Contains(err.Error(), "unknown network mode") {
+		t.Fatalf("err = %v", err)
+	}
+}
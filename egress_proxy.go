@@ -0,0 +1,186 @@
+package nodechain
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EgressProxy is an HTTP proxy (plain requests and CONNECT tunnels) that
+// only forwards to allowlisted hosts. DockerManager runs one for
+// NetworkAllowlist containers, which have no other route out.
+//
+// Allow entries are host names, optionally with a port. "*.example.com"
+// matches subdomains of example.com but not example.com itself. Entries
+// without a port allow ports 80 and 443 only.
+type EgressProxy struct {
+	Allow []string
+
+	mu  sync.Mutex
+	ln  net.Listener
+	srv *http.Server
+}
+
+func NewEgressProxy(allow []string) *EgressProxy {
+	return &EgressProxy{Allow: allow}
+}
+
+// Start listens on addr ("host:port"; port 0 picks one) and serves in the
+// background. It returns the address actually bound.
+func (p *EgressProxy) Start(addr string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.ln != nil {
+		return p.ln.Addr().String(), nil
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", fmt.Errorf("egress proxy: %w", err)
+	}
+	p.ln = ln
+	p.srv = &http.Server{Handler: p, ReadHeaderTimeout: 10 * time.Second}
+	go p.srv.Serve(ln)
+	return ln.Addr().String(), nil
+}
+
+func (p *EgressProxy) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.srv == nil {
+		return nil
+	}
+	err := p.srv.Close()
+	p.srv, p.ln = nil, nil
+	return err
+}
+
+// Allowed reports whether hostport ("host" or "host:port") may be reached.
+func (p *EgressProxy) Allowed(hostport string) bool {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host, port = hostport, "80"
+	}
+	host = normaliseHost(host)
+
+	for _, entry := range p.Allow {
+		pattern, allowPort, err := net.SplitHostPort(entry)
+		if err != nil {
+			pattern, allowPort = entry, ""
+		}
+		if allowPort == "" && port != "80" && port != "443" {
+			continue
+		}
+		if allowPort != "" && allowPort != port {
+			continue
+		}
+
+		pattern = normaliseHost(pattern)
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// normaliseHost lower-cases a host and drops IPv6 brackets and a trailing
+// dot, so "[::1]" matches "::1" and "Example.COM." matches "example.com".
+func normaliseHost(host string) string {
+	host = strings.TrimSuffix(host, ".")
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return strings.ToLower(host)
+}
+
+func (p *EgressProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+		return
+	}
+
+	if r.URL.Host == "" {
+		http.Error(w, "egress proxy: absolute URL required", http.StatusBadRequest)
+		return
+	}
+	target := r.URL.Host
+	if r.URL.Port() == "" {
+		target = net.JoinHostPort(r.URL.Hostname(), "80")
+	}
+	if r.URL.Scheme != "http" || !p.Allowed(target) {
+		http.Error(w, fmt.Sprintf("egress proxy: %s is not allowlisted", r.URL.Host), http.StatusForbidden)
+		return
+	}
+
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	out.Header.Del("Proxy-Connection")
+	out.Header.Del("Proxy-Authorization")
+
+	resp, err := egressTransport.RoundTrip(out)
+	if err != nil {
+		http.Error(w, "egress proxy: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for k, vs := range resp.Header {
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// egressTransport ignores the host's own proxy settings.
+var egressTransport = &http.Transport{
+	Proxy:                 nil,
+	DialContext:           (&net.Dialer{Timeout: 30 * time.Second}).DialContext,
+	ResponseHeaderTimeout: 60 * time.Second,
+}
+
+func (p *EgressProxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	if !p.Allowed(r.Host) {
+		http.Error(w, fmt.Sprintf("egress proxy: %s is not allowlisted", r.Host), http.StatusForbidden)
+		return
+	}
+
+	upstream, err := net.DialTimeout("tcp", r.Host, 30*time.Second)
+	if err != nil {
+		http.Error(w, "egress proxy: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "egress proxy: hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buf, err := hj.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+
+	go func() {
+		// bytes the client sent after the CONNECT headers
+		if n := buf.Reader.Buffered(); n > 0 {
+			b, _ := buf.Reader.Peek(n)
+			upstream.Write(b)
+		}
+		io.Copy(upstream, client)
+		upstream.Close()
+	}()
+	io.Copy(client, upstream)
+	client.Close()
+}
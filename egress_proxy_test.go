@@ -0,0 +1,96 @@
+package nodechain
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEgressProxyAllowed(t *testing.T) {
+	p := NewEgressProxy([]string{
+		"api.example.com",
+		"*.example.org",
+		"pypi.org:8443",
+		"Mixed.Case.COM",
+		"10.0.0.5",
+		"[::1]",
+		"[2001:db8::1]:8080",
+	})
+
+	tests := []struct {
+		hostport string
+		want     bool
+	}{
+		// exact host
+		{"api.example.com:443", true},
+		{"api.example.com:80", true},
+		{"api.example.com", true}, // no port means 80
+		{"example.com:443", false},
+		{"other.api.example.com:443", false},
+		{"api.example.com.evil.net:443", false},
+		{"api.example.com.:443", true}, // fully qualified
+
+		// wildcard
+		{"files.example.org:443", true},
+		{"a.b.example.org:443", true},
+		{"example.org:443", false},
+		{"evil-example.org:443", false},
+		{"example.org.evil.net:443", false},
+
+		// ports
+		{"api.example.com:22", false},
+		{"api.example.com:8443", false},
+		{"pypi.org:8443", true},
+		{"pypi.org:443", false},
+		{"pypi.org", false},
+
+		// case
+		{"API.Example.Com:443", true},
+		{"mixed.case.com:443", true},
+		{"FILES.EXAMPLE.ORG:443", true},
+
+		// IP literals
+		{"10.0.0.5:443", true},
+		{"10.0.0.6:443", false},
+		{"010.0.0.5:443", false},
+		{"[::1]:443", true},
+		{"[::1]:22", false},
+		{"[2001:db8::1]:8080", true},
+		{"[2001:db8::1]:443", false},
+		{"127.0.0.1:443", false},
+	}
+	for _, tt := range tests {
+		if got := p.Allowed(tt.hostport); got != tt.want {
+			t.Errorf("Allowed(%q) = %v, want %v", tt.hostport, got, tt.want)
+		}
+	}
+
+	if NewEgressProxy(nil).Allowed("example.com:443") {
+		t.Error("an empty allowlist allowed a host")
+	}
+}
+
+func TestEgressProxyRefusesUnlistedHosts(t *testing.T) {
+	p := NewEgressProxy([]string{"api.example.com"})
+
+	tests := []struct {
+		method, target string
+	}{
+		{http.MethodConnect, "evil.net:443"},
+		{http.MethodGet, "http://evil.net/"},
+		{http.MethodGet, "http://api.example.com:8080/"},
+		{http.MethodGet, "ftp://api.example.com/"},
+		{http.MethodGet, "/relative"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, nil)
+		if tt.method == http.MethodConnect {
+			r.Host = tt.target
+		}
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, r)
+		if w.Code != http.StatusForbidden && w.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: status %d", tt.method, tt.target, w.Code)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package nodechain
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// CachedEmbedder wraps an Embedder with an in-memory LRU cache and an
+// optional on-disk cache. Entries are keyed by the inner embedder's Name()
+// and a SHA-256 of the text, so switching models never returns stale
+// vectors. Only cache misses are sent upstream, in batches of BatchSize.
+// Callers get their own copy of every vector and may modify it.
+type CachedEmbedder struct {
+	Inner     Embedder
+	Capacity  int    // max in-memory entries; 0 disables the LRU
+	Dir       string // on-disk cache directory; "" disables it
+	BatchSize int    // max texts per upstream call; 0 sends all misses at once
+
+	mu    sync.Mutex
+	lru   *list.List // front = most recently used
+	items map[string]*list.Element
+
+	hits, misses int
+}
+
+type cacheEntry struct {
+	key string
+	emb []float32
+}
+
+func NewCachedEmbedder(inner Embedder, capacity int) *CachedEmbedder {
+	return &CachedEmbedder{
+		Inner:    inner,
+		Capacity: capacity,
+		lru:      list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (e *CachedEmbedder) Name() string { return e.Inner.Name() }
+
+// Stats returns the number of texts served from cache and sent upstream.
+func (e *CachedEmbedder) Stats() (hits, misses int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.hits, e.misses
+}
+
+func (e *CachedEmbedder) EmbedText(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, fmt.Errorf("EmbedText: no texts provided")
+	}
+
+	out := make([][]float32, len(texts))
+	keys := make([]string, len(texts))
+
+	// misses maps each distinct uncached key to the positions needing it
+	misses := map[string][]int{}
+	var missTexts []string
+	var missKeys []string
+
+	for i, t := range texts {
+		keys[i] = e.key(t)
+		if emb, ok := e.get(keys[i]); ok {
+			out[i] = slices.Clone(emb)
+			continue
+		}
+		if _, ok := misses[keys[i]]; !ok {
+			missTexts = append(missTexts, t)
+			missKeys = append(missKeys, keys[i])
+		}
+		misses[keys[i]] = append(misses[keys[i]], i)
+	}
+
+	e.mu.Lock()
+	e.hits += len(texts) - len(missTexts)
+	e.misses += len(missTexts)
+	e.mu.Unlock()
+
+	batch := e.BatchSize
+	if batch <= 0 {
+		batch = len(missTexts)
+	}
+	for start := 0; start < len(missTexts); start += batch {
+		end := min(start+batch, len(missTexts))
+
+		embs, err := e.Inner.EmbedText(ctx, missTexts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		if len(embs) != end-start {
+			return nil, fmt.Errorf("CachedEmbedder: got %d embeddings for %d texts", len(embs), end-start)
+		}
+
+		for j, emb := range embs {
+			key := missKeys[start+j]
+			e.put(key, slices.Clone(emb))
+			for _, i := range misses[key] {
+				out[i] = slices.Clone(emb)
+			}
+		}
+	}
+
+	return out, nil
+}
+
+func (e *CachedEmbedder) key(text string) string {
+	h := sha256.New()
+	h.Write([]byte(e.Inner.Name()))
+	h.Write([]byte{0})
+	h.Write([]byte(text))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (e *CachedEmbedder) get(key string) ([]float32, bool) {
+	e.mu.Lock()
+	if el, ok := e.items[key]; ok {
+		e.lru.MoveToFront(el)
+		emb := el.Value.(*cacheEntry).emb
+		e.mu.Unlock()
+		return emb, true
+	}
+	e.mu.Unlock()
+
+	if e.Dir == "" {
+		return nil, false
+	}
+	emb, err := readCachedEmbedding(e.path(key))
+	if err != nil {
+		return nil, false
+	}
+	e.remember(key, emb)
+	return emb, true
+}
+
+func (e *CachedEmbedder) put(key string, emb []float32) {
+	e.remember(key, emb)
+	if e.Dir != "" {
+		// the disk cache is best-effort; a failed write only costs a re-embed
+		_ = writeCachedEmbedding(e.path(key), emb)
+	}
+}
+
+func (e *CachedEmbedder) remember(key string, emb []float32) {
+	if e.Capacity <= 0 {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.lru == nil {
+		e.lru = list.New()
+		e.items = make(map[string]*list.Element)
+	}
+	if el, ok := e.items[key]; ok {
+		el.Value.(*cacheEntry).emb = emb
+		e.lru.MoveToFront(el)
+		return
+	}
+
+	e.items[key] = e.lru.PushFront(&cacheEntry{key: key, emb: emb})
+	for e.lru.Len() > e.Capacity {
+		oldest := e.lru.Back()
+		e.lru.Remove(oldest)
+		delete(e.items, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+func (e *CachedEmbedder) path(key string) string {
+	return filepath.Join(e.Dir, key[:2], key+".f32")
+}
+
+// Embeddings are stored as little-endian float32s.
+func readCachedEmbedding(path string) ([]float32, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%4 != 0 || len(data) == 0 {
+		return nil, errors.New("corrupt cache entry")
+	}
+	emb := make([]float32, len(data)/4)
+	for i := range emb {
+		emb[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:]))
+	}
+	return emb, nil
+}
+
+func writeCachedEmbedding(path string, emb []float32) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	data := make([]byte, len(emb)*4)
+	for i, v := range emb {
+		binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(v))
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package nodechain
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCachedEmbedderReturnsCopies(t *testing.T) {
+	for _, dir := range []string{"", t.TempDir()} {
+		e := NewCachedEmbedder(NewHashingEmbedder(8), 10)
+		e.Dir = dir
+		ctx := context.Background()
+
+		first, err := e.EmbedText(ctx, []string{"text", "text"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := first[1][0]
+		first[0][0] = 42 // callers may scribble on their vectors
+
+		second, err := e.EmbedText(ctx, []string{"text"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if second[0][0] != want || first[1][0] != want {
+			t.Fatalf("dir %q: modifying a returned vector changed the cache", dir)
+		}
+		second[0][0] = 42
+		if third, _ := e.EmbedText(ctx, []string{"text"}); third[0][0] != want {
+			t.Fatalf("dir %q: modifying a cache hit changed the cache", dir)
+		}
+		if hits, misses := e.Stats(); hits != 3 || misses != 1 {
+			t.Fatalf("dir %q: %d hits, %d misses", dir, hits, misses)
+		}
+	}
+}
+
+func TestCachedEmbedderKeysOnHashingSettings(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	words := NewHashingEmbedder(64)
+	words.CharNGram = 0
+	chars := NewHashingEmbedder(64)
+	if words.Name() == chars.Name() {
+		t.Fatalf("different settings share the name %q", words.Name())
+	}
+
+	cached := NewCachedEmbedder(words, 10)
+	cached.Dir = dir
+	if _, err := cached.EmbedText(ctx, []string{"shared text"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a second cache over the same directory with other settings must miss
+	other := NewCachedEmbedder(chars, 10)
+	other.Dir = dir
+	got, err := other.EmbedText(ctx, []string{"shared text"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := embedQuery(t, chars, "shared text")
+	for i := range want {
+		if got[0][i] != want[i] {
+			t.Fatal("cache returned a vector embedded with other settings")
+		}
+	}
+	if _, misses := other.Stats(); misses != 1 {
+		t.Fatalf("%d misses, want 1", misses)
+	}
+}
@@ -0,0 +1,111 @@
+package nodechain
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// HashingEmbedder is a deterministic, dependency-free Embedder for tests and
+// air-gapped use. Each text is turned into word unigrams, word bigrams and
+// character n-grams, which are hashed into Dimension buckets with a random
+// sign (the "hashing trick"), weighted by sublinear term frequency and
+// L2-normalised. Texts sharing vocabulary get high cosine similarity; it has
+// no notion of synonyms.
+type HashingEmbedder struct {
+	Dimension  int
+	CharNGram  int     // character n-gram length; 0 disables
+	CharWeight float64 // weight of character n-grams relative to words
+}
+
+func NewHashingEmbedder(dimension int) *HashingEmbedder {
+	return &HashingEmbedder{
+		Dimension:  dimension,
+		CharNGram:  3,
+		CharWeight: 0.5,
+	}
+}
+
+// Name includes every setting that changes the vectors, so caches and
+// stores keyed by it never mix embeddings from different configurations.
+func (e *HashingEmbedder) Name() string {
+	return fmt.Sprintf("hashing-embedder:%d:ngram%d:weight%g", e.Dimension, e.CharNGram, e.CharWeight)
+}
+
+func (e *HashingEmbedder) EmbedText(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, fmt.Errorf("EmbedText: no texts provided")
+	}
+	if e.Dimension <= 0 {
+		return nil, fmt.Errorf("HashingEmbedder: dimension must be positive, got %d", e.Dimension)
+	}
+
+	out := make([][]float32, len(texts))
+	for i, t := range texts {
+		out[i] = e.embed(t)
+	}
+	return out, nil
+}
+
+func (e *HashingEmbedder) embed(text string) []float32 {
+	counts := map[string]int{}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, w := range words {
+		counts["w:"+w]++
+		if i > 0 {
+			counts["b:"+words[i-1]+" "+w]++
+		}
+		if e.CharNGram > 0 {
+			padded := []rune(" " + w + " ")
+			for j := 0; j+e.CharNGram <= len(padded); j++ {
+				counts["c:"+string(padded[j:j+e.CharNGram])]++
+			}
+		}
+	}
+
+	// sum in a fixed order so results are bit-for-bit reproducible
+	features := make([]string, 0, len(counts))
+	for f := range counts {
+		features = append(features, f)
+	}
+	sort.Strings(features)
+
+	vec := make([]float64, e.Dimension)
+	for _, feature := range features {
+		c := counts[feature]
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+
+		weight := 1 + math.Log(float64(c))
+		if strings.HasPrefix(feature, "c:") {
+			weight *= e.CharWeight
+		}
+		if sum>>63 == 1 {
+			weight = -weight
+		}
+		vec[sum%uint64(e.Dimension)] += weight
+	}
+
+	var norm float64
+	for _, v := range vec {
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+
+	out := make([]float32, e.Dimension)
+	if norm == 0 {
+		return out
+	}
+	for i, v := range vec {
+		out[i] = float32(v / norm)
+	}
+	return out
+}
@@ -0,0 +1,97 @@
+package nodechain
+
+import (
+	"context"
+	"strconv"
+	"testing"
+)
+
+// embedDocs returns one document per text, with IDs "0", "1", ... and
+// embeddings from e.
+func embedDocs(t testing.TB, e Embedder, texts ...string) []Document {
+	t.Helper()
+	vecs, err := e.EmbedText(context.Background(), texts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := make([]Document, len(texts))
+	for i, text := range texts {
+		docs[i] = Document{ID: strconv.Itoa(i), Text: text, Embedding: vecs[i]}
+	}
+	return docs
+}
+
+func embedQuery(t testing.TB, e Embedder, text string) []float32 {
+	t.Helper()
+	vecs, err := e.EmbedText(context.Background(), []string{text})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vecs[0]
+}
+
+func docIDs(docs []Document) []string {
+	ids := make([]string, len(docs))
+	for i, d := range docs {
+		ids[i] = d.ID
+	}
+	return ids
+}
+
+func TestHashingEmbedderDeterministic(t *testing.T) {
+	e := NewHashingEmbedder(64)
+	a := embedQuery(t, e, "The quick brown fox")
+	b := embedQuery(t, NewHashingEmbedder(64), "the QUICK brown fox!")
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("embeddings differ at %d: %v vs %v", i, a[i], b[i])
+		}
+	}
+	if got := cosineSimilarity(a, a); got < 0.9999 {
+		t.Fatalf("embedding not normalised: self-similarity %v", got)
+	}
+}
+
+func TestHashingEmbedderSimilarity(t *testing.T) {
+	e := NewHashingEmbedder(256)
+	tests := []struct {
+		query, near, far string
+	}{
+		{"how do I reset my password", "reset your password from the login page", "the cafeteria opens at noon"},
+		{"docker container networking", "containers on a docker network", "baking sourdough bread"},
+		{"kubernetes", "kubernetes pods", "gardening tips"},
+	}
+	for _, tt := range tests {
+		q := embedQuery(t, e, tt.query)
+		near := cosineSimilarity(q, embedQuery(t, e, tt.near))
+		far := cosineSimilarity(q, embedQuery(t, e, tt.far))
+		if near <= far {
+			t.Errorf("%q: similarity to %q (%v) not above %q (%v)", tt.query, tt.near, near, tt.far, far)
+		}
+	}
+}
+
+func TestHashingEmbedderErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		e     *HashingEmbedder
+		texts []string
+	}{
+		{"no texts", NewHashingEmbedder(8), nil},
+		{"zero dimension", NewHashingEmbedder(0), []string{"x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.e.EmbedText(context.Background(), tt.texts); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+
+	vec := embedQuery(t, NewHashingEmbedder(8), "  !!  ")
+	for _, v := range vec {
+		if v != 0 {
+			t.Fatalf("text without terms embedded as %v, want zeros", vec)
+		}
+	}
+}
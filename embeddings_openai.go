@@ -24,7 +24,8 @@ func NewOpenAIEmbedder(client *openai.Client, model openai.EmbeddingModel) *Open
 	}
 }
 
-func (e *OpenAIEmbedder) Name() string { return "openai-embedder" }
+// Name includes the model so caches and stores can tell models apart.
+func (e *OpenAIEmbedder) Name() string { return "openai-embedder:" + string(e.Model) }
 
 func (e *OpenAIEmbedder) EmbedText(ctx context.Context, texts []string) ([][]float32, error) {
 	if len(texts) == 0 {
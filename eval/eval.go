@@ -0,0 +1,295 @@
+// Package eval measures RAG flow quality against a labelled dataset:
+// retrieval metrics (recall@k, MRR, nDCG) over the retrieved documents and,
+// optionally, LLM-judged faithfulness and relevance of the answers.
+package eval
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	nc "nodechain"
+)
+
+// Example is one evaluation case.
+type Example struct {
+	ID              string   `json:"id"`
+	Question        string   `json:"question"`
+	ExpectedDocIDs  []string `json:"expected_doc_ids"`
+	ReferenceAnswer string   `json:"reference_answer,omitempty"`
+}
+
+// LoadDataset reads examples as JSONL (one Example per line) or as a single
+// JSON array.
+func LoadDataset(r io.Reader) ([]Example, error) {
+	br := bufio.NewReader(r)
+	first, err := peekNonSpace(br)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if first == '[' {
+		var out []Example
+		if err := json.NewDecoder(br).Decode(&out); err != nil {
+			return nil, fmt.Errorf("eval: decoding dataset: %w", err)
+		}
+		return out, nil
+	}
+
+	var out []Example
+	dec := json.NewDecoder(br)
+	for {
+		var ex Example
+		if err := dec.Decode(&ex); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("eval: decoding example %d: %w", len(out)+1, err)
+		}
+		out = append(out, ex)
+	}
+	return out, nil
+}
+
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if !strings.ContainsRune(" \t\r\n", rune(b)) {
+			return b, br.UnreadByte()
+		}
+	}
+}
+
+const captureKey = "eval:capture"
+
+// CaptureNode copies the retrieved documents and answer out of a flow run so
+// the Evaluator can score them. Attach it after the node producing the
+// answer; flows built for production can leave it out.
+type CaptureNode struct {
+	nc.BaseNode
+	ContextKey string
+	AnswerKey  string
+}
+
+func NewCaptureNode(contextKey, answerKey string) *CaptureNode {
+	return &CaptureNode{
+		BaseNode:   nc.NewBaseNode(),
+		ContextKey: contextKey,
+		AnswerKey:  answerKey,
+	}
+}
+
+func (n *CaptureNode) TypeName() string { return "eval.CaptureNode" }
+
+type captured struct {
+	docs   []nc.Document
+	answer string
+}
+
+func (n *CaptureNode) Run(ctx context.Context, mem *nc.Memory) ([]nc.Trigger, error) {
+	var c captured
+	if raw, ok := mem.Get(n.ContextKey); ok {
+		docs, ok := raw.([]nc.Document)
+		if !ok {
+			return nil, fmt.Errorf("CaptureNode: value at key '%s' is not []Document", n.ContextKey)
+		}
+		c.docs = docs
+	}
+	if raw, ok := mem.Get(n.AnswerKey); ok {
+		answer, ok := raw.(string)
+		if !ok {
+			return nil, fmt.Errorf("CaptureNode: answer at key '%s' is not a string", n.AnswerKey)
+		}
+		c.answer = answer
+	}
+
+	// Local memory is discarded after the run; Global is the evaluator's
+	mem.Global[captureKey] = c
+
+	return []nc.Trigger{
+		{Action: nc.DefaultAction, ForkingData: map[string]any{}},
+	}, nil
+}
+
+// Evaluator runs a flow once per example. The question is placed in the
+// flow's global memory at QueryKey, so the flow should start at a node that
+// reads it (e.g. EmbedQueryNode) rather than a ValueNode, and must pass
+// through a CaptureNode.
+type Evaluator struct {
+	Flow     *nc.Flow
+	QueryKey string
+	K        int    // cutoff for recall@k and nDCG@k
+	Judge    *Judge // optional; nil skips answer grading
+}
+
+func NewEvaluator(flow *nc.Flow, queryKey string, k int) *Evaluator {
+	return &Evaluator{Flow: flow, QueryKey: queryKey, K: k}
+}
+
+// Result holds the scores for one example.
+type Result struct {
+	ID           string     `json:"id"`
+	Question     string     `json:"question"`
+	Answer       string     `json:"answer,omitempty"`
+	RetrievedIDs []string   `json:"retrieved_ids"`
+	Recall       float64    `json:"recall"`
+	RR           float64    `json:"reciprocal_rank"`
+	NDCG         float64    `json:"ndcg"`
+	Judgement    *Judgement `json:"judgement,omitempty"`
+	Error        string     `json:"error,omitempty"`       // the flow failed; no scores
+	JudgeError   string     `json:"judge_error,omitempty"` // grading failed; retrieval scores stand
+}
+
+// Summary averages retrieval scores over the examples that ran without
+// error, and judge scores over those that were also graded.
+type Summary struct {
+	Examples     int      `json:"examples"`
+	Errors       int      `json:"errors"`
+	JudgeErrors  int      `json:"judge_errors,omitempty"`
+	K            int      `json:"k"`
+	Recall       float64  `json:"recall_at_k"`
+	MRR          float64  `json:"mrr"`
+	NDCG         float64  `json:"ndcg_at_k"`
+	Faithfulness *float64 `json:"faithfulness,omitempty"`
+	Relevance    *float64 `json:"relevance,omitempty"`
+}
+
+type Report struct {
+	Summary Summary  `json:"summary"`
+	Results []Result `json:"results"`
+}
+
+// Run evaluates every example. A failing example is recorded in its Result
+// and does not stop the run; only context cancellation does. An example
+// whose answer could not be graded still counts towards the retrieval
+// scores, and is counted in JudgeErrors.
+func (e *Evaluator) Run(ctx context.Context, examples []Example) (Report, error) {
+	var rep Report
+	rep.Summary.K = e.K
+
+	var faith, rel float64
+	judged := 0
+
+	for _, ex := range examples {
+		if err := ctx.Err(); err != nil {
+			return rep, err
+		}
+
+		res := e.runOne(ctx, ex)
+		rep.Results = append(rep.Results, res)
+		rep.Summary.Examples++
+
+		if res.Error != "" {
+			rep.Summary.Errors++
+			continue
+		}
+		rep.Summary.Recall += res.Recall
+		rep.Summary.MRR += res.RR
+		rep.Summary.NDCG += res.NDCG
+		if res.JudgeError != "" {
+			rep.Summary.JudgeErrors++
+		}
+		if res.Judgement != nil {
+			faith += res.Judgement.Faithfulness
+			rel += res.Judgement.Relevance
+			judged++
+		}
+	}
+
+	if ok := rep.Summary.Examples - rep.Summary.Errors; ok > 0 {
+		rep.Summary.Recall /= float64(ok)
+		rep.Summary.MRR /= float64(ok)
+		rep.Summary.NDCG /= float64(ok)
+	}
+	if judged > 0 {
+		f, r := faith/float64(judged), rel/float64(judged)
+		rep.Summary.Faithfulness, rep.Summary.Relevance = &f, &r
+	}
+	return rep, nil
+}
+
+func (e *Evaluator) runOne(ctx context.Context, ex Example) Result {
+	res := Result{ID: ex.ID, Question: ex.Question}
+
+	global := map[string]any{e.QueryKey: ex.Question}
+	if _, err := e.Flow.Run(ctx, global); err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	c, ok := global[captureKey].(captured)
+	if !ok {
+		res.Error = "flow did not reach an eval.CaptureNode"
+		return res
+	}
+
+	res.Answer = c.answer
+	for _, d := range c.docs {
+		res.RetrievedIDs = append(res.RetrievedIDs, d.ID)
+	}
+	res.Recall = RecallAtK(c.docs, ex.ExpectedDocIDs, e.K)
+	res.RR = ReciprocalRank(cutoff(c.docs, e.K), ex.ExpectedDocIDs)
+	res.NDCG = NDCGAtK(c.docs, ex.ExpectedDocIDs, e.K)
+
+	if e.Judge != nil && c.answer != "" {
+		j, err := e.Judge.Grade(ctx, ex.Question, c.answer, ex.ReferenceAnswer, c.docs)
+		if err != nil {
+			res.JudgeError = err.Error()
+			return res
+		}
+		res.Judgement = &j
+	}
+	return res
+}
+
+func (r Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteTable prints one row per example followed by the averages.
+func (r Report) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "ID\tRECALL@%d\tRR\tNDCG@%d\tFAITH\tREL\tERROR\n", r.Summary.K, r.Summary.K)
+	for _, res := range r.Results {
+		faith, rel := "-", "-"
+		if res.Judgement != nil {
+			faith = fmt.Sprintf("%.2f", res.Judgement.Faithfulness)
+			rel = fmt.Sprintf("%.2f", res.Judgement.Relevance)
+		}
+		errText := res.Error
+		if res.JudgeError != "" {
+			errText = "judge: " + res.JudgeError
+		}
+		fmt.Fprintf(tw, "%s\t%.2f\t%.2f\t%.2f\t%s\t%s\t%s\n",
+			res.ID, res.Recall, res.RR, res.NDCG, faith, rel, firstLine(errText))
+	}
+
+	s := r.Summary
+	faith, rel := "-", "-"
+	if s.Faithfulness != nil {
+		faith = fmt.Sprintf("%.2f", *s.Faithfulness)
+		rel = fmt.Sprintf("%.2f", *s.Relevance)
+	}
+	fmt.Fprintf(tw, "MEAN (%d/%d)\t%.2f\t%.2f\t%.2f\t%s\t%s\t\n",
+		s.Examples-s.Errors, s.Examples, s.Recall, s.MRR, s.NDCG, faith, rel)
+
+	return tw.Flush()
+}
+
+// firstLine keeps table rows to one line; judge errors carry raw LLM output.
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
@@ -0,0 +1,76 @@
+package eval
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	nc "nodechain"
+)
+
+// answerNode stands in for a RAG flow: it "retrieves" docs and answers.
+type answerNode struct {
+	nc.BaseNode
+	docs []nc.Document
+}
+
+func (n *answerNode) TypeName() string { return "answerNode" }
+
+func (n *answerNode) Run(ctx context.Context, mem *nc.Memory) ([]nc.Trigger, error) {
+	q, _ := mem.Get("question")
+	mem.Local["docs"] = n.docs
+	mem.Local["answer"] = "answer to " + q.(string)
+	return []nc.Trigger{{Action: nc.DefaultAction, ForkingData: map[string]any{}}}, nil
+}
+
+// fakeJudge fails for questions containing "unjudgeable".
+type fakeJudge struct{}
+
+func (fakeJudge) Name() string { return "fake" }
+
+func (fakeJudge) Chat(ctx context.Context, msgs []nc.LLMMessage) (nc.LLMResponse, error) {
+	if strings.Contains(msgs[1].Content, "unjudgeable") {
+		return nc.LLMResponse{}, errors.New("rate limited")
+	}
+	return nc.LLMResponse{Text: `{"faithfulness": 1, "relevance": 0.5}`}, nil
+}
+
+func TestEvaluatorJudgeErrorKeepsRetrievalScores(t *testing.T) {
+	start := &answerNode{BaseNode: nc.NewBaseNode(), docs: []nc.Document{{ID: "a"}, {ID: "b"}}}
+	start.On(nc.DefaultAction, NewCaptureNode("docs", "answer"))
+
+	ev := NewEvaluator(nc.NewFlow(start), "question", 2)
+	ev.Judge = &Judge{Provider: fakeJudge{}}
+
+	rep, err := ev.Run(context.Background(), []Example{
+		{ID: "1", Question: "judged", ExpectedDocIDs: []string{"a"}},
+		{ID: "2", Question: "unjudgeable", ExpectedDocIDs: []string{"b"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := rep.Summary
+	if s.Errors != 0 || s.JudgeErrors != 1 {
+		t.Fatalf("errors %d, judge errors %d; want 0 and 1", s.Errors, s.JudgeErrors)
+	}
+	if s.Recall != 1 || math.Abs(s.MRR-0.75) > 1e-9 {
+		t.Fatalf("recall %v, MRR %v: the unjudged example's retrieval scores were dropped", s.Recall, s.MRR)
+	}
+	if s.Faithfulness == nil || *s.Faithfulness != 1 || *s.Relevance != 0.5 {
+		t.Fatalf("judge averages %v/%v, want 1/0.5 over the judged example", s.Faithfulness, s.Relevance)
+	}
+	if res := rep.Results[1]; res.JudgeError == "" || res.Error != "" || res.Judgement != nil {
+		t.Fatalf("result %+v", res)
+	}
+
+	var b strings.Builder
+	if err := rep.WriteTable(&b); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "judge: rate limited") || !strings.Contains(b.String(), "MEAN (2/2)") {
+		t.Fatalf("table:\n%s", b.String())
+	}
+}
@@ -0,0 +1,69 @@
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	nc "nodechain"
+)
+
+// Judgement is an LLM's grading of one answer.
+type Judgement struct {
+	// Faithfulness: is every claim in the answer supported by the context?
+	Faithfulness float64 `json:"faithfulness"`
+	// Relevance: does the answer address the question (and agree with the
+	// reference answer, when one is given)?
+	Relevance float64 `json:"relevance"`
+	Reason    string  `json:"reason,omitempty"`
+}
+
+// Judge grades answers with an LLM. Scores are in [0, 1].
+type Judge struct {
+	Provider nc.LLMProvider
+}
+
+const judgeSystem = `You evaluate answers produced by a retrieval-augmented assistant.
+Score two things from 0 to 1:
+- faithfulness: 1 if every claim in the ANSWER is supported by the CONTEXT, 0 if it is unsupported or contradicts it.
+- relevance: 1 if the ANSWER fully addresses the QUESTION (and agrees with the REFERENCE, if given), 0 if it does not.
+Reply with JSON only: {"faithfulness": <number>, "relevance": <number>, "reason": "<one sentence>"}`
+
+func (j *Judge) Grade(ctx context.Context, question, answer, reference string, docs []nc.Document) (Judgement, error) {
+	var b strings.Builder
+	b.WriteString("QUESTION:\n" + question + "\n\nCONTEXT:\n")
+	for i, d := range docs {
+		fmt.Fprintf(&b, "[%d] %s\n", i+1, d.Text)
+	}
+	if reference != "" {
+		b.WriteString("\nREFERENCE:\n" + reference + "\n")
+	}
+	b.WriteString("\nANSWER:\n" + answer + "\n")
+
+	resp, err := j.Provider.Chat(ctx, []nc.LLMMessage{
+		{Role: "system", Content: judgeSystem},
+		{Role: "user", Content: b.String()},
+	})
+	if err != nil {
+		return Judgement{}, err
+	}
+
+	text := strings.TrimSpace(resp.Text)
+	// tolerate ```json fences and surrounding prose
+	if start, end := strings.Index(text, "{"), strings.LastIndex(text, "}"); start >= 0 && end > start {
+		text = text[start : end+1]
+	}
+
+	var out Judgement
+	if err := json.Unmarshal([]byte(text), &out); err != nil {
+		return Judgement{}, fmt.Errorf("Judge: invalid JSON from LLM: %v\nRaw output:\n%s", err, resp.Text)
+	}
+	out.Faithfulness = clamp01(out.Faithfulness)
+	out.Relevance = clamp01(out.Relevance)
+	return out, nil
+}
+
+func clamp01(v float64) float64 {
+	return min(max(v, 0), 1)
+}
@@ -0,0 +1,97 @@
+package eval
+
+import (
+	"math"
+
+	nc "nodechain"
+)
+
+// relevantRanks returns, for each retrieved document, whether it matches an
+// expected ID. A document matches by its own ID or by its "parent_id"
+// metadata, so chunk-level results can be scored against source-level
+// labels. Each expected ID is credited at most once.
+func relevantRanks(docs []nc.Document, expected []string) []bool {
+	want := make(map[string]bool, len(expected))
+	for _, id := range expected {
+		want[id] = true
+	}
+
+	hits := make([]bool, len(docs))
+	for i, d := range docs {
+		for _, id := range matchIDs(d) {
+			if want[id] {
+				hits[i] = true
+				delete(want, id)
+				break
+			}
+		}
+	}
+	return hits
+}
+
+func matchIDs(d nc.Document) []string {
+	ids := []string{d.ID}
+	if p, ok := d.Metadata["parent_id"].(string); ok && p != "" {
+		ids = append(ids, p)
+	}
+	return ids
+}
+
+// RecallAtK is the fraction of expected documents found in the top k.
+func RecallAtK(docs []nc.Document, expected []string, k int) float64 {
+	if len(expected) == 0 {
+		return 0
+	}
+	found := 0
+	for _, hit := range relevantRanks(cutoff(docs, k), expected) {
+		if hit {
+			found++
+		}
+	}
+	return float64(found) / float64(len(expected))
+}
+
+// ReciprocalRank is 1/rank of the first relevant document, or 0.
+func ReciprocalRank(docs []nc.Document, expected []string) float64 {
+	for i, hit := range relevantRanks(docs, expected) {
+		if hit {
+			return 1 / float64(i+1)
+		}
+	}
+	return 0
+}
+
+// NDCGAtK is normalised discounted cumulative gain over the top k with
+// binary relevance.
+func NDCGAtK(docs []nc.Document, expected []string, k int) float64 {
+	if len(expected) == 0 {
+		return 0
+	}
+
+	var dcg float64
+	for i, hit := range relevantRanks(cutoff(docs, k), expected) {
+		if hit {
+			dcg += 1 / math.Log2(float64(i+2))
+		}
+	}
+
+	n := len(expected)
+	if k > 0 {
+		n = min(n, k)
+	}
+	var ideal float64
+	for i := 0; i < n; i++ {
+		ideal += 1 / math.Log2(float64(i+2))
+	}
+	if ideal == 0 {
+		return 0
+	}
+	return dcg / ideal
+}
+
+func cutoff(docs []nc.Document, k int) []nc.Document {
+	if k > 0 && k < len(docs) {
+		return docs[:k]
+	}
+	return docs
+}
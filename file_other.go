@@ -0,0 +1,6 @@
+//go:build !unix
+
+package nodechain
+
+// oNoFollow is unavailable here; copyPath's Lstat check is the only guard.
+const oNoFollow = 0
@@ -0,0 +1,8 @@
+//go:build unix
+
+package nodechain
+
+import "syscall"
+
+// oNoFollow makes opening a path fail if its final component is a symlink.
+const oNoFollow = syscall.O_NOFOLLOW
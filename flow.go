@@ -3,6 +3,7 @@ package nodechain
 import (
 	"context"
 	"fmt"
+	"sync"
 )
 
 type Flow struct {
@@ -20,6 +21,10 @@ func NewFlow(start Node) *Flow {
 }
 
 func (f *Flow) Run(ctx context.Context, global map[string]any) (ExecutionTree, error) {
+	exit := &flowExit{}
+	ctx = context.WithValue(ctx, flowExitKey{}, exit)
+	defer exit.run()
+
 	f.visitCounts = map[int]int{}
 	mem := NewMemory(global)
 	return f.runNode(ctx, f.Start, mem)
@@ -72,3 +77,36 @@ func (f *Flow) runNode(ctx context.Context, n Node, mem *Memory) (ExecutionTree,
 
 	return out, nil
 }
+
+type flowExitKey struct{}
+
+// flowExit collects the functions registered with OnFlowExit.
+type flowExit struct {
+	mu  sync.Mutex
+	fns []func()
+}
+
+// OnFlowExit registers fn to run when the Flow.Run that ctx belongs to
+// returns, whether the flow succeeded or failed, so nodes can release what
+// they acquired even if a later node errors. Functions run in reverse order
+// of registration. It reports false if ctx does not come from a Flow.
+func OnFlowExit(ctx context.Context, fn func()) bool {
+	exit, ok := ctx.Value(flowExitKey{}).(*flowExit)
+	if !ok {
+		return false
+	}
+	exit.mu.Lock()
+	exit.fns = append(exit.fns, fn)
+	exit.mu.Unlock()
+	return true
+}
+
+func (e *flowExit) run() {
+	e.mu.Lock()
+	fns := e.fns
+	e.fns = nil
+	e.mu.Unlock()
+	for i := len(fns) - 1; i >= 0; i-- {
+		fns[i]()
+	}
+}
@@ -0,0 +1,218 @@
+package nodechain
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// FusionMode selects how HybridRetrieveNode merges keyword and vector results.
+type FusionMode string
+
+const (
+	// FusionRRF uses reciprocal rank fusion: score = sum(w / (c + rank)).
+	FusionRRF FusionMode = "rrf"
+	// FusionWeighted min-max normalises each result list and sums the
+	// weighted scores.
+	FusionWeighted FusionMode = "weighted"
+)
+
+// HybridRetrieveNode: combines BM25 keyword search and vector search.
+//
+// VectorWeight defaults to 0.5 only through NewHybridRetrieveNode. Zero is a
+// valid weight meaning keyword results alone, so a struct literal that
+// leaves it unset ignores the vector store.
+type HybridRetrieveNode struct {
+	BaseNode
+	Store        VectorStore
+	Index        *BM25Index
+	QueryKey     string // key where the query text is stored
+	EmbeddingKey string // key where the query embedding is stored
+	ResultKey    string // key where retrieved docs will be stored ([]Document)
+	K            int
+	FetchK       int // candidates fetched from each retriever; defaults to 4*K
+
+	Fusion       FusionMode
+	RRFConstant  float64 // c in 1/(c + rank); 60 is the usual choice
+	VectorWeight float64 // weight of vector results in [0,1]; keyword gets 1-VectorWeight
+}
+
+func NewHybridRetrieveNode(store VectorStore, index *BM25Index, queryKey, embeddingKey, resultKey string, k int) *HybridRetrieveNode {
+	return &HybridRetrieveNode{
+		BaseNode:     NewBaseNode(),
+		Store:        store,
+		Index:        index,
+		QueryKey:     queryKey,
+		EmbeddingKey: embeddingKey,
+		ResultKey:    resultKey,
+		K:            k,
+		Fusion:       FusionRRF,
+		RRFConstant:  60,
+		VectorWeight: 0.5,
+	}
+}
+
+func (n *HybridRetrieveNode) TypeName() string { return "HybridRetrieveNode" }
+
+func (n *HybridRetrieveNode) Run(ctx context.Context, mem *Memory) ([]Trigger, error) {
+	if n.K <= 0 {
+		return nil, fmt.Errorf("HybridRetrieveNode: K must be positive, got %d", n.K)
+	}
+	if n.VectorWeight < 0 || n.VectorWeight > 1 {
+		return nil, fmt.Errorf("HybridRetrieveNode: VectorWeight must be between 0 and 1, got %g", n.VectorWeight)
+	}
+
+	rawQ, ok := mem.Get(n.QueryKey)
+	if !ok {
+		return nil, fmt.Errorf("HybridRetrieveNode: query not found at key '%s'", n.QueryKey)
+	}
+	query, ok := rawQ.(string)
+	if !ok {
+		return nil, fmt.Errorf("HybridRetrieveNode: query at key '%s' is not a string", n.QueryKey)
+	}
+
+	rawEmb, ok := mem.Get(n.EmbeddingKey)
+	if !ok {
+		return nil, fmt.Errorf("HybridRetrieveNode: embedding not found at key '%s'", n.EmbeddingKey)
+	}
+	emb, ok := rawEmb.([]float32)
+	if !ok {
+		return nil, fmt.Errorf("HybridRetrieveNode: value at key '%s' is not []float32", n.EmbeddingKey)
+	}
+
+	fetchK := n.FetchK
+	if fetchK <= 0 {
+		fetchK = 4 * n.K
+	}
+
+	vecDocs, err := n.Store.Search(ctx, emb, fetchK)
+	if err != nil {
+		return nil, fmt.Errorf("HybridRetrieveNode: %w", err)
+	}
+	vector := make([]ScoredDocument, len(vecDocs))
+	for i, d := range vecDocs {
+		vector[i] = ScoredDocument{Document: d, Score: cosineSimilarity(emb, d.Embedding)}
+	}
+
+	// a query with no searchable terms (only punctuation, say) falls back
+	// to vector results alone
+	var keyword []ScoredDocument
+	if len(tokenizeBM25(query)) > 0 {
+		if keyword, err = n.Index.Search(ctx, query, fetchK); err != nil {
+			return nil, fmt.Errorf("HybridRetrieveNode: %w", err)
+		}
+	}
+
+	var fused []ScoredDocument
+	switch n.Fusion {
+	case FusionRRF, "":
+		fused = fuseRRF(n.RRFConstant, n.VectorWeight, vector, keyword)
+	case FusionWeighted:
+		fused = fuseWeighted(n.VectorWeight, vector, keyword)
+	default:
+		return nil, fmt.Errorf("HybridRetrieveNode: unknown fusion mode '%s'", n.Fusion)
+	}
+
+	if n.K < len(fused) {
+		fused = fused[:n.K]
+	}
+
+	docs := make([]Document, len(fused))
+	for i, s := range fused {
+		docs[i] = s.Document
+	}
+	mem.Local[n.ResultKey] = docs
+
+	return []Trigger{
+		{Action: DefaultAction, ForkingData: map[string]any{}},
+	}, nil
+}
+
+// fuseRRF merges the vector and keyword rankings with reciprocal rank fusion.
+// Documents are matched by ID; documents without one are never merged.
+func fuseRRF(c, vectorWeight float64, vector, keyword []ScoredDocument) []ScoredDocument {
+	if c <= 0 {
+		c = 60
+	}
+	acc := newFusionAccumulator()
+	for rank, d := range vector {
+		acc.add(d.Document, vectorWeight/(c+float64(rank+1)))
+	}
+	for rank, d := range keyword {
+		acc.add(d.Document, (1-vectorWeight)/(c+float64(rank+1)))
+	}
+	return acc.sorted()
+}
+
+// fuseWeighted min-max normalises each list's scores to [0,1] and combines
+// them as vectorWeight*vector + (1-vectorWeight)*keyword.
+func fuseWeighted(vectorWeight float64, vector, keyword []ScoredDocument) []ScoredDocument {
+	acc := newFusionAccumulator()
+	for i, s := range normaliseScores(vector) {
+		acc.add(vector[i].Document, vectorWeight*s)
+	}
+	for i, s := range normaliseScores(keyword) {
+		acc.add(keyword[i].Document, (1-vectorWeight)*s)
+	}
+	return acc.sorted()
+}
+
+func normaliseScores(docs []ScoredDocument) []float64 {
+	out := make([]float64, len(docs))
+	if len(docs) == 0 {
+		return out
+	}
+	lo, hi := docs[0].Score, docs[0].Score
+	for _, d := range docs {
+		lo = min(lo, d.Score)
+		hi = max(hi, d.Score)
+	}
+	for i, d := range docs {
+		if hi == lo {
+			out[i] = 1
+		} else {
+			out[i] = (d.Score - lo) / (hi - lo)
+		}
+	}
+	return out
+}
+
+// fusionAccumulator sums scores per document. Documents are keyed by ID,
+// or by the order they were added in when the ID is empty, so unnamed
+// documents are not collapsed into one.
+type fusionAccumulator struct {
+	order  []string
+	docs   map[string]Document
+	scores map[string]float64
+}
+
+func newFusionAccumulator() *fusionAccumulator {
+	return &fusionAccumulator{
+		docs:   make(map[string]Document),
+		scores: make(map[string]float64),
+	}
+}
+
+func (a *fusionAccumulator) add(d Document, score float64) {
+	key := "id:" + d.ID
+	if d.ID == "" {
+		key = "#" + strconv.Itoa(len(a.order))
+	}
+	if _, ok := a.docs[key]; !ok {
+		a.order = append(a.order, key)
+		a.docs[key] = d
+	}
+	a.scores[key] += score
+}
+
+func (a *fusionAccumulator) sorted() []ScoredDocument {
+	out := make([]ScoredDocument, len(a.order))
+	for i, key := range a.order {
+		out[i] = ScoredDocument{Document: a.docs[key], Score: a.scores[key]}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Score > out[j].Score
+	})
+	return out
+}
@@ -0,0 +1,162 @@
+package nodechain
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var hybridCorpus = []string{
+	"ERR-1042 means the disk quota was exceeded",
+	"Disk space can be freed by deleting old logs",
+	"The printer on floor two is out of toner",
+	"Quota limits are configured per user in the admin panel",
+}
+
+func newHybridFixture(t *testing.T) (*InMemoryVectorStore, *BM25Index, *HashingEmbedder) {
+	t.Helper()
+	e := NewHashingEmbedder(256)
+	docs := embedDocs(t, e, hybridCorpus...)
+	store := NewInMemoryVectorStore()
+	if err := store.Add(context.Background(), docs); err != nil {
+		t.Fatal(err)
+	}
+	index := NewBM25Index()
+	if err := index.Add(context.Background(), docs); err != nil {
+		t.Fatal(err)
+	}
+	return store, index, e
+}
+
+func TestHybridRetrieveNode(t *testing.T) {
+	store, index, e := newHybridFixture(t)
+
+	tests := []struct {
+		name    string
+		query   string
+		fusion  FusionMode
+		weight  float64
+		k       int
+		wantTop string
+	}{
+		{"rrf identifier", "what is ERR-1042", FusionRRF, 0.5, 2, "0"},
+		{"weighted identifier", "what is ERR-1042", FusionWeighted, 0.5, 2, "0"},
+		{"keyword only", "toner", FusionWeighted, 0, 1, "2"},
+		{"vector only", "printer toner on floor two", FusionRRF, 1, 1, "2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := NewHybridRetrieveNode(store, index, "q", "emb", "docs", tt.k)
+			n.Fusion = tt.fusion
+			n.VectorWeight = tt.weight
+
+			mem := NewMemory(map[string]any{
+				"q":   tt.query,
+				"emb": embedQuery(t, e, tt.query),
+			})
+			if _, err := n.Run(context.Background(), mem); err != nil {
+				t.Fatal(err)
+			}
+			docs := mem.Local["docs"].([]Document)
+			if len(docs) != tt.k {
+				t.Fatalf("got %d docs, want %d", len(docs), tt.k)
+			}
+			if docs[0].ID != tt.wantTop {
+				t.Fatalf("top doc %s (%v), want %s", docs[0].ID, docIDs(docs), tt.wantTop)
+			}
+		})
+	}
+}
+
+func TestFuseRRF(t *testing.T) {
+	d := func(id string) ScoredDocument { return ScoredDocument{Document: Document{ID: id}} }
+	vector := []ScoredDocument{d("a"), d("b"), d("c")}
+	keyword := []ScoredDocument{d("b"), d("d")}
+
+	tests := []struct {
+		weight float64
+		want   []string
+	}{
+		{0.5, []string{"b", "a", "d", "c"}},
+		{1, []string{"a", "b", "c", "d"}},
+		{0, []string{"b", "d", "a", "c"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, s := range fuseRRF(60, tt.weight, vector, keyword) {
+			got = append(got, s.ID)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("weight %v: got %v, want %v", tt.weight, got, tt.want)
+		}
+	}
+}
+
+func TestHybridRetrieveNodeQueryWithoutTerms(t *testing.T) {
+	store, index, e := newHybridFixture(t)
+	n := NewHybridRetrieveNode(store, index, "q", "emb", "docs", 2)
+	mem := NewMemory(map[string]any{
+		"q":   "?!",
+		"emb": embedQuery(t, e, "printer toner"),
+	})
+	if _, err := n.Run(context.Background(), mem); err != nil {
+		t.Fatalf("query without keyword terms: %v", err)
+	}
+	if docs := mem.Local["docs"].([]Document); len(docs) != 2 || docs[0].ID != "2" {
+		t.Fatalf("got %v, want vector results led by doc 2", docIDs(docs))
+	}
+}
+
+func TestHybridRetrieveNodeValidates(t *testing.T) {
+	store, index, e := newHybridFixture(t)
+
+	tests := []struct {
+		name    string
+		k       int
+		weight  float64
+		wantErr string
+	}{
+		{"zero k", 0, 0.5, "K must be positive"},
+		{"negative k", -1, 0.5, "K must be positive"},
+		{"weight above one", 2, 1.5, "VectorWeight"},
+		{"negative weight", 2, -0.1, "VectorWeight"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := NewHybridRetrieveNode(store, index, "q", "emb", "docs", tt.k)
+			n.VectorWeight = tt.weight
+			mem := NewMemory(map[string]any{
+				"q":   "printer toner",
+				"emb": embedQuery(t, e, "printer toner"),
+			})
+			_, err := n.Run(context.Background(), mem)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFusionKeepsUnnamedDocuments(t *testing.T) {
+	vector := []ScoredDocument{{Document: Document{Text: "x"}, Score: 0.9}, {Document: Document{Text: "y"}, Score: 0.5}}
+	keyword := []ScoredDocument{{Document: Document{ID: "k", Text: "z"}, Score: 3}}
+
+	tests := []struct {
+		name  string
+		fused []ScoredDocument
+	}{
+		{"rrf", fuseRRF(60, 0.5, vector, keyword)},
+		{"weighted", fuseWeighted(0.5, vector, keyword)},
+	}
+	for _, tt := range tests {
+		var texts []string
+		for _, d := range tt.fused {
+			texts = append(texts, d.Text)
+		}
+		slices.Sort(texts)
+		if !slices.Equal(texts, []string{"x", "y", "z"}) {
+			t.Errorf("%s: fused %v, want x, y and z", tt.name, texts)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package nodechain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IngestPipeline splits source documents into chunks, embeds them in batches
+// and writes them to a VectorStore.
+//
+// Chunk IDs are "<source id>:<chunk index>" and each chunk's metadata carries
+// the source metadata, any splitter metadata, the embedder name under
+// MetadataEmbedder, and "parent_id", "chunk_index" and "chunk_count".
+//
+// Vector stores append and do not replace documents by ID, so ingesting the
+// same documents twice stores every chunk twice. To re-index, ingest into a
+// new store (or Load a snapshot) rather than the old one.
+type IngestPipeline struct {
+	Embedder Embedder
+	Store    VectorStore
+	Splitter Splitter // nil stores each document as a single chunk
+	// Parents, if set, receives the source documents and their chunks
+	// (without embeddings) for parent-document retrieval. Both are keyed by
+	// ID, so source IDs must not collide with ChunkIDs of other sources.
+	Parents     DocumentStore
+	BatchSize   int // texts per EmbedText call
+	Concurrency int // embedding batches in flight
+	MaxRetries  int // attempts per batch
+	RetryDelay  time.Duration
+}
+
+func NewIngestPipeline(embedder Embedder, store VectorStore) *IngestPipeline {
+	return &IngestPipeline{
+		Embedder:    embedder,
+		Store:       store,
+		Splitter:    NewRecursiveSplitter(1000, 200),
+		BatchSize:   64,
+		Concurrency: 4,
+		MaxRetries:  3,
+		RetryDelay:  time.Second,
+	}
+}
+
+// IngestStats summarises one ingestion run.
+type IngestStats struct {
+	Documents int
+	Chunks    int
+	Batches   int
+}
+
+// Run loads documents from loader and ingests them.
+func (p *IngestPipeline) Run(ctx context.Context, loader DocumentLoader) (IngestStats, error) {
+	docs, err := loader.Load(ctx)
+	if err != nil {
+		return IngestStats{}, err
+	}
+	return p.Ingest(ctx, docs)
+}
+
+// Ingest splits, embeds and stores docs. Batches are written to the store as
+// soon as they are embedded; on error, earlier batches remain stored.
+func (p *IngestPipeline) Ingest(ctx context.Context, docs []Document) (IngestStats, error) {
+	stats := IngestStats{Documents: len(docs)}
+
+	chunks, err := p.chunk(docs)
+	if err != nil {
+		return stats, err
+	}
+	stats.Chunks = len(chunks)
+	if len(chunks) == 0 {
+		return stats, nil
+	}
+
+	if p.Parents != nil {
+		if err := p.Parents.Put(ctx, docs); err != nil {
+			return stats, err
+		}
+		if err := p.Parents.Put(ctx, chunks); err != nil {
+			return stats, err
+		}
+	}
+
+	batchSize := max(p.BatchSize, 1)
+	var batches [][]Document
+	for start := 0; start < len(chunks); start += batchSize {
+		batches = append(batches, chunks[start:min(start+batchSize, len(chunks))])
+	}
+	stats.Batches = len(batches)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+		sem      = make(chan struct{}, max(p.Concurrency, 1))
+	)
+
+	for _, batch := range batches {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(batch []Document) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := p.embedAndStore(ctx, batch); err != nil {
+				errOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(batch)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return stats, firstErr
+	}
+	return stats, ctx.Err()
+}
+
+func (p *IngestPipeline) chunk(docs []Document) ([]Document, error) {
+	var out []Document
+	for _, d := range docs {
+		if d.ID == "" {
+			return nil, errors.New("IngestPipeline: document without ID")
+		}
+
+		if strings.TrimSpace(d.Text) == "" {
+			continue
+		}
+
+		parts := []Chunk{{Text: d.Text}}
+		if p.Splitter != nil {
+			var err error
+			if parts, err = p.Splitter.Split(d.Text); err != nil {
+				return nil, fmt.Errorf("IngestPipeline: splitting '%s': %w", d.ID, err)
+			}
+		}
+
+		for i, c := range parts {
+			meta := make(map[string]any, len(d.Metadata)+len(c.Metadata)+3)
+			for k, v := range d.Metadata {
+				meta[k] = v
+			}
+			for k, v := range c.Metadata {
+				meta[k] = v
+			}
+			meta[MetadataEmbedder] = p.Embedder.Name()
+			meta["parent_id"] = d.ID
+			meta["chunk_index"] = i
+			meta["chunk_count"] = len(parts)
+
+			out = append(out, Document{
+				ID:       ChunkID(d.ID, i),
+				Text:     c.Text,
+				Metadata: meta,
+			})
+		}
+	}
+	return out, nil
+}
+
+func (p *IngestPipeline) embedAndStore(ctx context.Context, batch []Document) error {
+	texts := make([]string, len(batch))
+	for i, d := range batch {
+		texts[i] = d.Text
+	}
+
+	var embs [][]float32
+	var err error
+	for attempt := 0; attempt < max(p.MaxRetries, 1); attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(p.RetryDelay):
+			}
+		}
+
+		embs, err = p.Embedder.EmbedText(ctx, texts)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("IngestPipeline: embedding batch starting at '%s': %w", batch[0].ID, err)
+	}
+	if len(embs) != len(batch) {
+		return fmt.Errorf("IngestPipeline: got %d embeddings for %d chunks", len(embs), len(batch))
+	}
+
+	for i := range batch {
+		batch[i].Embedding = embs[i]
+	}
+	return p.Store.Add(ctx, batch)
+}
+
+// IngestNode: runs an IngestPipeline over a DocumentLoader as part of a flow.
+type IngestNode struct {
+	BaseNode
+	Loader    DocumentLoader
+	Pipeline  *IngestPipeline
+	ResultKey string // where IngestStats is stored
+}
+
+func NewIngestNode(loader DocumentLoader, pipeline *IngestPipeline, resultKey string) *IngestNode {
+	return &IngestNode{
+		BaseNode:  NewBaseNode(),
+		Loader:    loader,
+		Pipeline:  pipeline,
+		ResultKey: resultKey,
+	}
+}
+
+func (n *IngestNode) TypeName() string { return "IngestNode" }
+
+func (n *IngestNode) Run(ctx context.Context, mem *Memory) ([]Trigger, error) {
+	stats, err := n.Pipeline.Run(ctx, n.Loader)
+	if err != nil {
+		return nil, fmt.Errorf("IngestNode: %w", err)
+	}
+
+	mem.Local[n.ResultKey] = stats
+
+	return []Trigger{
+		{Action: DefaultAction, ForkingData: map[string]any{}},
+	}, nil
+}
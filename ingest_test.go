@@ -0,0 +1,163 @@
+package nodechain
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// flakyEmbedder fails its first failures calls, and every call with a text
+// containing "bad".
+type flakyEmbedder struct {
+	*HashingEmbedder
+
+	mu       sync.Mutex
+	failures int
+	calls    int
+}
+
+func (e *flakyEmbedder) EmbedText(ctx context.Context, texts []string) ([][]float32, error) {
+	e.mu.Lock()
+	e.calls++
+	fail := e.failures > 0
+	if fail {
+		e.failures--
+	}
+	e.mu.Unlock()
+
+	if fail {
+		return nil, errors.New("temporarily unavailable")
+	}
+	for _, t := range texts {
+		if strings.Contains(t, "bad") {
+			return nil, errors.New("rejected")
+		}
+	}
+	return e.HashingEmbedder.EmbedText(ctx, texts)
+}
+
+func TestIngestPipeline(t *testing.T) {
+	tests := []struct {
+		name       string
+		texts      []string
+		failures   int
+		maxRetries int
+		wantErr    string
+		stored     []string // chunk IDs in the store afterwards
+		calls      int
+	}{
+		{
+			name:   "batches",
+			texts:  []string{"one", "two", "three"},
+			stored: []string{"0:0", "1:0", "2:0"},
+			calls:  2,
+		},
+		{
+			name:       "retried batch",
+			texts:      []string{"one", "two", "three"},
+			failures:   2,
+			maxRetries: 3,
+			stored:     []string{"0:0", "1:0", "2:0"},
+			calls:      4,
+		},
+		{
+			name:       "retries exhausted",
+			texts:      []string{"one"},
+			failures:   2,
+			maxRetries: 2,
+			wantErr:    "temporarily unavailable",
+			calls:      2,
+		},
+		{
+			name:       "failed batch keeps earlier ones",
+			texts:      []string{"one", "two", "bad", "four", "five"},
+			maxRetries: 2,
+			wantErr:    "batch starting at '2:0': rejected",
+			stored:     []string{"0:0", "1:0"},
+			calls:      3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			embedder := &flakyEmbedder{HashingEmbedder: NewHashingEmbedder(16), failures: tt.failures}
+			store := NewInMemoryVectorStore()
+			p := NewIngestPipeline(embedder, store)
+			p.Splitter = nil
+			p.BatchSize, p.Concurrency = 2, 1
+			p.MaxRetries, p.RetryDelay = tt.maxRetries, 0
+
+			var docs []Document
+			for i, text := range tt.texts {
+				docs = append(docs, Document{ID: string(rune('0' + i)), Text: text})
+			}
+			stats, err := p.Ingest(context.Background(), docs)
+			if tt.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+			if stats.Chunks != len(tt.texts) || stats.Batches != (len(tt.texts)+1)/2 {
+				t.Errorf("stats %+v", stats)
+			}
+			if embedder.calls != tt.calls {
+				t.Errorf("%d EmbedText calls, want %d", embedder.calls, tt.calls)
+			}
+
+			got, _ := store.Search(context.Background(), embedQuery(t, embedder.HashingEmbedder, "x"), 10)
+			ids := docIDs(got)
+			slices.Sort(ids)
+			if !slices.Equal(ids, tt.stored) {
+				t.Errorf("stored %v, want %v", ids, tt.stored)
+			}
+		})
+	}
+}
+
+func TestIngestPipelineConcurrentChunks(t *testing.T) {
+	e := NewHashingEmbedder(16)
+	store := NewInMemoryVectorStore()
+	parents := NewInMemoryDocumentStore()
+	p := NewIngestPipeline(e, store)
+	p.Splitter = NewRecursiveSplitter(20, 0)
+	p.Parents = parents
+	p.BatchSize, p.Concurrency = 3, 4
+
+	text := strings.Repeat("word ", 40)
+	docs := []Document{
+		{ID: "a", Text: text, Metadata: map[string]any{"source": "a.txt"}},
+		{ID: "b", Text: text},
+		{ID: "blank", Text: "  \n"},
+	}
+	stats, err := p.Ingest(context.Background(), docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Documents != 3 || stats.Chunks < 4 {
+		t.Fatalf("stats %+v", stats)
+	}
+
+	got, err := store.Search(context.Background(), embedQuery(t, e, "word"), stats.Chunks+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != stats.Chunks {
+		t.Fatalf("%d chunks stored, want %d", len(got), stats.Chunks)
+	}
+	for _, d := range got {
+		if d.Metadata[MetadataEmbedder] != e.Name() || d.Metadata["chunk_count"] == nil {
+			t.Fatalf("chunk metadata %v", d.Metadata)
+		}
+		if d.Metadata["parent_id"] == "a" && d.Metadata["source"] != "a.txt" {
+			t.Fatalf("chunk lost its source metadata: %v", d.Metadata)
+		}
+	}
+
+	stored, _ := parents.Get(context.Background(), []string{"a", ChunkID("b", 0)})
+	if len(stored) != 2 || stored[1].Embedding != nil {
+		t.Fatalf("parent store holds %+v", stored)
+	}
+}
@@ -35,15 +35,20 @@ func (n *LLMNode) Run(ctx context.Context, mem *Memory) ([]Trigger, error) {
 		return nil, fmt.Errorf("LLMNode: no prompt found at key '%s'", n.InputKey)
 	}
 
-	prompt, ok := raw.(string)
-	if !ok {
-		return nil, errors.New("LLMNode: prompt must be a string")
+	var msgs []LLMMessage
+	switch prompt := raw.(type) {
+	case string:
+		msgs = []LLMMessage{
+			{Role: "system", Content: n.System},
+			{Role: "user", Content: prompt},
+		}
+	case []LLMMessage:
+		msgs = prompt
+	default:
+		return nil, errors.New("LLMNode: prompt must be a string or []LLMMessage")
 	}
 
-	resp, err := n.Provider.Chat(ctx, []LLMMessage{
-		{Role: "system", Content: n.System},
-		{Role: "user", Content: prompt},
-	})
+	resp, err := n.Provider.Chat(ctx, msgs)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,234 @@
+package nodechain
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DocumentLoader produces source documents for ingestion. Loaded documents
+// have no embeddings; IngestPipeline splits and embeds them.
+type DocumentLoader interface {
+	Load(ctx context.Context) ([]Document, error)
+}
+
+// FileLoaderFunc turns the contents of one file into documents. path is the
+// file's path relative to the loader root and is used for IDs and metadata.
+type FileLoaderFunc func(path string, data []byte) ([]Document, error)
+
+// DirectoryLoader walks Root and loads every file whose extension has a
+// registered FileLoaderFunc. Hidden files and directories are skipped.
+type DirectoryLoader struct {
+	Root    string
+	Loaders map[string]FileLoaderFunc // keyed by lower-case extension, e.g. ".md"
+}
+
+func NewDirectoryLoader(root string) *DirectoryLoader {
+	return &DirectoryLoader{
+		Root: root,
+		Loaders: map[string]FileLoaderFunc{
+			".txt":   LoadTextFile,
+			".md":    LoadMarkdownFile,
+			".html":  LoadHTMLFile,
+			".htm":   LoadHTMLFile,
+			".jsonl": LoadJSONLFile,
+		},
+	}
+}
+
+func (l *DirectoryLoader) Load(ctx context.Context) ([]Document, error) {
+	var docs []Document
+
+	err := filepath.WalkDir(l.Root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if path != l.Root && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		load, ok := l.Loaders[strings.ToLower(filepath.Ext(path))]
+		if !ok {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(l.Root, path)
+		if err != nil {
+			rel = path
+		}
+		rel = filepath.ToSlash(rel)
+
+		loaded, err := load(rel, data)
+		if err != nil {
+			return fmt.Errorf("DirectoryLoader: %s: %w", rel, err)
+		}
+		docs = append(docs, loaded...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+// StaticLoader returns a fixed set of documents.
+type StaticLoader []Document
+
+func (l StaticLoader) Load(ctx context.Context) ([]Document, error) {
+	return l, nil
+}
+
+func LoadTextFile(path string, data []byte) ([]Document, error) {
+	return []Document{newSourceDocument(path, "text", string(data))}, nil
+}
+
+func LoadMarkdownFile(path string, data []byte) ([]Document, error) {
+	return []Document{newSourceDocument(path, "markdown", string(data))}, nil
+}
+
+var (
+	htmlDropRe  = regexp.MustCompile(`(?i)<(script|style|noscript|head)\b[^>]*>|<!--`)
+	htmlCloseRe = map[string]*regexp.Regexp{
+		"script":   regexp.MustCompile(`(?i)</script\s*>`),
+		"style":    regexp.MustCompile(`(?i)</style\s*>`),
+		"noscript": regexp.MustCompile(`(?i)</noscript\s*>`),
+		"head":     regexp.MustCompile(`(?i)</head\s*>`),
+	}
+	htmlBlockRe = regexp.MustCompile(`(?i)</?(p|div|br|li|ul|ol|h[1-6]|tr|table|section|article|header|footer|pre|blockquote)\b[^>]*>`)
+	htmlTagRe   = regexp.MustCompile(`<[^>]*>`)
+	blankRunRe  = regexp.MustCompile(`\n\s*\n\s*`)
+	spaceRunRe  = regexp.MustCompile(`[ \t\r\f]+`)
+)
+
+// LoadHTMLFile extracts readable text from an HTML page. Scripts, styles and
+// comments are dropped and block-level elements become line breaks.
+func LoadHTMLFile(path string, data []byte) ([]Document, error) {
+	s := dropHTMLElements(string(data))
+	s = htmlBlockRe.ReplaceAllString(s, "\n")
+	s = htmlTagRe.ReplaceAllString(s, " ")
+	s = html.UnescapeString(s)
+	s = spaceRunRe.ReplaceAllString(s, " ")
+	s = blankRunRe.ReplaceAllString(s, "\n\n")
+
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	text := strings.TrimSpace(strings.Join(lines, "\n"))
+
+	return []Document{newSourceDocument(path, "html", text)}, nil
+}
+
+// dropHTMLElements removes comments and script, style, noscript and head
+// elements. Each element ends at the first closing tag of its own name, as
+// script and style contents are raw text, and one that is never closed runs
+// to the end of the page, as it does in a browser.
+func dropHTMLElements(s string) string {
+	var b strings.Builder
+	for {
+		loc := htmlDropRe.FindStringSubmatchIndex(s)
+		if loc == nil {
+			b.WriteString(s)
+			return b.String()
+		}
+		b.WriteString(s[:loc[0]])
+		b.WriteByte(' ')
+
+		rest := s[loc[1]:]
+		if loc[2] < 0 { // comment
+			_, after, ok := strings.Cut(rest, "-->")
+			if !ok {
+				return b.String()
+			}
+			s = after
+			continue
+		}
+		end := htmlCloseRe[strings.ToLower(s[loc[2]:loc[3]])].FindStringIndex(rest)
+		if end == nil {
+			return b.String()
+		}
+		s = rest[end[1]:]
+	}
+}
+
+// LoadJSONLFile reads one document per line. Each line is an object with a
+// "text" field and optional "id" and "metadata" fields.
+func LoadJSONLFile(path string, data []byte) ([]Document, error) {
+	var docs []Document
+
+	sc := bufio.NewScanner(strings.NewReader(string(data)))
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	line := 0
+	for sc.Scan() {
+		line++
+		raw := strings.TrimSpace(sc.Text())
+		if raw == "" {
+			continue
+		}
+
+		var rec struct {
+			ID       string         `json:"id"`
+			Text     string         `json:"text"`
+			Metadata map[string]any `json:"metadata"`
+		}
+		if err := json.Unmarshal([]byte(raw), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		d := newSourceDocument(fmt.Sprintf("%s#L%d", path, line), "jsonl", rec.Text)
+		if rec.ID != "" {
+			d.ID = rec.ID
+		}
+		for k, v := range rec.Metadata {
+			d.Metadata[k] = v
+		}
+		d.Metadata["source"] = path
+		d.Metadata["line"] = line
+		docs = append(docs, d)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+func newSourceDocument(source, format, text string) Document {
+	return Document{
+		ID:   stableID(source),
+		Text: text,
+		Metadata: map[string]any{
+			"source": source,
+			"format": format,
+		},
+	}
+}
+
+// stableID derives a short deterministic ID from a source location, so
+// re-ingesting the same file produces the same document IDs.
+func stableID(source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,172 @@
+package nodechain
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadHTMLFile(t *testing.T) {
+	tests := []struct {
+		name, html, want string
+	}{
+		{
+			name: "blocks and entities",
+			html: "<h1>Title</h1><p>Fish &amp; chips</p><ul><li>one</li><li>two</li></ul>",
+			want: "Title\n\nFish & chips\n\none\n\ntwo",
+		},
+		{
+			name: "script and style",
+			html: "<p>before</p><script>var x = 1;</script><STYLE type=\"text/css\">p {}</STYLE><p>after</p>",
+			want: "before\n\nafter",
+		},
+		{
+			name: "nested in head",
+			html: "<html><head><title>t</title><style>a{}</style><script>go()</script></head><body>body text</body></html>",
+			want: "body text",
+		},
+		{
+			name: "other closing tag inside script",
+			html: "<script>document.write('</style>leak')</script>kept",
+			want: "kept",
+		},
+		{
+			name: "style inside noscript",
+			html: "<noscript><style>a{}</style>enable JavaScript</noscript>kept",
+			want: "kept",
+		},
+		{
+			name: "mismatched closing tag",
+			html: "<p>kept</p><script>hidden</style>still script",
+			want: "kept",
+		},
+		{
+			name: "unclosed comment",
+			html: "<p>kept</p><!-- hidden",
+			want: "kept",
+		},
+		{
+			name: "comment",
+			html: "a<!-- <script> -->b",
+			want: "a b",
+		},
+		{
+			name: "header is not head",
+			html: "<header>site</header><p>text</p>",
+			want: "site\n\ntext",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docs, err := LoadHTMLFile("page.html", []byte(tt.html))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(docs) != 1 || docs[0].Text != tt.want {
+				t.Fatalf("text %q, want %q", docs[0].Text, tt.want)
+			}
+			if docs[0].Metadata["source"] != "page.html" || docs[0].Metadata["format"] != "html" {
+				t.Fatalf("metadata %v", docs[0].Metadata)
+			}
+		})
+	}
+}
+
+func TestLoadJSONLFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		ids     []string
+		texts   []string
+		wantErr bool
+	}{
+		{
+			name:  "ids and generated ids",
+			data:  "{\"id\": \"a\", \"text\": \"first\"}\n\n{\"text\": \"third line\"}\n",
+			ids:   []string{"a", stableID("data.jsonl#L3")},
+			texts: []string{"first", "third line"},
+		},
+		{
+			name:    "bad line",
+			data:    "{\"text\": \"ok\"}\nnot json\n",
+			wantErr: true,
+		},
+		{name: "empty", data: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docs, err := LoadJSONLFile("data.jsonl", []byte(tt.data))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(docs) != len(tt.ids) {
+				t.Fatalf("%d documents, want %d", len(docs), len(tt.ids))
+			}
+			for i, d := range docs {
+				if d.ID != tt.ids[i] || d.Text != tt.texts[i] {
+					t.Errorf("document %d: %q %q, want %q %q", i, d.ID, d.Text, tt.ids[i], tt.texts[i])
+				}
+			}
+		})
+	}
+
+	docs, err := LoadJSONLFile("data.jsonl", []byte(`{"text": "x", "metadata": {"source": "spoofed", "lang": "en"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := docs[0].Metadata; m["source"] != "data.jsonl" || m["lang"] != "en" || m["line"] != 1 {
+		t.Fatalf("metadata %v", m)
+	}
+}
+
+func TestDirectoryLoader(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"a.txt":            "text",
+		"docs/b.md":        "# markdown",
+		"docs/c.HTML":      "<p>html</p>",
+		".hidden/d.txt":    "skipped",
+		"docs/.e.txt":      "skipped",
+		"docs/image.png":   "skipped",
+		"data/items.jsonl": `{"id": "item", "text": "jsonl"}`,
+	}
+	for name, data := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	docs, err := NewDirectoryLoader(root).Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, d := range docs {
+		got[d.Metadata["source"].(string)] = d.Text
+	}
+	want := map[string]string{
+		"a.txt":            "text",
+		"data/items.jsonl": "jsonl",
+		"docs/b.md":        "# markdown",
+		"docs/c.HTML":      "html",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("loaded %v, want %v", got, want)
+	}
+	for source, text := range want {
+		if got[source] != text {
+			t.Errorf("%s: %q, want %q", source, got[source], text)
+		}
+	}
+}
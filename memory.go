@@ -28,3 +28,12 @@ func (m *Memory) Clone(fork map[string]any) *Memory {
 		Local:  newLocal,
 	}
 }
+
+// Get looks a key up in Local first, falling back to Global.
+func (m *Memory) Get(key string) (any, bool) {
+	if v, ok := m.Local[key]; ok {
+		return v, true
+	}
+	v, ok := m.Global[key]
+	return v, ok
+}
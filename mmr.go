@@ -0,0 +1,54 @@
+package nodechain
+
+// MaxMarginalRelevance selects k documents from candidates, trading off
+// relevance to the query against similarity to documents already selected.
+// lambda=1 is pure relevance, lambda=0 is pure diversity. Candidates without
+// an embedding of the query's dimension are skipped.
+func MaxMarginalRelevance(query []float32, candidates []Document, k int, lambda float64) []Document {
+	var pool []Document
+	var relevance []float64
+	for _, d := range candidates {
+		if len(d.Embedding) != len(query) {
+			continue
+		}
+		pool = append(pool, d)
+		relevance = append(relevance, cosineSimilarity(query, d.Embedding))
+	}
+
+	if k > len(pool) {
+		k = len(pool)
+	}
+
+	selected := make([]Document, 0, k)
+	used := make([]bool, len(pool))
+	// maxSim[i] is the highest similarity of pool[i] to any selected doc.
+	maxSim := make([]float64, len(pool))
+
+	for len(selected) < k {
+		best := -1
+		var bestScore float64
+		for i := range pool {
+			if used[i] {
+				continue
+			}
+			score := lambda*relevance[i] - (1-lambda)*maxSim[i]
+			if best < 0 || score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+
+		used[best] = true
+		selected = append(selected, pool[best])
+
+		for i := range pool {
+			if used[i] {
+				continue
+			}
+			if s := cosineSimilarity(pool[i].Embedding, pool[best].Embedding); len(selected) == 1 || s > maxSim[i] {
+				maxSim[i] = s
+			}
+		}
+	}
+
+	return selected
+}
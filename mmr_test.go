@@ -0,0 +1,114 @@
+package nodechain
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestMaxMarginalRelevance(t *testing.T) {
+	e := NewHashingEmbedder(256)
+	docs := embedDocs(t, e,
+		"python virtualenv setup guide",
+		"python virtualenv setup guide for beginners",
+		"installing python packages with pip",
+		"weather forecast for tomorrow",
+	)
+	query := embedQuery(t, e, "python virtualenv setup")
+
+	tests := []struct {
+		name   string
+		k      int
+		lambda float64
+		want   []string
+	}{
+		{"pure relevance keeps near-duplicates", 2, 1, []string{"0", "1"}},
+		{"diversity skips the near-duplicate", 2, 0.5, []string{"0", "2"}},
+		{"k larger than candidates", 10, 0.5, nil}, // all four, any order
+		{"k zero", 0, 0.5, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := docIDs(MaxMarginalRelevance(query, docs, tt.k, tt.lambda))
+			if tt.want == nil {
+				if len(got) != len(docs) {
+					t.Fatalf("got %v, want all %d docs", got, len(docs))
+				}
+				return
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxMarginalRelevanceSkipsMismatchedEmbeddings(t *testing.T) {
+	e := NewHashingEmbedder(16)
+	docs := embedDocs(t, e, "alpha", "beta")
+	docs = append(docs, Document{ID: "bad", Embedding: []float32{1, 0}}, Document{ID: "none"})
+
+	got := docIDs(MaxMarginalRelevance(embedQuery(t, e, "alpha"), docs, 4, 0.7))
+	if !slices.Equal(got, []string{"0", "1"}) {
+		t.Fatalf("got %v", got)
+	}
+}
+
+// searchRecorder records the k of each Search on the wrapped store.
+type searchRecorder struct {
+	VectorStore
+	ks []int
+}
+
+func (s *searchRecorder) Search(ctx context.Context, query []float32, k int) ([]Document, error) {
+	s.ks = append(s.ks, k)
+	return s.VectorStore.Search(ctx, query, k)
+}
+
+func TestRetrieveNodeMMR(t *testing.T) {
+	e := NewHashingEmbedder(256)
+	inner := NewInMemoryVectorStore()
+	err := inner.Add(context.Background(), embedDocs(t, e,
+		"python virtualenv setup guide",
+		"python virtualenv setup guide for beginners",
+		"python virtualenv setup guide for experts",
+		"installing python packages with pip",
+		"weather forecast for tomorrow",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := embedQuery(t, e, "python virtualenv setup")
+
+	tests := []struct {
+		name      string
+		mmr       bool
+		fetchK    int
+		wantFetch int
+		want      []string
+	}{
+		{"off", false, 0, 2, []string{"0", "2"}},
+		{"default fetch", true, 0, 8, []string{"0", "3"}},
+		{"explicit fetch", true, 5, 5, []string{"0", "3"}},
+		// with no more candidates than K there is nothing to demote
+		{"fetch equal to k", true, 2, 2, []string{"0", "2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &searchRecorder{VectorStore: inner}
+			n := NewRetrieveNode(store, "emb", "docs", 2)
+			n.MMR = tt.mmr
+			n.FetchK = tt.fetchK
+			mem := NewMemory(map[string]any{"emb": query})
+			if _, err := n.Run(context.Background(), mem); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(store.ks, []int{tt.wantFetch}) {
+				t.Fatalf("searched with k %v, want %d", store.ks, tt.wantFetch)
+			}
+			if got := docIDs(mem.Local["docs"].([]Document)); !slices.Equal(got, tt.want) {
+				t.Fatalf("documents %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
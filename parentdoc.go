@@ -0,0 +1,222 @@
+package nodechain
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DocumentStore holds documents by ID, without search. It backs
+// small-to-big retrieval: chunks are searched in a VectorStore and their
+// parents (or neighbouring chunks) are fetched from here.
+type DocumentStore interface {
+	Put(ctx context.Context, docs []Document) error
+	// Get returns the documents found, in the order of ids; missing IDs are
+	// skipped.
+	Get(ctx context.Context, ids []string) ([]Document, error)
+}
+
+type InMemoryDocumentStore struct {
+	mu   sync.RWMutex
+	docs map[string]Document
+}
+
+func NewInMemoryDocumentStore() *InMemoryDocumentStore {
+	return &InMemoryDocumentStore{
+		docs: make(map[string]Document),
+	}
+}
+
+func (s *InMemoryDocumentStore) Put(ctx context.Context, docs []Document) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range docs {
+		if d.ID == "" {
+			return fmt.Errorf("DocumentStore: document without ID")
+		}
+		s.docs[d.ID] = d
+	}
+	return nil
+}
+
+func (s *InMemoryDocumentStore) Get(ctx context.Context, ids []string) ([]Document, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []Document
+	for _, id := range ids {
+		if d, ok := s.docs[id]; ok {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+// ChunkID is the ID IngestPipeline gives chunk i of the document parentID.
+// Parents and their chunks share one DocumentStore, so a source document
+// whose own ID looks like a chunk ID (e.g. "a:0" next to a document "a")
+// overwrites, or is overwritten by, that chunk.
+func ChunkID(parentID string, i int) string {
+	return fmt.Sprintf("%s:%d", parentID, i)
+}
+
+// chunkPosition reads the parent ID and chunk index IngestPipeline records
+// in chunk metadata. Indexes may be float64 after a JSON round trip.
+func chunkPosition(d Document) (string, int, bool) {
+	parent, _ := d.Metadata["parent_id"].(string)
+	if parent == "" {
+		return "", 0, false
+	}
+	switch idx := d.Metadata["chunk_index"].(type) {
+	case int:
+		return parent, idx, true
+	case float64:
+		return parent, int(idx), true
+	}
+	return "", 0, false
+}
+
+// ExpandToParents replaces each chunk with its parent document, keeping the
+// rank of the best-scoring chunk and dropping duplicates. Chunks without a
+// parent, or whose parent is not in store, are kept as they are.
+func ExpandToParents(ctx context.Context, store DocumentStore, chunks []Document) ([]Document, error) {
+	var ids []string
+	seen := map[string]bool{}
+	for _, c := range chunks {
+		parent, _, ok := chunkPosition(c)
+		if ok && !seen[parent] {
+			seen[parent] = true
+			ids = append(ids, parent)
+		}
+	}
+
+	found, err := store.Get(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	parents := make(map[string]Document, len(found))
+	for _, p := range found {
+		parents[p.ID] = p
+	}
+
+	var out []Document
+	emitted := map[string]bool{}
+	for _, c := range chunks {
+		id := c.ID
+		doc := c
+		if parent, _, ok := chunkPosition(c); ok {
+			if p, ok := parents[parent]; ok {
+				id, doc = parent, p
+			}
+		}
+		if emitted[id] {
+			continue
+		}
+		emitted[id] = true
+		out = append(out, doc)
+	}
+	return out, nil
+}
+
+// ExpandToWindows replaces each chunk with a passage made of the chunk and up
+// to window neighbours on either side, fetched from store by ChunkID.
+// Overlapping windows from the same parent are merged into one passage,
+// ranked at its best chunk. Overlap between consecutive chunks is removed.
+func ExpandToWindows(ctx context.Context, store DocumentStore, chunks []Document, window int) ([]Document, error) {
+	type span struct {
+		parent string
+		lo, hi int
+		first  Document // highest-ranked chunk, for metadata
+	}
+
+	var spans []*span
+	for _, c := range chunks {
+		parent, idx, ok := chunkPosition(c)
+		if !ok {
+			spans = append(spans, &span{lo: -1, first: c})
+			continue
+		}
+
+		spans = append(spans, &span{parent: parent, lo: max(idx-window, 0), hi: idx + window, first: c})
+	}
+
+	// Merge spans that overlap or touch into the higher-ranked one. After a
+	// span grows it is compared with the later spans again, since it may now
+	// reach one it did not before.
+	for i := 0; i < len(spans); i++ {
+		a := spans[i]
+		if a.lo < 0 {
+			continue
+		}
+		for j := i + 1; j < len(spans); j++ {
+			b := spans[j]
+			if b.parent == a.parent && b.lo >= 0 && b.lo <= a.hi+1 && b.hi >= a.lo-1 {
+				a.lo, a.hi = min(a.lo, b.lo), max(a.hi, b.hi)
+				spans = slices.Delete(spans, j, j+1)
+				j = i
+			}
+		}
+	}
+
+	var out []Document
+	for _, s := range spans {
+		if s.lo < 0 {
+			out = append(out, s.first)
+			continue
+		}
+
+		ids := make([]string, 0, s.hi-s.lo+1)
+		for i := s.lo; i <= s.hi; i++ {
+			ids = append(ids, ChunkID(s.parent, i))
+		}
+		parts, err := store.Get(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		if len(parts) == 0 {
+			out = append(out, s.first)
+			continue
+		}
+
+		sort.SliceStable(parts, func(i, j int) bool {
+			_, a, _ := chunkPosition(parts[i])
+			_, b, _ := chunkPosition(parts[j])
+			return a < b
+		})
+
+		text := parts[0].Text
+		for _, p := range parts[1:] {
+			text = joinOverlapping(text, p.Text)
+		}
+		_, first, _ := chunkPosition(parts[0])
+		_, last, _ := chunkPosition(parts[len(parts)-1])
+
+		meta := make(map[string]any, len(s.first.Metadata)+1)
+		for k, v := range s.first.Metadata {
+			meta[k] = v
+		}
+		meta["chunk_range"] = [2]int{first, last}
+
+		out = append(out, Document{
+			ID:       fmt.Sprintf("%s:%d-%d", s.parent, first, last),
+			Text:     text,
+			Metadata: meta,
+		})
+	}
+	return out, nil
+}
+
+// joinOverlapping appends b to a, dropping the longest prefix of b that a
+// already ends with (chunk overlap). Matches shorter than minOverlap bytes are
+// treated as coincidence, and the two are joined with a newline.
+func joinOverlapping(a, b string) string {
+	const minOverlap = 12
+	for k := min(len(a), len(b)); k >= minOverlap; k-- {
+		if strings.HasSuffix(a, b[:k]) {
+			return a + b[k:]
+		}
+	}
+	return a + "\n" + b
+}
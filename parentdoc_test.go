@@ -0,0 +1,166 @@
+package nodechain
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func chunkDoc(parent string, i int) Document {
+	return Document{
+		ID:       ChunkID(parent, i),
+		Text:     fmt.Sprintf("%s chunk %d", parent, i),
+		Metadata: map[string]any{"parent_id": parent, "chunk_index": i},
+	}
+}
+
+func TestExpandToWindows(t *testing.T) {
+	store := NewInMemoryDocumentStore()
+	var all []Document
+	for i := range 10 {
+		all = append(all, chunkDoc("p", i), chunkDoc("q", i))
+	}
+	if err := store.Put(context.Background(), all); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		chunks []Document
+		want   []string
+	}{
+		{
+			name:   "separate windows",
+			chunks: []Document{chunkDoc("p", 1), chunkDoc("p", 8)},
+			want:   []string{"p:0-2", "p:7-9"},
+		},
+		{
+			name:   "overlapping windows",
+			chunks: []Document{chunkDoc("p", 4), chunkDoc("p", 5)},
+			want:   []string{"p:3-6"},
+		},
+		{
+			// 0-1 and 5-7 only touch once 2-4 has extended the first
+			name:   "windows joined by a later chunk",
+			chunks: []Document{chunkDoc("p", 0), chunkDoc("p", 6), chunkDoc("p", 3)},
+			want:   []string{"p:0-7"},
+		},
+		{
+			name:   "other parents and loose chunks stay apart",
+			chunks: []Document{chunkDoc("q", 2), {ID: "loose"}, chunkDoc("p", 2), chunkDoc("q", 3)},
+			want:   []string{"q:1-4", "loose", "p:1-3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExpandToWindows(context.Background(), store, tt.chunks, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, d := range got {
+				ids = append(ids, d.ID)
+			}
+			if strings.Join(ids, " ") != strings.Join(tt.want, " ") {
+				t.Fatalf("passages %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandToParents(t *testing.T) {
+	store := NewInMemoryDocumentStore()
+	parents := []Document{{ID: "p", Text: "parent p"}, {ID: "q", Text: "parent q"}}
+	if err := store.Put(context.Background(), parents); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		chunks []Document
+		want   []string
+	}{
+		{
+			name:   "chunks sharing a parent",
+			chunks: []Document{chunkDoc("p", 3), chunkDoc("q", 0), chunkDoc("p", 1)},
+			want:   []string{"p", "q"},
+		},
+		{
+			name:   "missing parent keeps the chunk",
+			chunks: []Document{chunkDoc("gone", 2), chunkDoc("q", 1), chunkDoc("gone", 2)},
+			want:   []string{"gone:2", "q"},
+		},
+		{
+			name:   "chunks without a parent",
+			chunks: []Document{{ID: "loose"}, chunkDoc("p", 0)},
+			want:   []string{"loose", "p"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExpandToParents(context.Background(), store, tt.chunks)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ids := docIDs(got); !slices.Equal(ids, tt.want) {
+				t.Fatalf("documents %v, want %v", ids, tt.want)
+			}
+			for _, d := range got {
+				if d.ID == "p" && d.Text != "parent p" {
+					t.Fatalf("parent text %q", d.Text)
+				}
+			}
+		})
+	}
+}
+
+func TestRetrieveNodeParents(t *testing.T) {
+	e := NewHashingEmbedder(256)
+	texts := map[string][]string{
+		"a": {"zebra yak wolf", "apple banana cherry", "apple banana grape", "lion tiger bear"},
+		"b": {"river lake ocean", "cloud rain snow", "desk chair lamp", "road bridge tunnel"},
+	}
+	vectors := NewInMemoryVectorStore()
+	parents := NewInMemoryDocumentStore()
+	for _, parent := range []string{"a", "b"} {
+		chunks := embedDocs(t, e, texts[parent]...)
+		for i := range chunks {
+			chunks[i].ID = ChunkID(parent, i)
+			chunks[i].Metadata = map[string]any{"parent_id": parent, "chunk_index": i}
+		}
+		if err := vectors.Add(context.Background(), chunks); err != nil {
+			t.Fatal(err)
+		}
+		doc := Document{ID: parent, Text: strings.Join(texts[parent], " ")}
+		if err := parents.Put(context.Background(), append(chunks, doc)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		parents DocumentStore
+		window  int
+		want    []string
+	}{
+		{"chunks", nil, 0, []string{"a:1", "a:2"}},
+		{"parents", parents, 0, []string{"a"}},
+		{"window", parents, 1, []string{"a:0-3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := NewRetrieveNode(vectors, "emb", "docs", 2)
+			n.Parents = tt.parents
+			n.Window = tt.window
+			mem := NewMemory(map[string]any{"emb": embedQuery(t, e, "apple banana cherry")})
+			if _, err := n.Run(context.Background(), mem); err != nil {
+				t.Fatal(err)
+			}
+			if ids := docIDs(mem.Local["docs"].([]Document)); !slices.Equal(ids, tt.want) {
+				t.Fatalf("documents %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
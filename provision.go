@@ -0,0 +1,304 @@
+package nodechain
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ProvisionSpec declares how to prepare a DockerManager's image: packages
+// to install, setup commands to run and files to seed. Start builds it in a
+// throwaway container that runs as root with network access, commits the
+// result, and starts the sandbox from that image with the manager's usual
+// limits, so setup never needs the sandbox itself to be privileged.
+//
+// Files under /workspace are copied into the workspace on every Start
+// (committed images do not include the bind mount); other files are baked
+// into the image.
+type ProvisionSpec struct {
+	Image    string            `json:"image,omitempty"`    // base image; DockerManager.Image if empty
+	Packages []string          `json:"packages,omitempty"` // installed with apt-get, apk or dnf
+	Setup    []string          `json:"setup,omitempty"`    // shell commands run as root, in order
+	Env      map[string]string `json:"env,omitempty"`      // set on the sandbox container
+	Files    map[string]string `json:"files,omitempty"`    // sandbox path -> host file or directory
+
+	// Cache reuses the image from an earlier build of the same spec, tagged
+	// by its hash. The hash covers the base image name, not its contents,
+	// so a moving tag such as ubuntu:latest is not re-pulled.
+	Cache bool `json:"cache,omitempty"`
+
+	StepTimeout time.Duration `json:"-"` // per package install or setup command; default 10m
+}
+
+// ProvisionImageRepo is the repository provisioned images are tagged in.
+const ProvisionImageRepo = "nodechain-provisioned"
+
+// ProvisionError reports the step that failed while building a spec.
+type ProvisionError struct {
+	Step     string // "packages", "setup[2]", "files", "commit", ...
+	Command  string
+	ExitCode int
+	Output   string // combined stdout and stderr, truncated
+	Err      error
+}
+
+func (e *ProvisionError) Error() string {
+	msg := "provisioning failed at " + e.Step
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	} else {
+		msg += fmt.Sprintf(": exit status %d", e.ExitCode)
+	}
+	if e.Command != "" {
+		msg += "\ncommand: " + e.Command
+	}
+	if out := strings.TrimSpace(e.Output); out != "" {
+		msg += "\n" + out
+	}
+	return msg
+}
+
+func (e *ProvisionError) Unwrap() error { return e.Err }
+
+// Hash identifies the image a spec builds from base. It covers everything
+// baked into the image, including the contents of seeded files.
+func (s *ProvisionSpec) Hash(base string) (string, error) {
+	h := sha256.New()
+	image := s.Image
+	if image == "" {
+		image = base
+	}
+	enc := json.NewEncoder(h)
+	enc.Encode([]any{"v1", image, s.Packages, s.Setup})
+
+	for _, dst := range s.imageFiles() {
+		fmt.Fprintf(h, "file %s\n", dst)
+		if err := hashPath(h, s.Files[dst]); err != nil {
+			return "", fmt.Errorf("hashing %s: %w", s.Files[dst], err)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
+
+// ImageTag returns the tag the spec's image is committed under.
+func (s *ProvisionSpec) ImageTag(base string) (string, error) {
+	hash, err := s.Hash(base)
+	if err != nil {
+		return "", err
+	}
+	return ProvisionImageRepo + ":" + hash, nil
+}
+
+// build returns the provisioned image, building it unless a cached one can
+// be used. name labels the build container.
+func (s *ProvisionSpec) build(ctx context.Context, base, name string) (string, error) {
+	image := s.Image
+	if image == "" {
+		image = base
+	}
+	tag, err := s.ImageTag(base)
+	if err != nil {
+		return "", &ProvisionError{Step: "hash", Err: err}
+	}
+	if s.Cache && exec.CommandContext(ctx, "docker", "image", "inspect", tag).Run() == nil {
+		return tag, nil
+	}
+
+	builder := name + "-build"
+	args := []string{"run", "-d", "--name=" + builder, "--network", "bridge", "--user", "0"}
+	for _, l := range []string{LabelManaged + "=true", LabelOwner + "=" + ownerID()} {
+		args = append(args, "--label", l)
+	}
+	args = append(args, "--entrypoint", "sleep", image, "infinity")
+	if out, err := exec.CommandContext(ctx, "docker", args...).CombinedOutput(); err != nil {
+		return "", &ProvisionError{Step: "start", Output: string(out), Err: err}
+	}
+	defer exec.Command("docker", "rm", "-f", builder).Run()
+
+	opts := ExecOptions{Timeout: s.StepTimeout}
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Minute
+	}
+	run := func(step, cmd string) error {
+		res, err := dockerExec(ctx, builder, []string{"-u", "0"}, []string{"sh", "-c", cmd}, opts)
+		if err != nil {
+			return &ProvisionError{Step: step, Command: cmd, Err: err}
+		}
+		if res.TimedOut {
+			return &ProvisionError{Step: step, Command: cmd, ExitCode: -1, Output: res.Stdout + res.Stderr,
+				Err: fmt.Errorf("timed out after %s", opts.Timeout)}
+		}
+		if res.ExitCode != 0 {
+			return &ProvisionError{Step: step, Command: cmd, ExitCode: res.ExitCode, Output: res.Stdout + res.Stderr}
+		}
+		return nil
+	}
+
+	if len(s.Packages) > 0 {
+		if err := run("packages", installPackagesScript(s.Packages)); err != nil {
+			return "", err
+		}
+	}
+
+	for _, key := range s.imageFiles() {
+		dst, src := path.Clean(key), s.Files[key]
+		if err := run("files", "mkdir -p -- "+shellQuote(path.Dir(dst))); err != nil {
+			return "", err
+		}
+		if out, err := exec.CommandContext(ctx, "docker", "cp", src, builder+":"+dst).CombinedOutput(); err != nil {
+			return "", &ProvisionError{Step: "files", Command: "docker cp " + src + " " + dst, Output: string(out), Err: err}
+		}
+	}
+
+	for i, cmd := range s.Setup {
+		if err := run(fmt.Sprintf("setup[%d]", i), cmd); err != nil {
+			return "", err
+		}
+	}
+
+	commit := exec.CommandContext(ctx, "docker", "commit",
+		"--change", "LABEL "+LabelManaged+"=true",
+		builder, tag)
+	if out, err := commit.CombinedOutput(); err != nil {
+		return "", &ProvisionError{Step: "commit", Output: string(out), Err: err}
+	}
+	return tag, nil
+}
+
+// seedWorkspace copies spec files under /workspace into the workspace.
+func (s *ProvisionSpec) seedWorkspace(workspace string) error {
+	for dst, src := range s.Files {
+		if !isWorkspacePath(dst) {
+			continue
+		}
+		host, err := resolveIn(workspace, dst)
+		if err != nil {
+			return &ProvisionError{Step: "files", Err: err}
+		}
+		if err := copyPath(src, host); err != nil {
+			return &ProvisionError{Step: "files", Command: "copy " + src + " " + dst, Err: err}
+		}
+	}
+	return nil
+}
+
+func (s *ProvisionSpec) envArgs() []string {
+	keys := make([]string, 0, len(s.Env))
+	for k := range s.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var args []string
+	for _, k := range keys {
+		args = append(args, "-e", k+"="+s.Env[k])
+	}
+	return args
+}
+
+// imageFiles returns the sorted Files keys that are baked into the image.
+func (s *ProvisionSpec) imageFiles() []string {
+	var out []string
+	for dst := range s.Files {
+		if !isWorkspacePath(dst) {
+			out = append(out, dst)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func isWorkspacePath(p string) bool {
+	if !path.IsAbs(p) {
+		return true
+	}
+	p = path.Clean(p)
+	return p == "/workspace" || strings.HasPrefix(p, "/workspace/")
+}
+
+// installPackagesScript installs pkgs with whichever package manager the
+// image has.
+func installPackagesScript(pkgs []string) string {
+	quoted := make([]string, len(pkgs))
+	for i, p := range pkgs {
+		quoted[i] = shellQuote(p)
+	}
+	list := strings.Join(quoted, " ")
+	return `set -e
+if command -v apt-get >/dev/null 2>&1; then
+	export DEBIAN_FRONTEND=noninteractive
+	apt-get update -y
+	apt-get install -y --no-install-recommends ` + list + `
+	rm -rf /var/lib/apt/lists/*
+elif command -v apk >/dev/null 2>&1; then
+	apk add --no-cache ` + list + `
+elif command -v dnf >/dev/null 2>&1; then
+	dnf install -y ` + list + `
+	dnf clean all
+else
+	echo "no supported package manager (apt-get, apk, dnf)" >&2
+	exit 127
+fi`
+}
+
+// hashPath writes the names, modes and contents under p to w, in a stable
+// order.
+func hashPath(w io.Writer, p string) error {
+	return filepath.WalkDir(p, func(file string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(p, file)
+		fmt.Fprintf(w, "%s %o\n", filepath.ToSlash(rel), info.Mode())
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(file)
+			if err != nil {
+				return err
+			}
+			io.WriteString(w, target+"\n")
+		case info.Mode().IsRegular():
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if _, err := io.Copy(w, f); err != nil {
+				return err
+			}
+			w.Write([]byte{'\n'})
+		}
+		return nil
+	})
+}
+
+// LoadProvisionSpec reads a spec from a JSON file.
+func LoadProvisionSpec(file string) (*ProvisionSpec, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var spec ProvisionSpec
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("provision spec %s: %w", file, err)
+	}
+	return &spec, nil
+}
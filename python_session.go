@@ -0,0 +1,520 @@
+package nodechain
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// PythonResult is the outcome of running code in a PythonSession.
+type PythonResult struct {
+	Stdout    string       `json:"stdout"`
+	Stderr    string       `json:"stderr"`
+	Value     string       `json:"value,omitempty"` // repr of the last expression, if not None
+	Error     *PythonError `json:"error,omitempty"`
+	Files     []PythonFile `json:"files,omitempty"` // workspace files created or modified
+	TimedOut  bool         `json:"timed_out,omitempty"`
+	Truncated bool         `json:"truncated,omitempty"`
+	Reset     bool         `json:"reset,omitempty"` // the interpreter was restarted and earlier state is gone
+}
+
+// PythonError is an exception raised by the code, including SyntaxError,
+// SystemExit and the KeyboardInterrupt used to stop code that timed out.
+type PythonError struct {
+	Type      string `json:"type"`
+	Message   string `json:"message"`
+	Traceback string `json:"traceback,omitempty"`
+}
+
+type PythonFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+const DefaultPythonValueChars = 10000
+
+// PythonSession keeps a Python interpreter running in a sandbox so
+// variables, imports and definitions persist between calls, like a notebook
+// kernel. Output written by the code, including by subprocesses, is captured
+// per call; the value of a trailing expression is returned as its repr.
+//
+// When code overruns its timeout it is interrupted with SIGINT, which keeps
+// the interpreter's state. If it does not stop within InterruptGrace the
+// interpreter is killed and the next call starts a fresh one (Reset).
+type PythonSession struct {
+	Sandbox        ShellSandbox
+	Python         []string // default python3
+	MaxValueChars  int      // default DefaultPythonValueChars
+	InterruptGrace time.Duration
+
+	mu      sync.Mutex
+	proc    *pythonProc
+	started bool
+	nextID  int
+}
+
+func NewPythonSession(sb ShellSandbox) *PythonSession {
+	return &PythonSession{
+		Sandbox:        sb,
+		Python:         []string{"python3"},
+		MaxValueChars:  DefaultPythonValueChars,
+		InterruptGrace: 3 * time.Second,
+	}
+}
+
+type pythonProc struct {
+	cmd    *exec.Cmd
+	cancel context.CancelFunc
+	stdin  io.WriteCloser
+	pid    int
+	lines  chan []byte   // protocol messages
+	dead   chan struct{} // closed when the protocol stream ends
+	stderr *cappedBuffer // the driver's own stderr, for startup failures
+}
+
+type pythonRequest struct {
+	ID        int    `json:"id"`
+	Code      string `json:"code"`
+	MaxOutput int    `json:"max_output"`
+	MaxValue  int    `json:"max_value"`
+}
+
+type pythonResponse struct {
+	PythonResult
+	ID    int  `json:"id"`
+	Ready bool `json:"ready"`
+	PID   int  `json:"pid"`
+}
+
+func (s *PythonSession) Exec(ctx context.Context, code string, opts ExecOptions) (PythonResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reset, err := s.ensure(ctx)
+	if err != nil {
+		return PythonResult{}, err
+	}
+	p := s.proc
+
+	s.nextID++
+	maxValue := s.MaxValueChars
+	if maxValue <= 0 {
+		maxValue = DefaultPythonValueChars
+	}
+	req, _ := json.Marshal(pythonRequest{
+		ID:        s.nextID,
+		Code:      code,
+		MaxOutput: opts.maxOutput(),
+		MaxValue:  maxValue,
+	})
+	if _, err := p.stdin.Write(append(req, '\n')); err != nil {
+		s.discard()
+		return PythonResult{}, fmt.Errorf("python session: %w", err)
+	}
+
+	var timeout <-chan time.Time
+	if t := opts.timeout(); t > 0 {
+		timer := time.NewTimer(t)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	timedOut := false
+	var grace <-chan time.Time
+	for {
+		select {
+		case line := <-p.lines:
+			var resp pythonResponse
+			if err := json.Unmarshal(line, &resp); err != nil || resp.ID != s.nextID {
+				continue // a reply to an abandoned request
+			}
+			res := resp.PythonResult
+			res.TimedOut = timedOut
+			res.Reset = reset
+			return res, nil
+
+		case <-p.dead:
+			s.discard()
+			return PythonResult{
+				Error: &PythonError{
+					Type:    "InterpreterDied",
+					Message: "the Python interpreter exited; its state is lost",
+				},
+				Reset: reset,
+			}, nil
+
+		case <-timeout:
+			timeout = nil
+			timedOut = true
+			// KeyboardInterrupt keeps the interpreter and its state
+			s.Sandbox.Exec(context.Background(), "kill -INT "+strconv.Itoa(p.pid), ExecOptions{Timeout: 10 * time.Second})
+			grace = time.After(s.InterruptGrace)
+
+		case <-grace:
+			s.discard()
+			return PythonResult{
+				Error: &PythonError{
+					Type:    "TimeoutError",
+					Message: "code did not stop when interrupted; the interpreter was restarted and its state is lost",
+				},
+				TimedOut: true,
+				Reset:    true,
+			}, nil
+
+		case <-ctx.Done():
+			s.discard()
+			return PythonResult{Reset: true}, ctx.Err()
+		}
+	}
+}
+
+// Close stops the interpreter but leaves the sandbox running.
+func (s *PythonSession) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.discard()
+}
+
+// ensure starts an interpreter if none is running, reporting whether an
+// earlier one's state was lost. s.mu must be held.
+func (s *PythonSession) ensure(ctx context.Context) (bool, error) {
+	if s.proc != nil {
+		select {
+		case <-s.proc.dead:
+			s.discard()
+		default:
+			return false, nil
+		}
+	}
+
+	python := s.Python
+	if len(python) == 0 {
+		python = []string{"python3"}
+	}
+	procCtx, cancel := context.WithCancel(context.Background())
+	cmd, err := s.Sandbox.Command(procCtx, append(append([]string{}, python...), "-u", "-c", pythonDriver)...)
+	if err != nil {
+		cancel()
+		return false, fmt.Errorf("python session: %w", err)
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		cancel()
+		return false, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return false, err
+	}
+	stderr := newCappedBuffer(DefaultMaxOutputBytes)
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return false, fmt.Errorf("python session: starting interpreter: %w", err)
+	}
+
+	p := &pythonProc{
+		cmd:    cmd,
+		cancel: cancel,
+		stdin:  stdin,
+		lines:  make(chan []byte),
+		dead:   make(chan struct{}),
+		stderr: stderr,
+	}
+	go func() {
+		defer close(p.dead)
+		br := bufio.NewReaderSize(stdout, 64<<10)
+		for {
+			line, err := br.ReadBytes('\n')
+			if len(line) > 0 {
+				select {
+				case p.lines <- line:
+				case <-procCtx.Done():
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	startup := time.NewTimer(30 * time.Second)
+	defer startup.Stop()
+	for p.pid == 0 {
+		select {
+		case line := <-p.lines:
+			var resp pythonResponse
+			if json.Unmarshal(line, &resp) == nil && resp.Ready {
+				p.pid = resp.PID
+			}
+		case <-p.dead:
+			cancel()
+			cmd.Wait()
+			return false, fmt.Errorf("python session: interpreter failed to start: %s", stderr.String())
+		case <-startup.C:
+			p.kill()
+			return false, fmt.Errorf("python session: interpreter did not start within 30s")
+		case <-ctx.Done():
+			p.kill()
+			return false, ctx.Err()
+		}
+	}
+
+	reset := s.started
+	s.started = true
+	s.proc = p
+	return reset, nil
+}
+
+// discard kills the interpreter and forgets it. s.mu must be held.
+func (s *PythonSession) discard() {
+	if s.proc != nil {
+		s.proc.kill()
+		s.proc = nil
+	}
+}
+
+func (p *pythonProc) kill() {
+	p.stdin.Close()
+	p.cancel()
+	go p.cmd.Wait()
+}
+
+// PythonExecTool runs Python code in a PythonSession.
+type PythonExecTool struct {
+	Session *PythonSession
+	Options ExecOptions // per-call timeout and output cap
+
+	// Sandbox and SandboxKey pick the sandbox as for DockerExecTool, and
+	// the tool starts one interpreter per sandbox they resolve to, so
+	// concurrent branches using pooled sandboxes each get their own. Session
+	// is used when neither is set, or when they resolve to its sandbox.
+	Sandbox    Sandbox
+	SandboxKey string
+
+	mu       sync.Mutex
+	sessions map[Sandbox]*PythonSession
+}
+
+func (t *PythonExecTool) Name() string { return "python_exec" }
+
+func (t *PythonExecTool) Description() string {
+	return `python_exec(code: string) -> {"stdout", "stderr", "value", "error", "files", "timed_out", "reset"}: ` +
+		`runs Python in a persistent interpreter (variables and imports are kept between calls) ` +
+		`with /workspace as the working directory; "value" is the repr of a trailing expression ` +
+		`and "files" lists workspace files the code created or changed`
+}
+
+func (t *PythonExecTool) Run(input any) (any, error) {
+	return t.run(context.Background(), t.Sandbox, input)
+}
+
+func (t *PythonExecTool) RunWithMemory(ctx context.Context, mem *Memory, input any) (any, error) {
+	sb, err := sandboxFromMemory(mem, t.SandboxKey, t.Sandbox)
+	if err != nil {
+		return nil, fmt.Errorf("python_exec: %w", err)
+	}
+	return t.run(ctx, sb, input)
+}
+
+// Close stops the interpreters the tool started for Sandbox and SandboxKey.
+// Session belongs to the caller and is left running.
+func (t *PythonExecTool) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for sb, py := range t.sessions {
+		py.Close()
+		delete(t.sessions, sb)
+	}
+}
+
+// session returns the interpreter for sb, starting a session for a sandbox
+// seen for the first time.
+func (t *PythonExecTool) session(sb Sandbox) (*PythonSession, error) {
+	if sb == nil || (t.Session != nil && Sandbox(t.Session.Sandbox) == sb) {
+		if t.Session == nil {
+			return nil, fmt.Errorf("no session")
+		}
+		return t.Session, nil
+	}
+	shell, ok := sb.(ShellSandbox)
+	if !ok {
+		return nil, fmt.Errorf("sandbox %T cannot run an interpreter", sb)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if py, ok := t.sessions[sb]; ok {
+		return py, nil
+	}
+	if t.sessions == nil {
+		t.sessions = make(map[Sandbox]*PythonSession)
+	}
+	py := NewPythonSession(shell)
+	if t.Session != nil {
+		py.Python = t.Session.Python
+		py.MaxValueChars = t.Session.MaxValueChars
+		py.InterruptGrace = t.Session.InterruptGrace
+	}
+	t.sessions[sb] = py
+	return py, nil
+}
+
+func (t *PythonExecTool) run(ctx context.Context, sb Sandbox, input any) (any, error) {
+	args, err := toolArgs(input, "code")
+	if err != nil {
+		return nil, fmt.Errorf("python_exec: %w", err)
+	}
+	code := argString(args, "code")
+	if code == "" {
+		return nil, fmt.Errorf("python_exec: code is required")
+	}
+	py, err := t.session(sb)
+	if err != nil {
+		return nil, fmt.Errorf("python_exec: %w", err)
+	}
+
+	res, err := py.Exec(ctx, code, t.Options)
+	if err != nil {
+		return nil, fmt.Errorf("python_exec: %w", err)
+	}
+
+	out := map[string]any{
+		"stdout":    res.Stdout,
+		"stderr":    res.Stderr,
+		"timed_out": res.TimedOut,
+		"truncated": res.Truncated,
+		"reset":     res.Reset,
+	}
+	if res.Value != "" {
+		out["value"] = res.Value
+	}
+	if res.Error != nil {
+		out["error"] = map[string]any{
+			"type":      res.Error.Type,
+			"message":   res.Error.Message,
+			"traceback": res.Error.Traceback,
+		}
+	}
+	if len(res.Files) > 0 {
+		files := make([]map[string]any, len(res.Files))
+		for i, f := range res.Files {
+			files[i] = map[string]any{"path": f.Path, "size": f.Size}
+		}
+		out["files"] = files
+	}
+	return out, nil
+}
+
+// pythonDriver is the interpreter loop. It reads one JSON request per line
+// on stdin and writes one JSON reply per line on the original stdout; while
+// code runs, file descriptors 1 and 2 point at temp files so output from
+// subprocesses is captured too.
+const pythonDriver = `
+import ast, json, os, sys, tempfile, traceback
+
+_proto_out = os.fdopen(os.dup(1), "w", encoding="utf-8")
+_proto_in = os.fdopen(os.dup(0), "r", encoding="utf-8")
+_devnull = os.open(os.devnull, os.O_RDWR)
+_saved_err = os.dup(2)
+os.dup2(_devnull, 0)
+os.dup2(_devnull, 1)
+sys.stdin = open(os.devnull)
+_root = os.environ.get("WORKSPACE") or os.getcwd()
+_ns = {"__name__": "__main__", "__builtins__": __builtins__}
+_skip = {"__pycache__", "node_modules"}
+
+def _send(msg):
+    _proto_out.write(json.dumps(msg) + "\n")
+    _proto_out.flush()
+
+def _snapshot(limit=5000):
+    seen = {}
+    for d, dirs, files in os.walk(_root):
+        dirs[:] = [x for x in dirs if not x.startswith(".") and x not in _skip]
+        for f in files:
+            p = os.path.join(d, f)
+            try:
+                st = os.stat(p)
+            except OSError:
+                continue
+            seen[p] = (st.st_mtime_ns, st.st_size)
+            if len(seen) >= limit:
+                return seen
+    return seen
+
+def _read(f, limit):
+    f.seek(0)
+    data = f.read()
+    if limit <= 0 or len(data) <= limit:
+        return data.decode("utf-8", "replace"), False
+    head = data[:limit // 2].decode("utf-8", "replace")
+    tail = data[len(data) - (limit - limit // 2):].decode("utf-8", "replace")
+    sep = "" if head.endswith("\n") else "\n"
+    return "%s%s[... %d bytes truncated ...]\n%s" % (head, sep, len(data) - limit, tail), True
+
+def _run(req):
+    out_f, err_f = tempfile.TemporaryFile(), tempfile.TemporaryFile()
+    res = {"id": req["id"]}
+    if sys.stdin.closed:  # exit() closes it
+        sys.stdin = open(os.devnull)
+    before = _snapshot()
+    sys.stdout.flush(); sys.stderr.flush()
+    os.dup2(out_f.fileno(), 1)
+    os.dup2(err_f.fileno(), 2)
+    try:
+        tree = ast.parse(req["code"], "<cell>", "exec")
+        last = None
+        if tree.body and isinstance(tree.body[-1], ast.Expr):
+            last = ast.Expression(tree.body.pop().value)
+        exec(compile(tree, "<cell>", "exec"), _ns)
+        if last is not None:
+            value = eval(compile(last, "<cell>", "eval"), _ns)
+            if value is not None:
+                _ns["_"] = value
+                text = repr(value)
+                if len(text) > req["max_value"]:
+                    text = text[:req["max_value"]] + "..."
+                res["value"] = text
+    except BaseException as e:
+        if isinstance(e, SyntaxError):
+            tb = traceback.format_exception_only(type(e), e)
+        else:
+            tb = traceback.format_exception(type(e), e, e.__traceback__.tb_next)
+        res["error"] = {"type": type(e).__name__, "message": str(e), "traceback": "".join(tb)}
+    finally:
+        sys.stdout.flush(); sys.stderr.flush()
+        os.dup2(_devnull, 1)
+        os.dup2(_saved_err, 2)
+    res["stdout"], t1 = _read(out_f, req["max_output"])
+    res["stderr"], t2 = _read(err_f, req["max_output"])
+    res["truncated"] = t1 or t2
+    after = _snapshot()
+    res["files"] = [
+        {"path": "/workspace/" + os.path.relpath(p, _root).replace(os.sep, "/"), "size": after[p][1]}
+        for p in sorted(after) if before.get(p) != after[p]
+    ]
+    return res
+
+_send({"ready": True, "pid": os.getpid()})
+while True:
+    try:
+        line = _proto_in.readline()
+        if not line:
+            break
+        req = json.loads(line)
+        try:
+            _send(_run(req))
+        except KeyboardInterrupt:
+            _send({"id": req["id"], "error": {"type": "KeyboardInterrupt", "message": "interrupted"}})
+    except KeyboardInterrupt:
+        continue
+`
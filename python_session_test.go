@@ -0,0 +1,148 @@
+//go:build unix
+
+package nodechain
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startPythonSession(t *testing.T) *PythonSession {
+	t.Helper()
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 not installed")
+	}
+	py := NewPythonSession(startLocalSandbox(t))
+	t.Cleanup(py.Close)
+	return py
+}
+
+func TestPythonSession(t *testing.T) {
+	py := startPythonSession(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		code      string
+		stdout    string
+		value     string
+		errorType string
+		files     []string
+	}{
+		{name: "definition", code: "import math\nx = 41"},
+		{name: "state persists", code: "x + 1", value: "42"},
+		{name: "print and value", code: "print('hi')\nmath.sqrt(16)", stdout: "hi\n", value: "4.0"},
+		{name: "None is not a value", code: "None"},
+		{name: "exception", code: "1/0", errorType: "ZeroDivisionError"},
+		{name: "syntax error", code: "def (", errorType: "SyntaxError"},
+		{name: "subprocess output", code: "import subprocess\nsubprocess.run(['echo', 'child'])\n_ = None", stdout: "child\n"},
+		{name: "files", code: "open('out.txt', 'w').write('data')\n_ = None", files: []string{"/workspace/out.txt"}},
+		{name: "state survives errors", code: "x", value: "41"},
+	}
+	for _, tt := range tests {
+		res, err := py.Exec(ctx, tt.code, ExecOptions{})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if res.Stdout != tt.stdout || res.Value != tt.value {
+			t.Errorf("%s: stdout %q value %q, want %q %q", tt.name, res.Stdout, res.Value, tt.stdout, tt.value)
+		}
+		gotType := ""
+		if res.Error != nil {
+			gotType = res.Error.Type
+		}
+		if gotType != tt.errorType {
+			t.Errorf("%s: error %+v, want type %q", tt.name, res.Error, tt.errorType)
+		}
+		var files []string
+		for _, f := range res.Files {
+			files = append(files, f.Path)
+		}
+		if strings.Join(files, ",") != strings.Join(tt.files, ",") {
+			t.Errorf("%s: files %v, want %v", tt.name, files, tt.files)
+		}
+		if res.Reset {
+			t.Errorf("%s: interpreter was reset", tt.name)
+		}
+	}
+}
+
+func TestPythonSessionInterruptKeepsState(t *testing.T) {
+	py := startPythonSession(t)
+	ctx := context.Background()
+
+	if _, err := py.Exec(ctx, "kept = 'yes'", ExecOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := py.Exec(ctx, "import time\ntime.sleep(30)", ExecOptions{Timeout: 300 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.TimedOut || res.Error == nil || res.Error.Type != "KeyboardInterrupt" || res.Reset {
+		t.Fatalf("interrupted: %+v %+v", res, res.Error)
+	}
+
+	res, err = py.Exec(ctx, "kept", ExecOptions{})
+	if err != nil || res.Value != "'yes'" {
+		t.Fatalf("state after interrupt: %+v, %v", res, err)
+	}
+}
+
+func TestPythonSessionRestartsAfterExit(t *testing.T) {
+	py := startPythonSession(t)
+	ctx := context.Background()
+
+	if _, err := py.Exec(ctx, "lost = 1", ExecOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := py.Exec(ctx, "import os\nos._exit(0)", ExecOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := py.Exec(ctx, "'lost' in globals()", ExecOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Value != "False" || !res.Reset {
+		t.Fatalf("after exit: %+v", res)
+	}
+}
+
+func TestPythonExecToolSessionPerSandbox(t *testing.T) {
+	py := startPythonSession(t)
+	other := startLocalSandbox(t)
+	tool := &PythonExecTool{Session: py, SandboxKey: "sandbox"}
+	t.Cleanup(tool.Close)
+	ctx := context.Background()
+
+	run := func(mem *Memory, code string) map[string]any {
+		t.Helper()
+		out, err := tool.RunWithMemory(ctx, mem, map[string]any{"code": code})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out.(map[string]any)
+	}
+
+	own := NewMemory(map[string]any{"sandbox": py.Sandbox})
+	branch := NewMemory(map[string]any{"sandbox": other})
+	none := NewMemory(map[string]any{})
+
+	run(own, "x = 'session'")
+	run(branch, "x = 'branch'")
+	if _, err := tool.RunWithMemory(ctx, none, map[string]any{"code": "x"}); err == nil {
+		t.Error("ran Python without a sandbox in memory")
+	}
+	if v := run(own, "x")["value"]; v != "'session'" {
+		t.Errorf("with the Session's sandbox: %v", v)
+	}
+	if v := run(branch, "x")["value"]; v != "'branch'" {
+		t.Errorf("with another sandbox: %v, want its own interpreter's state", v)
+	}
+
+	if _, err := tool.RunWithMemory(ctx, NewMemory(map[string]any{"sandbox": &fakeSandbox{}}), map[string]any{"code": "1"}); err == nil {
+		t.Error("ran Python in a sandbox without Command")
+	}
+}
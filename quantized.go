@@ -0,0 +1,418 @@
+package nodechain
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Quantization selects how QuantizedVectorStore compresses embeddings.
+type Quantization int
+
+const (
+	// QuantizeInt8 stores one signed byte per dimension plus a per-vector
+	// scale: 4x smaller than float32 with little recall loss.
+	QuantizeInt8 Quantization = iota
+	// QuantizeBinary stores one bit (the sign) per dimension: 32x smaller,
+	// scored by Hamming distance. Needs re-scoring for good recall.
+	QuantizeBinary
+)
+
+func (q Quantization) String() string {
+	switch q {
+	case QuantizeInt8:
+		return "int8"
+	case QuantizeBinary:
+		return "binary"
+	}
+	return fmt.Sprintf("Quantization(%d)", int(q))
+}
+
+// VectorArchive keeps full-precision vectors outside the quantized index so
+// the top candidates can be re-scored exactly.
+type VectorArchive interface {
+	// Append stores vecs and returns the index of the first one.
+	Append(vecs [][]float32) (int, error)
+	Read(i int) ([]float32, error)
+}
+
+// QuantizedVectorStore is an in-memory VectorStore holding quantized
+// embeddings. Search scores every document against its compressed vector,
+// then, if Archive is set, re-scores the best Rescore*k candidates with the
+// full-precision vectors. Returned documents carry the archived embedding,
+// or a dequantized approximation when there is no archive.
+//
+// Vectors are L2-normalised before quantization, so scores approximate
+// cosine similarity. Like InMemoryVectorStore, the first Add records the
+// dimension and embedder and mismatches fail with *EmbeddingMismatchError.
+type QuantizedVectorStore struct {
+	Quantization Quantization
+	// Archive, if set, must be set before the first Add: Add refuses a
+	// store whose earlier documents were not archived, and Search then
+	// skips re-scoring. nil disables re-scoring.
+	Archive VectorArchive
+	Rescore int // candidates re-scored per result; default 4
+
+	mu        sync.RWMutex
+	embedder  string
+	dimension int
+	docs      []Document // without embeddings
+	codes     []int8     // int8: dimension codes per doc
+	scales    []float32  // int8: per-doc dequantization scale
+	bitsets   []uint64   // binary: ceil(dimension/64) words per doc
+	archive   []int      // index of each doc in Archive
+}
+
+func NewQuantizedVectorStore(q Quantization, archive VectorArchive) *QuantizedVectorStore {
+	return &QuantizedVectorStore{
+		Quantization: q,
+		Archive:      archive,
+		Rescore:      4,
+	}
+}
+
+func (s *QuantizedVectorStore) Add(ctx context.Context, docs []Document) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Quantization != QuantizeInt8 && s.Quantization != QuantizeBinary {
+		return fmt.Errorf("QuantizedVectorStore: unknown quantization %v", s.Quantization)
+	}
+	dim, embedder, err := checkEmbeddings(s.dimension, s.embedder, docs)
+	if err != nil {
+		return err
+	}
+	if s.Archive != nil && len(s.archive) != len(s.docs) {
+		return fmt.Errorf("QuantizedVectorStore: Archive was set after %d documents were added without it", len(s.docs)-len(s.archive))
+	}
+
+	if s.Archive != nil && len(docs) > 0 {
+		vecs := make([][]float32, len(docs))
+		for i, d := range docs {
+			vecs[i] = d.Embedding
+		}
+		first, err := s.Archive.Append(vecs)
+		if err != nil {
+			return fmt.Errorf("QuantizedVectorStore: archiving vectors: %w", err)
+		}
+		for i := range docs {
+			s.archive = append(s.archive, first+i)
+		}
+	}
+	// record these only once nothing can fail, so a rejected first batch
+	// leaves the store empty and unconstrained
+	s.dimension, s.embedder = dim, embedder
+
+	for _, d := range docs {
+		unit := normalise(d.Embedding)
+		switch s.Quantization {
+		case QuantizeInt8:
+			codes, scale := quantizeInt8(unit)
+			s.codes = append(s.codes, codes...)
+			s.scales = append(s.scales, scale)
+		case QuantizeBinary:
+			s.bitsets = append(s.bitsets, quantizeBinary(unit)...)
+		}
+
+		d.Embedding = nil
+		s.docs = append(s.docs, d)
+	}
+	return nil
+}
+
+func (s *QuantizedVectorStore) CheckEmbedding(embedder string, dimension int) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return checkQueryEmbedding(s.dimension, s.embedder, embedder, dimension)
+}
+
+// Embedder returns the name of the embedder whose vectors the store holds,
+// or "" if it is not known.
+func (s *QuantizedVectorStore) Embedder() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.embedder
+}
+
+// Dimension returns the dimension of the stored embeddings, or 0 before
+// the first Add.
+func (s *QuantizedVectorStore) Dimension() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.dimension
+}
+
+// Len returns the number of stored documents.
+func (s *QuantizedVectorStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.docs)
+}
+
+func (s *QuantizedVectorStore) Search(ctx context.Context, query []float32, k int) ([]Document, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(query) == 0 {
+		return nil, fmt.Errorf("Search: empty query embedding")
+	}
+	if s.dimension != 0 && len(query) != s.dimension {
+		return nil, &EmbeddingMismatchError{
+			Op:           "search",
+			WantEmbedder: s.embedder,
+			WantDim:      s.dimension,
+			GotDim:       len(query),
+		}
+	}
+	if len(s.docs) == 0 || k <= 0 {
+		return nil, nil
+	}
+
+	unit := normalise(query)
+	scores := make([]float64, len(s.docs))
+	switch s.Quantization {
+	case QuantizeInt8:
+		for i := range s.docs {
+			scores[i] = s.int8Dot(unit, i)
+		}
+	case QuantizeBinary:
+		qbits := quantizeBinary(unit)
+		words := len(qbits)
+		for i := range s.docs {
+			ham := 0
+			for w, qw := range qbits {
+				ham += bits.OnesCount64(qw ^ s.bitsets[i*words+w])
+			}
+			scores[i] = 1 - 2*float64(ham)/float64(s.dimension)
+		}
+	}
+
+	// documents added before Archive was set have no archived vector
+	rescore := s.Archive != nil && len(s.archive) == len(s.docs)
+	candidates := k
+	if rescore {
+		candidates = k * max(s.Rescore, 1)
+	}
+	top := topIndexes(scores, candidates)
+
+	var full map[int][]float32
+	if rescore {
+		full = make(map[int][]float32, len(top))
+		for _, i := range top {
+			v, err := s.Archive.Read(s.archive[i])
+			if err != nil {
+				return nil, fmt.Errorf("QuantizedVectorStore: reading vector %d: %w", i, err)
+			}
+			full[i] = v
+			scores[i] = cosineSimilarity(query, v)
+		}
+		sort.SliceStable(top, func(a, b int) bool { return scores[top[a]] > scores[top[b]] })
+	}
+	if len(top) > k {
+		top = top[:k]
+	}
+
+	out := make([]Document, len(top))
+	for j, i := range top {
+		d := s.docs[i]
+		if full != nil {
+			d.Embedding = full[i]
+		} else {
+			d.Embedding = s.dequantize(i)
+		}
+		out[j] = d
+	}
+	return out, nil
+}
+
+func (s *QuantizedVectorStore) int8Dot(unit []float32, i int) float64 {
+	codes := s.codes[i*s.dimension : (i+1)*s.dimension]
+	var dot float32
+	for j, c := range codes {
+		dot += unit[j] * float32(c)
+	}
+	return float64(dot * s.scales[i])
+}
+
+func (s *QuantizedVectorStore) dequantize(i int) []float32 {
+	out := make([]float32, s.dimension)
+	switch s.Quantization {
+	case QuantizeInt8:
+		for j, c := range s.codes[i*s.dimension : (i+1)*s.dimension] {
+			out[j] = float32(c) * s.scales[i]
+		}
+	case QuantizeBinary:
+		words := (s.dimension + 63) / 64
+		v := float32(1 / math.Sqrt(float64(s.dimension)))
+		for j := range out {
+			if s.bitsets[i*words+j/64]&(1<<(j%64)) != 0 {
+				out[j] = v
+			} else {
+				out[j] = -v
+			}
+		}
+	}
+	return out
+}
+
+// MemoryBytes estimates the heap used by the quantized vectors (not the
+// documents or the archive).
+func (s *QuantizedVectorStore) MemoryBytes() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.codes) + 4*len(s.scales) + 8*len(s.bitsets) + 8*len(s.archive)
+}
+
+func normalise(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	out := make([]float32, len(v))
+	if sum == 0 {
+		return out
+	}
+	inv := 1 / math.Sqrt(sum)
+	for i, x := range v {
+		out[i] = float32(float64(x) * inv)
+	}
+	return out
+}
+
+// quantizeInt8 maps v symmetrically onto [-127, 127].
+func quantizeInt8(v []float32) ([]int8, float32) {
+	var maxAbs float32
+	for _, x := range v {
+		maxAbs = max(maxAbs, float32(math.Abs(float64(x))))
+	}
+	codes := make([]int8, len(v))
+	if maxAbs == 0 {
+		return codes, 0
+	}
+	scale := maxAbs / 127
+	for i, x := range v {
+		codes[i] = int8(math.Round(float64(x / scale)))
+	}
+	return codes, scale
+}
+
+// quantizeBinary keeps the sign of each dimension, 64 per word.
+func quantizeBinary(v []float32) []uint64 {
+	out := make([]uint64, (len(v)+63)/64)
+	for i, x := range v {
+		if x > 0 {
+			out[i/64] |= 1 << (i % 64)
+		}
+	}
+	return out
+}
+
+// topIndexes returns the indexes of the n highest scores, best first.
+func topIndexes(scores []float64, n int) []int {
+	idx := make([]int, len(scores))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(a, b int) bool { return scores[idx[a]] > scores[idx[b]] })
+	if n < len(idx) {
+		idx = idx[:n]
+	}
+	return idx
+}
+
+// InMemoryVectorArchive keeps full-precision vectors on the heap. It saves
+// no memory but lets QuantizedVectorStore re-score exactly.
+type InMemoryVectorArchive struct {
+	mu   sync.RWMutex
+	vecs [][]float32
+}
+
+func (a *InMemoryVectorArchive) Append(vecs [][]float32) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	first := len(a.vecs)
+	a.vecs = append(a.vecs, vecs...)
+	return first, nil
+}
+
+func (a *InMemoryVectorArchive) Read(i int) ([]float32, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if i < 0 || i >= len(a.vecs) {
+		return nil, fmt.Errorf("vector %d out of range", i)
+	}
+	return a.vecs[i], nil
+}
+
+// FileVectorArchive keeps full-precision vectors in a file of fixed-size
+// little-endian float32 records, so only the quantized codes stay in memory.
+// Re-scoring reads Rescore*k records per search.
+type FileVectorArchive struct {
+	mu        sync.Mutex
+	f         *os.File
+	dimension int
+	count     int
+}
+
+// NewFileVectorArchive creates (or truncates) path for vectors of the given
+// dimension.
+func NewFileVectorArchive(path string, dimension int) (*FileVectorArchive, error) {
+	if dimension <= 0 {
+		return nil, fmt.Errorf("FileVectorArchive: dimension must be positive, got %d", dimension)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &FileVectorArchive{f: f, dimension: dimension}, nil
+}
+
+func (a *FileVectorArchive) Append(vecs [][]float32) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	buf := make([]byte, 4*a.dimension*len(vecs))
+	for i, v := range vecs {
+		if len(v) != a.dimension {
+			return 0, fmt.Errorf("FileVectorArchive: vector has dimension %d, want %d", len(v), a.dimension)
+		}
+		for j, x := range v {
+			binary.LittleEndian.PutUint32(buf[4*(i*a.dimension+j):], math.Float32bits(x))
+		}
+	}
+
+	first := a.count
+	if _, err := a.f.WriteAt(buf, int64(4*a.dimension*first)); err != nil {
+		return 0, err
+	}
+	a.count += len(vecs)
+	return first, nil
+}
+
+func (a *FileVectorArchive) Read(i int) ([]float32, error) {
+	a.mu.Lock()
+	count := a.count
+	a.mu.Unlock()
+	if i < 0 || i >= count {
+		return nil, fmt.Errorf("vector %d out of range", i)
+	}
+
+	buf := make([]byte, 4*a.dimension)
+	if _, err := a.f.ReadAt(buf, int64(4*a.dimension*i)); err != nil {
+		return nil, err
+	}
+	out := make([]float32, a.dimension)
+	for j := range out {
+		out[j] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*j:]))
+	}
+	return out, nil
+}
+
+func (a *FileVectorArchive) Close() error {
+	return a.f.Close()
+}
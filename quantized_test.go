@@ -0,0 +1,148 @@
+package nodechain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+func randomDocs(rng *rand.Rand, n, dim int) []Document {
+	docs := make([]Document, n)
+	for i := range docs {
+		v := make([]float32, dim)
+		for j := range v {
+			v[j] = float32(rng.NormFloat64())
+		}
+		docs[i] = Document{ID: fmt.Sprint(i), Embedding: v}
+	}
+	return docs
+}
+
+func TestQuantizedVectorStoreSearch(t *testing.T) {
+	e := NewHashingEmbedder(128)
+	docs := embedDocs(t, e,
+		"go channels and goroutines",
+		"python list comprehensions",
+		"rust ownership and borrowing",
+		"sql joins and indexes",
+	)
+	archive, err := NewFileVectorArchive(filepath.Join(t.TempDir(), "vecs"), 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	tests := []struct {
+		name  string
+		store *QuantizedVectorStore
+	}{
+		{"int8", NewQuantizedVectorStore(QuantizeInt8, nil)},
+		{"binary", NewQuantizedVectorStore(QuantizeBinary, nil)},
+		{"int8 rescored", NewQuantizedVectorStore(QuantizeInt8, &InMemoryVectorArchive{})},
+		{"binary rescored from file", NewQuantizedVectorStore(QuantizeBinary, archive)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.store.Add(context.Background(), docs); err != nil {
+				t.Fatal(err)
+			}
+			got, err := tt.store.Search(context.Background(), embedQuery(t, e, "rust borrowing rules"), 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 2 || got[0].ID != "2" {
+				t.Fatalf("got %v, want doc 2 first", docIDs(got))
+			}
+			if len(got[0].Embedding) != 128 {
+				t.Fatalf("result embedding has %d dimensions", len(got[0].Embedding))
+			}
+		})
+	}
+}
+
+func TestQuantizedVectorStoreArchiveSetLate(t *testing.T) {
+	docs := randomDocs(rand.New(rand.NewSource(1)), 10, 16)
+	s := NewQuantizedVectorStore(QuantizeInt8, nil)
+	if err := s.Add(context.Background(), docs[:5]); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Archive = &InMemoryVectorArchive{}
+	if err := s.Add(context.Background(), docs[5:]); err == nil {
+		t.Fatal("Add accepted documents after Archive was set late")
+	}
+	got, err := s.Search(context.Background(), docs[0].Embedding, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0].ID != "0" {
+		t.Fatalf("search with a late archive returned %v", docIDs(got))
+	}
+}
+
+type failingArchive struct{}
+
+func (failingArchive) Append(vecs [][]float32) (int, error) { return 0, errors.New("disk full") }
+func (failingArchive) Read(i int) ([]float32, error)        { return nil, errors.New("disk full") }
+
+func TestQuantizedVectorStoreFailedAddRecordsNothing(t *testing.T) {
+	s := NewQuantizedVectorStore(QuantizeInt8, failingArchive{})
+	docs := randomDocs(rand.New(rand.NewSource(1)), 2, 16)
+	if err := s.Add(context.Background(), docs); err == nil {
+		t.Fatal("Add succeeded with a failing archive")
+	}
+	if s.Dimension() != 0 || s.Len() != 0 {
+		t.Fatalf("failed Add recorded dimension %d and %d docs", s.Dimension(), s.Len())
+	}
+
+	s.Archive = &InMemoryVectorArchive{}
+	if err := s.Add(context.Background(), randomDocs(rand.New(rand.NewSource(2)), 2, 32)); err != nil {
+		t.Fatalf("store still bound to the failed batch: %v", err)
+	}
+}
+
+func benchmarkSearch(b *testing.B, store VectorStore, n, dim int) {
+	rng := rand.New(rand.NewSource(1))
+	if err := store.Add(context.Background(), randomDocs(rng, n, dim)); err != nil {
+		b.Fatal(err)
+	}
+	queries := randomDocs(rng, 64, dim)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := store.Search(context.Background(), queries[i%len(queries)].Embedding, 10); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkVectorStoreSearch(b *testing.B) {
+	const n, dim = 10000, 384
+	stores := []struct {
+		name string
+		new  func() VectorStore
+	}{
+		{"float32", func() VectorStore { return NewInMemoryVectorStore() }},
+		{"int8", func() VectorStore { return NewQuantizedVectorStore(QuantizeInt8, nil) }},
+		{"binary", func() VectorStore { return NewQuantizedVectorStore(QuantizeBinary, nil) }},
+		{"binary rescored", func() VectorStore { return NewQuantizedVectorStore(QuantizeBinary, &InMemoryVectorArchive{}) }},
+	}
+	for _, s := range stores {
+		b.Run(s.name, func(b *testing.B) { benchmarkSearch(b, s.new(), n, dim) })
+	}
+}
+
+func BenchmarkQuantizedVectorStoreAdd(b *testing.B) {
+	docs := randomDocs(rand.New(rand.NewSource(1)), 1000, 384)
+	for _, q := range []Quantization{QuantizeInt8, QuantizeBinary} {
+		b.Run(q.String(), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := NewQuantizedVectorStore(q, nil).Add(context.Background(), docs); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
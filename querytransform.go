@@ -0,0 +1,291 @@
+package nodechain
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Prompts for the query transform nodes: {query} is replaced with the query
+// and, for MultiQueryNode, {n} with the number of queries wanted.
+const (
+	DefaultRewritePrompt = "Rewrite the following question so it is self-contained, specific and well suited " +
+		"to searching a document collection. Reply with the rewritten question only.\n\nQuestion: {query}"
+
+	DefaultMultiQueryPrompt = "Write {n} different search queries that would help answer the question below. " +
+		"Vary wording and angle. Reply with one query per line and nothing else.\n\nQuestion: {query}"
+
+	DefaultHyDEPrompt = "Write a short passage that answers the question below, as it might appear in " +
+		"documentation. It does not need to be correct; it is used only for search.\n\nQuestion: {query}"
+)
+
+// readQuery loads the query string stored at key for a node named name.
+func readQuery(mem *Memory, name, key string) (string, error) {
+	raw, ok := mem.Get(key)
+	if !ok {
+		return "", fmt.Errorf("%s: query not found at key '%s'", name, key)
+	}
+	query, ok := raw.(string)
+	if !ok {
+		return "", fmt.Errorf("%s: query at key '%s' is not a string", name, key)
+	}
+	return query, nil
+}
+
+// fillPrompt substitutes {query} and {n} in a query transform prompt. A
+// prompt without {query}, such as an old fmt-style one, is an error rather
+// than a question the LLM never sees.
+func fillPrompt(name, prompt, query string, n int) (string, error) {
+	if !strings.Contains(prompt, "{query}") {
+		return "", fmt.Errorf("%s: prompt has no {query} placeholder", name)
+	}
+	return strings.NewReplacer("{query}", query, "{n}", strconv.Itoa(n)).Replace(prompt), nil
+}
+
+func askLLM(ctx context.Context, provider LLMProvider, prompt string) (string, error) {
+	resp, err := provider.Chat(ctx, []LLMMessage{
+		{Role: "system", Content: "You help improve search queries for a retrieval system."},
+		{Role: "user", Content: prompt},
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(resp.Text), nil
+}
+
+// QueryRewriteNode: asks an LLM to rewrite the query for retrieval.
+type QueryRewriteNode struct {
+	BaseNode
+	Provider  LLMProvider
+	QueryKey  string
+	ResultKey string // where the rewritten query is stored
+	Prompt    string // must contain {query}
+}
+
+func NewQueryRewriteNode(provider LLMProvider, queryKey, resultKey string) *QueryRewriteNode {
+	return &QueryRewriteNode{
+		BaseNode:  NewBaseNode(),
+		Provider:  provider,
+		QueryKey:  queryKey,
+		ResultKey: resultKey,
+		Prompt:    DefaultRewritePrompt,
+	}
+}
+
+func (n *QueryRewriteNode) TypeName() string { return "QueryRewriteNode" }
+
+func (n *QueryRewriteNode) Run(ctx context.Context, mem *Memory) ([]Trigger, error) {
+	query, err := readQuery(mem, "QueryRewriteNode", n.QueryKey)
+	if err != nil {
+		return nil, err
+	}
+
+	prompt, err := fillPrompt("QueryRewriteNode", n.Prompt, query, 0)
+	if err != nil {
+		return nil, err
+	}
+	rewritten, err := askLLM(ctx, n.Provider, prompt)
+	if err != nil {
+		return nil, err
+	}
+	if rewritten == "" {
+		rewritten = query
+	}
+
+	mem.Local[n.ResultKey] = rewritten
+
+	return []Trigger{
+		{Action: DefaultAction, ForkingData: map[string]any{}},
+	}, nil
+}
+
+// HyDENode: generates a hypothetical answer to embed in place of the query
+// (Hypothetical Document Embeddings). Point EmbedQueryNode at ResultKey.
+type HyDENode struct {
+	BaseNode
+	Provider  LLMProvider
+	QueryKey  string
+	ResultKey string // where the hypothetical document is stored
+	Prompt    string // must contain {query}
+}
+
+func NewHyDENode(provider LLMProvider, queryKey, resultKey string) *HyDENode {
+	return &HyDENode{
+		BaseNode:  NewBaseNode(),
+		Provider:  provider,
+		QueryKey:  queryKey,
+		ResultKey: resultKey,
+		Prompt:    DefaultHyDEPrompt,
+	}
+}
+
+func (n *HyDENode) TypeName() string { return "HyDENode" }
+
+func (n *HyDENode) Run(ctx context.Context, mem *Memory) ([]Trigger, error) {
+	query, err := readQuery(mem, "HyDENode", n.QueryKey)
+	if err != nil {
+		return nil, err
+	}
+
+	prompt, err := fillPrompt("HyDENode", n.Prompt, query, 0)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := askLLM(ctx, n.Provider, prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	// keep the question in the text so the embedding stays anchored to it
+	mem.Local[n.ResultKey] = query + "\n\n" + doc
+
+	return []Trigger{
+		{Action: DefaultAction, ForkingData: map[string]any{}},
+	}, nil
+}
+
+// MultiQueryNode: asks an LLM for N alternative phrasings of the query and
+// stores them as []string, for MultiQueryRetrieveNode.
+type MultiQueryNode struct {
+	BaseNode
+	Provider        LLMProvider
+	QueryKey        string
+	ResultKey       string // where the []string of queries is stored
+	N               int
+	IncludeOriginal bool   // prepend the original query to the list
+	Prompt          string // must contain {query}; {n} is replaced with N
+}
+
+func NewMultiQueryNode(provider LLMProvider, queryKey, resultKey string, n int) *MultiQueryNode {
+	return &MultiQueryNode{
+		BaseNode:        NewBaseNode(),
+		Provider:        provider,
+		QueryKey:        queryKey,
+		ResultKey:       resultKey,
+		N:               n,
+		IncludeOriginal: true,
+		Prompt:          DefaultMultiQueryPrompt,
+	}
+}
+
+func (n *MultiQueryNode) TypeName() string { return "MultiQueryNode" }
+
+var listMarkerRe = regexp.MustCompile(`^\s*(?:[-*•]|\d+[.)])\s*`)
+
+func (n *MultiQueryNode) Run(ctx context.Context, mem *Memory) ([]Trigger, error) {
+	query, err := readQuery(mem, "MultiQueryNode", n.QueryKey)
+	if err != nil {
+		return nil, err
+	}
+
+	prompt, err := fillPrompt("MultiQueryNode", n.Prompt, query, n.N)
+	if err != nil {
+		return nil, err
+	}
+	text, err := askLLM(ctx, n.Provider, prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	var queries []string
+	if n.IncludeOriginal {
+		queries = append(queries, query)
+	}
+	seen := map[string]bool{strings.ToLower(query): n.IncludeOriginal}
+	generated := 0
+	for _, line := range strings.Split(text, "\n") {
+		q := strings.Trim(strings.TrimSpace(listMarkerRe.ReplaceAllString(line, "")), `"`)
+		if q == "" || seen[strings.ToLower(q)] {
+			continue
+		}
+		if n.N > 0 && generated == n.N {
+			break
+		}
+		seen[strings.ToLower(q)] = true
+		queries = append(queries, q)
+		generated++
+	}
+	if len(queries) == 0 {
+		queries = []string{query}
+	}
+
+	mem.Local[n.ResultKey] = queries
+
+	return []Trigger{
+		{Action: DefaultAction, ForkingData: map[string]any{}},
+	}, nil
+}
+
+// MultiQueryRetrieveNode: embeds several queries, retrieves top-K for each and
+// stores the union, deduplicated by ID and ordered by reciprocal rank fusion.
+type MultiQueryRetrieveNode struct {
+	BaseNode
+	Embedder   Embedder
+	Store      VectorStore
+	QueriesKey string // []string of queries
+	ResultKey  string // where the merged []Document is stored
+	K          int    // per query
+	Limit      int    // cap on merged results; 0 keeps the whole union
+}
+
+func NewMultiQueryRetrieveNode(embedder Embedder, store VectorStore, queriesKey, resultKey string, k int) *MultiQueryRetrieveNode {
+	return &MultiQueryRetrieveNode{
+		BaseNode:   NewBaseNode(),
+		Embedder:   embedder,
+		Store:      store,
+		QueriesKey: queriesKey,
+		ResultKey:  resultKey,
+		K:          k,
+	}
+}
+
+func (n *MultiQueryRetrieveNode) TypeName() string { return "MultiQueryRetrieveNode" }
+
+func (n *MultiQueryRetrieveNode) Run(ctx context.Context, mem *Memory) ([]Trigger, error) {
+	raw, ok := mem.Get(n.QueriesKey)
+	if !ok {
+		return nil, fmt.Errorf("MultiQueryRetrieveNode: queries not found at key '%s'", n.QueriesKey)
+	}
+	queries, ok := raw.([]string)
+	if !ok {
+		return nil, fmt.Errorf("MultiQueryRetrieveNode: value at key '%s' is not []string", n.QueriesKey)
+	}
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("MultiQueryRetrieveNode: no queries at key '%s'", n.QueriesKey)
+	}
+
+	embs, err := n.Embedder.EmbedText(ctx, queries)
+	if err != nil {
+		return nil, err
+	}
+	if len(embs) != len(queries) {
+		return nil, fmt.Errorf("MultiQueryRetrieveNode: expected %d embeddings, got %d", len(queries), len(embs))
+	}
+
+	acc := newFusionAccumulator()
+	for _, emb := range embs {
+		docs, err := n.Store.Search(ctx, emb, n.K)
+		if err != nil {
+			return nil, fmt.Errorf("MultiQueryRetrieveNode: %w", err)
+		}
+		for rank, d := range docs {
+			acc.add(d, 1/(60+float64(rank+1)))
+		}
+	}
+
+	fused := acc.sorted()
+	if n.Limit > 0 && n.Limit < len(fused) {
+		fused = fused[:n.Limit]
+	}
+	out := make([]Document, len(fused))
+	for i, s := range fused {
+		out[i] = s.Document
+	}
+	mem.Local[n.ResultKey] = out
+
+	return []Trigger{
+		{Action: DefaultAction, ForkingData: map[string]any{}},
+	}, nil
+}
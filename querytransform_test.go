@@ -0,0 +1,94 @@
+package nodechain
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// echoLLM records the prompt it was sent and replies with reply.
+type echoLLM struct {
+	prompt string
+	reply  string
+}
+
+func (e *echoLLM) Name() string { return "echo" }
+
+func (e *echoLLM) Chat(ctx context.Context, msgs []LLMMessage) (LLMResponse, error) {
+	e.prompt = msgs[len(msgs)-1].Content
+	return LLMResponse{Text: e.reply}, nil
+}
+
+func TestQueryTransformPrompts(t *testing.T) {
+	tests := []struct {
+		name   string
+		node   func(LLMProvider) Node
+		prompt string
+		want   string // expected prompt; "" expects an error
+	}{
+		{
+			name:   "rewrite",
+			node:   func(p LLMProvider) Node { return NewQueryRewriteNode(p, "query", "out") },
+			prompt: "Rewrite: {query}",
+			want:   "Rewrite: 100% {n} sure?",
+		},
+		{
+			name:   "hyde",
+			node:   func(p LLMProvider) Node { return NewHyDENode(p, "query", "out") },
+			prompt: "{query}\nAnswer it.",
+			want:   "100% {n} sure?\nAnswer it.",
+		},
+		{
+			name:   "multi-query",
+			node:   func(p LLMProvider) Node { return NewMultiQueryNode(p, "query", "out", 3) },
+			prompt: "Give {n} queries for {query}",
+			want:   "Give 3 queries for 100% {n} sure?",
+		},
+		{
+			name:   "fmt-style prompt",
+			node:   func(p LLMProvider) Node { return NewQueryRewriteNode(p, "query", "out") },
+			prompt: "Rewrite: %s",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			llm := &echoLLM{reply: "rewritten"}
+			n := tt.node(llm)
+			switch n := n.(type) {
+			case *QueryRewriteNode:
+				n.Prompt = tt.prompt
+			case *HyDENode:
+				n.Prompt = tt.prompt
+			case *MultiQueryNode:
+				n.Prompt = tt.prompt
+			}
+
+			mem := NewMemory(map[string]any{"query": "100% {n} sure?"})
+			_, err := n.Run(context.Background(), mem)
+			if tt.want == "" {
+				if err == nil || !strings.Contains(err.Error(), "{query}") {
+					t.Fatalf("err = %v, want a missing placeholder error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if llm.prompt != tt.want {
+				t.Fatalf("prompt %q, want %q", llm.prompt, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultQueryTransformPrompts(t *testing.T) {
+	for _, p := range []string{DefaultRewritePrompt, DefaultHyDEPrompt, DefaultMultiQueryPrompt} {
+		got, err := fillPrompt("test", p, "why?", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(got, "%") || strings.Contains(got, "{") || !strings.Contains(got, "why?") {
+			t.Errorf("default prompt filled as %q", got)
+		}
+	}
+}
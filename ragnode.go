@@ -4,14 +4,23 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"text/template"
 )
 
 // EmbedQueryNode: reads a query string from memory and stores its embedding.
+//
+// Set Store to the store the embedding will be searched in: the constructor
+// leaves it nil, and without it a query embedded with the wrong model is not
+// caught here and silently retrieves unrelated documents.
 type EmbedQueryNode struct {
 	BaseNode
 	Embedder     Embedder
 	QueryKey     string // memory key for the query text
 	EmbeddingKey string // memory key for the query embedding
+
+	// Store, if an EmbeddingChecker, is checked against the query embedding.
+	// nil skips the check.
+	Store VectorStore
 }
 
 func NewEmbedQueryNode(embedder Embedder, queryKey, embeddingKey string) *EmbedQueryNode {
@@ -47,6 +56,12 @@ func (n *EmbedQueryNode) Run(ctx context.Context, mem *Memory) ([]Trigger, error
 		return nil, fmt.Errorf("EmbedQueryNode: expected 1 embedding, got %d", len(embs))
 	}
 
+	if checker, ok := n.Store.(EmbeddingChecker); ok {
+		if err := checker.CheckEmbedding(n.Embedder.Name(), len(embs[0])); err != nil {
+			return nil, fmt.Errorf("EmbedQueryNode: %w", err)
+		}
+	}
+
 	mem.Local[n.EmbeddingKey] = embs[0]
 
 	return []Trigger{
@@ -61,6 +76,19 @@ type RetrieveNode struct {
 	EmbeddingKey string // key where the query embedding is stored
 	K            int
 	ResultKey    string // key where retrieved docs will be stored ([]Document)
+
+	// MMR re-ranks FetchK candidates with maximal marginal relevance before
+	// keeping the top K, so near-duplicate chunks don't crowd the results.
+	MMR       bool
+	MMRLambda float64 // 1 = pure relevance, 0 = pure diversity
+	FetchK    int     // candidates considered by MMR; defaults to 4*K
+
+	// Parents enables small-to-big retrieval: matching chunks are replaced
+	// by their parent documents (Window == 0) or by passages of Window
+	// neighbouring chunks either side (Window > 0), fetched from Parents.
+	// IngestPipeline fills such a store when its Parents field is set.
+	Parents DocumentStore
+	Window  int
 }
 
 func NewRetrieveNode(store VectorStore, embeddingKey, resultKey string, k int) *RetrieveNode {
@@ -70,6 +98,7 @@ func NewRetrieveNode(store VectorStore, embeddingKey, resultKey string, k int) *
 		EmbeddingKey: embeddingKey,
 		K:            k,
 		ResultKey:    resultKey,
+		MMRLambda:    0.5,
 	}
 }
 
@@ -89,9 +118,32 @@ func (n *RetrieveNode) Run(ctx context.Context, mem *Memory) ([]Trigger, error)
 		return nil, fmt.Errorf("RetrieveNode: value at key '%s' is not []float32", n.EmbeddingKey)
 	}
 
-	docs, err := n.Store.Search(ctx, emb, n.K)
+	fetchK := n.K
+	if n.MMR {
+		fetchK = n.FetchK
+		if fetchK < n.K {
+			fetchK = 4 * n.K
+		}
+	}
+
+	docs, err := n.Store.Search(ctx, emb, fetchK)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("RetrieveNode: %w", err)
+	}
+
+	if n.MMR {
+		docs = MaxMarginalRelevance(emb, docs, n.K, n.MMRLambda)
+	}
+
+	if n.Parents != nil {
+		if n.Window > 0 {
+			docs, err = ExpandToWindows(ctx, n.Parents, docs, n.Window)
+		} else {
+			docs, err = ExpandToParents(ctx, n.Parents, docs)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("RetrieveNode: %w", err)
+		}
 	}
 
 	mem.Local[n.ResultKey] = docs
@@ -101,12 +153,58 @@ func (n *RetrieveNode) Run(ctx context.Context, mem *Memory) ([]Trigger, error)
 	}, nil
 }
 
+// RAGPromptData is passed to RAGPromptNode's template.
+type RAGPromptData struct {
+	System    string // instructions; empty when they go in a system message
+	Query     string
+	Documents []RAGPromptDocument
+}
+
+// RAGPromptDocument is one context chunk as presented to the template.
+type RAGPromptDocument struct {
+	Number int // 1-based, as cited in the answer
+	ID     string
+	Source string
+	Text   string
+}
+
+const DefaultRAGSystem = "You are a helpful assistant. Use ONLY the following context to answer the question.\n" +
+	"Cite the passages you rely on by their number, e.g. [1] or [2, 3]."
+
+var DefaultRAGTemplate = template.Must(template.New("rag").Parse(
+	`{{with .System}}{{.}}
+
+{{end}}Context:
+{{range .Documents}}[{{.Number}}] (source: {{.Source}}) {{.Text}}
+{{end}}
+Question:
+{{.Query}}
+
+Answer:`))
+
 // RAGPromptNode: builds a prompt using the query and retrieved documents.
+//
+// Documents are added in rank order until the next one does not fit in
+// Budget; it and every later one are dropped, or with TrimToFit it is cut
+// down to the remaining budget instead. Budget counts document text only:
+// the template, System and query come on top of it, so leave room for them
+// when sizing it against a model's context window. With AsMessages the node
+// stores []LLMMessage (System as the system message, the rendered template
+// as the user message) instead of a prompt string.
 type RAGPromptNode struct {
 	BaseNode
 	QueryKey   string // query text
 	ContextKey string // []Document
 	PromptKey  string // where to store final prompt
+	UsedKey    string // optional: where the []Document actually included is stored; give it to CitationNode
+
+	Template   *template.Template // executed with RAGPromptData
+	System     string
+	AsMessages bool
+
+	Budget    int       // max total document text; 0 means unlimited
+	Tokenizer Tokenizer // measures Budget in tokens; nil means runes
+	TrimToFit bool
 }
 
 func NewRAGPromptNode(queryKey, contextKey, promptKey string) *RAGPromptNode {
@@ -115,6 +213,8 @@ func NewRAGPromptNode(queryKey, contextKey, promptKey string) *RAGPromptNode {
 		QueryKey:   queryKey,
 		ContextKey: contextKey,
 		PromptKey:  promptKey,
+		Template:   DefaultRAGTemplate,
+		System:     DefaultRAGSystem,
 	}
 }
 
@@ -147,19 +247,84 @@ func (n *RAGPromptNode) Run(ctx context.Context, mem *Memory) ([]Trigger, error)
 		return nil, fmt.Errorf("RAGPromptNode: value at key '%s' is not []Document", n.ContextKey)
 	}
 
+	used := n.fitBudget(docs)
+
+	data := RAGPromptData{Query: query}
+	if !n.AsMessages {
+		data.System = n.System
+	}
+	for i, d := range used {
+		data.Documents = append(data.Documents, RAGPromptDocument{
+			Number: i + 1,
+			ID:     d.ID,
+			Source: DocumentSource(d),
+			Text:   d.Text,
+		})
+	}
+
+	tmpl := n.Template
+	if tmpl == nil {
+		tmpl = DefaultRAGTemplate
+	}
 	var b strings.Builder
-	b.WriteString("You are a helpful assistant. Use ONLY the following context to answer the question.\n\n")
-	b.WriteString("Context:\n")
-	for i, d := range docs {
-		b.WriteString(fmt.Sprintf("[%d] %s\n", i+1, d.Text))
+	if err := tmpl.Execute(&b, data); err != nil {
+		return nil, fmt.Errorf("RAGPromptNode: template: %w", err)
 	}
-	b.WriteString("\nQuestion:\n")
-	b.WriteString(query)
-	b.WriteString("\n\nAnswer:")
 
-	mem.Local[n.PromptKey] = b.String()
+	if n.AsMessages {
+		var msgs []LLMMessage
+		if n.System != "" {
+			msgs = append(msgs, LLMMessage{Role: "system", Content: n.System})
+		}
+		mem.Local[n.PromptKey] = append(msgs, LLMMessage{Role: "user", Content: b.String()})
+	} else {
+		mem.Local[n.PromptKey] = b.String()
+	}
+	if n.UsedKey != "" {
+		mem.Local[n.UsedKey] = used
+	}
 
 	return []Trigger{
 		{Action: DefaultAction, ForkingData: map[string]any{}},
 	}, nil
 }
+
+// fitBudget returns the leading documents whose combined text fits in
+// n.Budget.
+func (n *RAGPromptNode) fitBudget(docs []Document) []Document {
+	if n.Budget <= 0 {
+		return docs
+	}
+
+	size := func(s string) int {
+		if n.Tokenizer != nil {
+			return CountTokens(n.Tokenizer, s)
+		}
+		return len([]rune(s))
+	}
+
+	var out []Document
+	remaining := n.Budget
+	for _, d := range docs {
+		l := size(d.Text)
+		if l <= remaining {
+			out = append(out, d)
+			remaining -= l
+			continue
+		}
+		// skipping it would let lower-ranked documents take its place
+		if n.TrimToFit && remaining > 0 {
+			d.Text = n.truncate(d.Text, remaining)
+			out = append(out, d)
+		}
+		break
+	}
+	return out
+}
+
+func (n *RAGPromptNode) truncate(text string, limit int) string {
+	if n.Tokenizer != nil {
+		return strings.TrimSpace(strings.Join(n.Tokenizer.Tokenize(text)[:limit], ""))
+	}
+	return string([]rune(text)[:limit])
+}
@@ -0,0 +1,88 @@
+package nodechain
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestEmbedQueryNodeChecksStore(t *testing.T) {
+	stored := NewHashingEmbedder(16)
+	docs := embedDocs(t, stored, "a")
+	docs[0].Metadata = map[string]any{MetadataEmbedder: stored.Name()}
+	store := NewInMemoryVectorStore()
+	if err := store.Add(context.Background(), docs); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		embedder Embedder
+		store    VectorStore
+		wantErr  bool
+	}{
+		{"same embedder", stored, store, false},
+		{"other dimension", NewHashingEmbedder(32), store, true},
+		{"no store", NewHashingEmbedder(32), nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := NewEmbedQueryNode(tt.embedder, "query", "emb")
+			n.Store = tt.store
+			mem := NewMemory(map[string]any{"query": "a"})
+			_, err := n.Run(context.Background(), mem)
+
+			var mismatch *EmbeddingMismatchError
+			if tt.wantErr != errors.As(err, &mismatch) {
+				t.Fatalf("err = %v, want mismatch: %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				if _, ok := mem.Local["emb"].([]float32); !ok {
+					t.Fatal("no embedding stored")
+				}
+			}
+		})
+	}
+}
+
+func TestRAGPromptNodeBudget(t *testing.T) {
+	docs := []Document{
+		{ID: "a", Text: "aaaa"},
+		{ID: "b", Text: "bbbbbbbb"},
+		{ID: "c", Text: "cc"},
+	}
+	tests := []struct {
+		name   string
+		budget int
+		trim   bool
+		want   []string // used document texts
+	}{
+		{"unlimited", 0, false, []string{"aaaa", "bbbbbbbb", "cc"}},
+		{"all fit", 14, false, []string{"aaaa", "bbbbbbbb", "cc"}},
+		{"stops at the first that does not fit", 10, false, []string{"aaaa"}},
+		{"trims the first that does not fit", 10, true, []string{"aaaa", "bbbbbb"}},
+		{"first does not fit", 3, false, nil},
+		{"exact", 4, true, []string{"aaaa"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := NewRAGPromptNode("query", "docs", "prompt")
+			n.Budget, n.TrimToFit, n.UsedKey = tt.budget, tt.trim, "used"
+			mem := NewMemory(map[string]any{"query": "q", "docs": docs})
+			if _, err := n.Run(context.Background(), mem); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, d := range mem.Local["used"].([]Document) {
+				got = append(got, d.Text)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("used %q, want %q", got, tt.want)
+			}
+			if docs[1].Text != "bbbbbbbb" {
+				t.Fatal("trimming modified the caller's document")
+			}
+		})
+	}
+}
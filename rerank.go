@@ -0,0 +1,280 @@
+package nodechain
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Reranker scores retrieved documents against a query. Implementations return
+// one ScoredDocument per input document, in any order.
+type Reranker interface {
+	Rerank(ctx context.Context, query string, docs []Document) ([]ScoredDocument, error)
+	Name() string
+}
+
+// LLMReranker asks an LLM to grade each query/passage pair on a 0-10 scale,
+// with up to Concurrency requests in flight. A reply without a number
+// scores 0.
+type LLMReranker struct {
+	Provider    LLMProvider
+	System      string
+	Concurrency int // passages graded at once
+}
+
+func NewLLMReranker(provider LLMProvider) *LLMReranker {
+	return &LLMReranker{
+		Provider:    provider,
+		System:      "You grade how well a passage answers a question. Reply with a single number from 0 to 10 and nothing else.",
+		Concurrency: 4,
+	}
+}
+
+func (r *LLMReranker) Name() string { return "llm-reranker(" + r.Provider.Name() + ")" }
+
+func (r *LLMReranker) Rerank(ctx context.Context, query string, docs []Document) ([]ScoredDocument, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+		sem      = make(chan struct{}, max(r.Concurrency, 1))
+	)
+
+	out := make([]ScoredDocument, len(docs))
+	for i, d := range docs {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			prompt := "Question:\n" + query + "\n\nPassage:\n" + d.Text + "\n\nRelevance (0-10):"
+			resp, err := r.Provider.Chat(ctx, []LLMMessage{
+				{Role: "system", Content: r.System},
+				{Role: "user", Content: prompt},
+			})
+			if err != nil {
+				errOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+
+			// one rambling reply should not sink the whole batch
+			out[i] = ScoredDocument{Document: d, Score: parseRelevanceScore(resp.Text)}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// relevanceScoreRe matches a number, optionally written as a fraction such as
+// "8/10" or "8 out of 10".
+var relevanceScoreRe = regexp.MustCompile(`(?i)(\d+(?:\.\d+)?)(?:\s*(?:/|out of)\s*\d+(?:\.\d+)?)?`)
+
+// parseRelevanceScore takes the last number in an LLM reply, since models
+// that ignore the format tend to restate the scale first ("On a scale of
+// 0-10, 7"). Fractions count by their numerator ("7.5/10" is 7.5). It
+// returns 0 if there is no number.
+func parseRelevanceScore(text string) float64 {
+	matches := relevanceScoreRe.FindAllStringSubmatch(text, -1)
+	if len(matches) == 0 {
+		return 0
+	}
+	v, err := strconv.ParseFloat(matches[len(matches)-1][1], 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+// HTTPCrossEncoderReranker calls a cross-encoder served over HTTP. The
+// request/response shape follows the text-embeddings-inference /rerank API:
+//
+//	POST {"query": "...", "texts": ["...", ...]}
+//	-> [{"index": 0, "score": 0.93}, ...]
+//
+// Documents the server leaves out of its response are kept, ranked below
+// every scored one.
+type HTTPCrossEncoderReranker struct {
+	Endpoint string
+	Headers  map[string]string // e.g. Authorization
+	Client   *http.Client
+}
+
+func NewHTTPCrossEncoderReranker(endpoint string) *HTTPCrossEncoderReranker {
+	return &HTTPCrossEncoderReranker{
+		Endpoint: endpoint,
+		Headers:  map[string]string{},
+		Client:   http.DefaultClient,
+	}
+}
+
+func (r *HTTPCrossEncoderReranker) Name() string { return "cross-encoder(" + r.Endpoint + ")" }
+
+type crossEncoderRequest struct {
+	Query string   `json:"query"`
+	Texts []string `json:"texts"`
+}
+
+type crossEncoderResult struct {
+	Index int     `json:"index"`
+	Score float64 `json:"score"`
+}
+
+func (r *HTTPCrossEncoderReranker) Rerank(ctx context.Context, query string, docs []Document) ([]ScoredDocument, error) {
+	if len(docs) == 0 {
+		return nil, nil
+	}
+
+	texts := make([]string, len(docs))
+	for i, d := range docs {
+		texts[i] = d.Text
+	}
+	body, err := json.Marshal(crossEncoderRequest{Query: query, Texts: texts})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range r.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("HTTPCrossEncoderReranker: %s: %s", resp.Status, msg)
+	}
+
+	var results []crossEncoderResult
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return nil, fmt.Errorf("HTTPCrossEncoderReranker: decoding response: %w", err)
+	}
+
+	out := make([]ScoredDocument, 0, len(docs))
+	scored := make([]bool, len(docs))
+	lowest := 0.0
+	for _, res := range results {
+		if res.Index < 0 || res.Index >= len(docs) {
+			return nil, fmt.Errorf("HTTPCrossEncoderReranker: result index %d out of range", res.Index)
+		}
+		if scored[res.Index] {
+			continue
+		}
+		scored[res.Index] = true
+		out = append(out, ScoredDocument{Document: docs[res.Index], Score: res.Score})
+		if len(out) == 1 || res.Score < lowest {
+			lowest = res.Score
+		}
+	}
+	for i, d := range docs {
+		if !scored[i] {
+			out = append(out, ScoredDocument{Document: d, Score: lowest - 1})
+		}
+	}
+	return out, nil
+}
+
+// RerankNode: reorders retrieved documents with a Reranker and keeps the top N.
+type RerankNode struct {
+	BaseNode
+	Reranker   Reranker
+	QueryKey   string // query text
+	ContextKey string // []Document to rerank
+	ResultKey  string // where the reranked []Document is stored
+	TopN       int    // 0 keeps every document
+}
+
+func NewRerankNode(reranker Reranker, queryKey, contextKey, resultKey string, topN int) *RerankNode {
+	return &RerankNode{
+		BaseNode:   NewBaseNode(),
+		Reranker:   reranker,
+		QueryKey:   queryKey,
+		ContextKey: contextKey,
+		ResultKey:  resultKey,
+		TopN:       topN,
+	}
+}
+
+func (n *RerankNode) TypeName() string { return "RerankNode" }
+
+func (n *RerankNode) Run(ctx context.Context, mem *Memory) ([]Trigger, error) {
+	rawQ, ok := mem.Get(n.QueryKey)
+	if !ok {
+		return nil, fmt.Errorf("RerankNode: query not found at key '%s'", n.QueryKey)
+	}
+	query, ok := rawQ.(string)
+	if !ok {
+		return nil, fmt.Errorf("RerankNode: query at key '%s' is not a string", n.QueryKey)
+	}
+
+	rawDocs, ok := mem.Get(n.ContextKey)
+	if !ok {
+		return nil, fmt.Errorf("RerankNode: documents not found at key '%s'", n.ContextKey)
+	}
+	docs, ok := rawDocs.([]Document)
+	if !ok {
+		return nil, fmt.Errorf("RerankNode: value at key '%s' is not []Document", n.ContextKey)
+	}
+
+	scored, err := n.Reranker.Rerank(ctx, query, docs)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(scored, func(i, j int) bool {
+		return scored[i].Score > scored[j].Score
+	})
+	if n.TopN > 0 && n.TopN < len(scored) {
+		scored = scored[:n.TopN]
+	}
+
+	out := make([]Document, len(scored))
+	for i, s := range scored {
+		out[i] = s.Document
+	}
+	mem.Local[n.ResultKey] = out
+
+	return []Trigger{
+		{Action: DefaultAction, ForkingData: map[string]any{}},
+	}, nil
+}
@@ -0,0 +1,184 @@
+package nodechain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHTTPCrossEncoderRerankerKeepsUnscoredDocuments(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     []string
+	}{
+		{"all scored", `[{"index":2,"score":0.9},{"index":0,"score":0.5},{"index":1,"score":0.1}]`, []string{"c", "a", "b"}},
+		{"missing documents ranked last", `[{"index":1,"score":-3}]`, []string{"b", "a", "c"}},
+		{"duplicate index ignored", `[{"index":0,"score":0.2},{"index":0,"score":0.9},{"index":2,"score":0.4}]`, []string{"c", "a", "b"}},
+		{"empty response", `[]`, []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req crossEncoderRequest
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Texts) != 3 {
+					t.Errorf("bad request: %+v, %v", req, err)
+				}
+				w.Write([]byte(tt.response))
+			}))
+			defer srv.Close()
+
+			docs := []Document{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+			n := NewRerankNode(NewHTTPCrossEncoderReranker(srv.URL), "q", "docs", "out", 0)
+			mem := NewMemory(map[string]any{"q": "query", "docs": docs})
+			if _, err := n.Run(context.Background(), mem); err != nil {
+				t.Fatal(err)
+			}
+			if got := docIDs(mem.Local["out"].([]Document)); !slices.Equal(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPCrossEncoderRerankerBadIndex(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"index":5,"score":1}]`))
+	}))
+	defer srv.Close()
+
+	if _, err := NewHTTPCrossEncoderReranker(srv.URL).Rerank(context.Background(), "q", []Document{{ID: "a"}}); err == nil {
+		t.Fatal("out-of-range index accepted")
+	}
+}
+
+// scriptedLLM replies with the reply whose key appears in the prompt.
+type scriptedLLM map[string]string
+
+func (s scriptedLLM) Name() string { return "scripted" }
+
+func (s scriptedLLM) Chat(ctx context.Context, msgs []LLMMessage) (LLMResponse, error) {
+	prompt := msgs[len(msgs)-1].Content
+	for key, reply := range s {
+		if strings.Contains(prompt, key) {
+			return LLMResponse{Text: reply}, nil
+		}
+	}
+	return LLMResponse{}, nil
+}
+
+func TestLLMRerankerUnparsableScore(t *testing.T) {
+	llm := scriptedLLM{
+		"alpha": "Score: 7.5/10",
+		"beta":  "I cannot judge this passage.",
+		"gamma": "9",
+	}
+	docs := []Document{{ID: "a", Text: "alpha"}, {ID: "b", Text: "beta"}, {ID: "c", Text: "gamma"}}
+	scored, err := NewLLMReranker(llm).Rerank(context.Background(), "q", docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{"a": 7.5, "b": 0, "c": 9}
+	for _, s := range scored {
+		if s.Score != want[s.ID] {
+			t.Errorf("%s scored %v, want %v", s.ID, s.Score, want[s.ID])
+		}
+	}
+}
+
+func TestParseRelevanceScore(t *testing.T) {
+	tests := []struct {
+		reply string
+		want  float64
+	}{
+		{"8", 8},
+		{"Score: 7.5", 7.5},
+		{"7.5/10", 7.5},
+		{"8 / 10", 8},
+		{"6 out of 10.", 6},
+		{"On a scale of 0-10, 7", 7},
+		{"Relevance (0-10): 3", 3},
+		{"I cannot judge this passage.", 0},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRelevanceScore(tt.reply); got != tt.want {
+			t.Errorf("parseRelevanceScore(%q) = %v, want %v", tt.reply, got, tt.want)
+		}
+	}
+}
+
+// gatedLLM blocks every Chat until release is closed, recording the most
+// calls in flight at once.
+type gatedLLM struct {
+	release chan struct{}
+	fail    string // prompts containing this fail
+
+	mu       sync.Mutex
+	inFlight int
+	peak     int
+}
+
+func (g *gatedLLM) Name() string { return "gated" }
+
+func (g *gatedLLM) Chat(ctx context.Context, msgs []LLMMessage) (LLMResponse, error) {
+	g.mu.Lock()
+	g.inFlight++
+	g.peak = max(g.peak, g.inFlight)
+	g.mu.Unlock()
+	defer func() {
+		g.mu.Lock()
+		g.inFlight--
+		g.mu.Unlock()
+	}()
+
+	if g.fail != "" && strings.Contains(msgs[len(msgs)-1].Content, g.fail) {
+		return LLMResponse{}, errors.New("provider down")
+	}
+	select {
+	case <-g.release:
+		return LLMResponse{Text: "5"}, nil
+	case <-ctx.Done():
+		return LLMResponse{}, ctx.Err()
+	}
+}
+
+func TestLLMRerankerConcurrency(t *testing.T) {
+	var docs []Document
+	for i := range 10 {
+		docs = append(docs, Document{ID: fmt.Sprint(i), Text: fmt.Sprintf("passage %d", i)})
+	}
+
+	llm := &gatedLLM{release: make(chan struct{})}
+	r := NewLLMReranker(llm)
+	r.Concurrency = 3
+	time.AfterFunc(50*time.Millisecond, func() { close(llm.release) })
+
+	scored, err := r.Rerank(context.Background(), "q", docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if llm.peak != 3 {
+		t.Errorf("%d calls in flight, want 3", llm.peak)
+	}
+	for i, s := range scored {
+		if s.ID != docs[i].ID || s.Score != 5 {
+			t.Errorf("result %d: %s scored %v", i, s.ID, s.Score)
+		}
+	}
+
+	// a failed call stops the rest instead of waiting on them
+	llm = &gatedLLM{release: make(chan struct{}), fail: "passage 1"}
+	r.Provider = llm
+	if _, err := r.Rerank(context.Background(), "q", docs); err == nil || err.Error() != "provider down" {
+		t.Fatalf("got %v, want the provider's error", err)
+	}
+}
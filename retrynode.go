@@ -2,7 +2,7 @@ package nodechain
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"time"
 )
 
@@ -11,6 +11,13 @@ type RetryNode struct {
 	Inner      Node
 	MaxRetries int
 	RetryDelay time.Duration
+
+	// Sandbox, if set, is snapshotted before the first attempt and restored
+	// before each retry, so a failed attempt's changes do not leak into the
+	// next. It must be a Snapshotter. SandboxKey names a memory key holding
+	// the sandbox instead (see SandboxNode).
+	Sandbox    Sandbox
+	SandboxKey string
 }
 
 func NewRetryNode(inner Node, maxRetries int, retryDelay time.Duration) *RetryNode {
@@ -30,7 +37,26 @@ func (n *RetryNode) TypeName() string {
 func (n *RetryNode) Run(ctx context.Context, mem *Memory) ([]Trigger, error) {
 	var lastErr error
 
+	var sb Snapshotter
+	var snap SandboxSnapshot
+	if n.Sandbox != nil || n.SandboxKey != "" {
+		var err error
+		if sb, err = snapshotterFromMemory(mem, n.SandboxKey, n.Sandbox); err != nil {
+			return nil, fmt.Errorf("RetryNode: %w", err)
+		}
+		if snap, err = sb.Snapshot(ctx, internalSnapshotPrefix+"retry "+n.TypeName()); err != nil {
+			return nil, fmt.Errorf("RetryNode: snapshot: %w", err)
+		}
+		defer sb.DeleteSnapshot(context.Background(), snap.ID)
+	}
+
 	for attempt := 0; attempt < n.MaxRetries; attempt++ {
+		if attempt > 0 && sb != nil {
+			if err := sb.Restore(ctx, snap.ID); err != nil {
+				return nil, fmt.Errorf("RetryNode: restoring sandbox: %w", err)
+			}
+		}
+
 		triggers, err := n.Inner.Run(ctx, mem)
 		if err == nil {
 			return triggers, nil
@@ -46,7 +72,7 @@ func (n *RetryNode) Run(ctx context.Context, mem *Memory) ([]Trigger, error) {
 			}
 		}
 	}
-	return nil, errors.New("RetryNode: all attempts failed: " + lastErr.Error())
+	return nil, fmt.Errorf("RetryNode: all attempts failed: %w", lastErr)
 }
 
 // successors propagate to inner node's successors
@@ -0,0 +1,240 @@
+package nodechain
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ExecResult is the outcome of a command run in a Sandbox. A command that
+// runs and exits non-zero, or is stopped by its timeout, is a result, not an
+// error.
+type ExecResult struct {
+	Stdout    string `json:"stdout"`
+	Stderr    string `json:"stderr"`
+	ExitCode  int    `json:"exit_code"`           // -1 if the command was killed
+	TimedOut  bool   `json:"timed_out,omitempty"` // killed after ExecOptions.Timeout
+	Truncated bool   `json:"truncated,omitempty"` // output exceeded ExecOptions.MaxOutputBytes
+}
+
+const (
+	DefaultExecTimeout    = 5 * time.Minute
+	DefaultMaxOutputBytes = 64 << 10
+)
+
+// ExecOptions bounds a single Exec. Zero values use the defaults above;
+// negative values disable the limit.
+type ExecOptions struct {
+	Timeout        time.Duration
+	MaxOutputBytes int // per stream; the middle of longer output is dropped
+}
+
+func (o ExecOptions) timeout() time.Duration {
+	switch {
+	case o.Timeout == 0:
+		return DefaultExecTimeout
+	case o.Timeout < 0:
+		return 0
+	}
+	return o.Timeout
+}
+
+func (o ExecOptions) maxOutput() int {
+	switch {
+	case o.MaxOutputBytes == 0:
+		return DefaultMaxOutputBytes
+	case o.MaxOutputBytes < 0:
+		return 0
+	}
+	return o.MaxOutputBytes
+}
+
+// Sandbox is an isolated place for agents to run shell commands, with a
+// workspace directory (/workspace) that files can be copied in and out of.
+// Exec returns an error only when the command could not be run at all, or
+// when ctx itself was cancelled; the command is killed in both cases.
+type Sandbox interface {
+	Start(ctx context.Context) error
+	Exec(ctx context.Context, cmd string, opts ExecOptions) (ExecResult, error)
+	CopyIn(ctx context.Context, hostPath, sandboxPath string) error
+	CopyOut(ctx context.Context, sandboxPath, hostPath string) error
+	Stop(ctx context.Context) error
+}
+
+// ExitError is returned by DockerManager.Run for commands that exit
+// non-zero or time out.
+type ExitError struct {
+	Result ExecResult
+}
+
+func (e *ExitError) Error() string {
+	if e.Result.TimedOut {
+		return "command timed out"
+	}
+	return fmt.Sprintf("exit status %d", e.Result.ExitCode)
+}
+
+// cappedBuffer keeps the first and last max/2 bytes written to it and counts
+// what it drops in between. max <= 0 keeps everything.
+type cappedBuffer struct {
+	max     int
+	head    []byte
+	tail    []byte // ring buffer once full
+	next    int    // write position in tail
+	dropped int
+}
+
+func newCappedBuffer(max int) *cappedBuffer {
+	return &cappedBuffer{max: max}
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if b.max <= 0 {
+		b.head = append(b.head, p...)
+		return n, nil
+	}
+
+	headMax := b.max / 2
+	if room := headMax - len(b.head); room > 0 {
+		take := min(room, len(p))
+		b.head = append(b.head, p[:take]...)
+		p = p[take:]
+	}
+
+	tailMax := b.max - headMax
+	for len(p) > 0 {
+		if len(b.tail) < tailMax {
+			take := min(tailMax-len(b.tail), len(p))
+			b.tail = append(b.tail, p[:take]...)
+			p = p[take:]
+			continue
+		}
+		// overwrite the oldest tail bytes
+		take := min(tailMax-b.next, len(p))
+		copy(b.tail[b.next:], p[:take])
+		b.dropped += take
+		b.next = (b.next + take) % tailMax
+		p = p[take:]
+	}
+	return n, nil
+}
+
+func (b *cappedBuffer) Truncated() bool { return b.dropped > 0 }
+
+// String returns the kept output, with a marker where bytes were dropped.
+func (b *cappedBuffer) String() string {
+	tail := append(append([]byte{}, b.tail[b.next:]...), b.tail[:b.next]...)
+	if b.dropped == 0 {
+		return string(b.head) + string(tail)
+	}
+	sep := "\n"
+	if len(b.head) > 0 && b.head[len(b.head)-1] == '\n' {
+		sep = ""
+	}
+	return fmt.Sprintf("%s%s[... %d bytes truncated ...]\n%s", b.head, sep, b.dropped, tail)
+}
+
+// resolveIn maps a sandbox path onto the host directory root, which is
+// mounted at /workspace. Relative paths are taken relative to root. Paths
+// that escape root, lexically or through a symlink, are refused.
+func resolveIn(root, p string) (string, error) {
+	if rest, ok := strings.CutPrefix(p, "/workspace"); ok && (rest == "" || rest[0] == '/') {
+		p = rest
+	}
+	full := filepath.Join(root, filepath.FromSlash(strings.TrimPrefix(p, "/")))
+	if !within(root, full) {
+		return "", fmt.Errorf("path %q escapes the sandbox", p)
+	}
+
+	// the deepest existing ancestor (or the path itself) must resolve inside
+	// root, or writes through it would land outside
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	for dir := full; ; dir = filepath.Dir(dir) {
+		if real, err := filepath.EvalSymlinks(dir); err == nil {
+			if !within(realRoot, real) {
+				return "", fmt.Errorf("path %q escapes the sandbox through a symlink", p)
+			}
+			break
+		}
+		if dir == root || dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	// the leaf is opened by the caller, and opening follows a final symlink
+	// even when it dangles, so refuse links the sandbox could have planted
+	if info, err := os.Lstat(full); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return "", fmt.Errorf("path %q is a symlink", p)
+	}
+	return full, nil
+}
+
+func within(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// copyPath copies a file or directory tree. Symlinks are not followed,
+// in src or dst: an existing symlink anywhere in the destination tree is
+// refused rather than written through, since the sandbox may have planted it.
+func copyPath(src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if existing, err := os.Lstat(dst); err == nil && existing.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("copy to %s: destination is a symlink", dst)
+	}
+
+	switch {
+	case info.IsDir():
+		if err := os.MkdirAll(dst, info.Mode().Perm()|0o700); err != nil {
+			return err
+		}
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := copyPath(filepath.Join(src, e.Name()), filepath.Join(dst, e.Name())); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dst)
+
+	case info.Mode().IsRegular():
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		in, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|oNoFollow, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	}
+	return fmt.Errorf("copy %s: unsupported file type", src)
+}
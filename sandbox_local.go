@@ -0,0 +1,378 @@
+//go:build unix
+
+package nodechain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RLimits bounds each command run by LocalSandbox. Zero means unlimited.
+type RLimits struct {
+	CPUSeconds  int   // ulimit -t
+	MemoryBytes int64 // address space, ulimit -v
+	FileBytes   int64 // largest file written, ulimit -f
+	Processes   int   // per-user processes, ulimit -u
+	OpenFiles   int   // ulimit -n
+	StackBytes  int64 // ulimit -s
+	CoreDumps   bool  // allow core dumps; off by default
+}
+
+// LocalSandbox runs commands as local processes in a private directory,
+// for hosts without Docker and for tests. It is much weaker isolation than
+// a container: commands see the host filesystem and network. Use User to
+// drop privileges (requires running as root) and Limits to bound resources.
+// Started as root, it refuses to run commands as root unless User is set or
+// AllowRoot says that is intended.
+//
+// Paths inside the sandbox are relative to Dir; a leading "/workspace" is
+// mapped onto Dir so commands and paths written for DockerManager work.
+type LocalSandbox struct {
+	Dir    string // working directory; a temp dir is created if empty
+	User   string // run commands as this user; "" runs as the current user
+	Limits RLimits
+	// AllowRoot lets Start proceed as root with no User, running commands
+	// as root, e.g. in a CI container that is itself the sandbox.
+	AllowRoot bool
+	Env       []string // extra KEY=VALUE entries
+
+	mu        sync.Mutex
+	running   bool
+	tempDir   bool
+	cred      *syscall.Credential
+	snapshots snapshotStore
+
+	// running commands, so Restore and Stop can kill them
+	cmds     map[int]context.CancelFunc
+	nextCmd  int
+	execs    int        // Exec calls in flight
+	execDone *sync.Cond // signalled as execs drops and when killing ends
+	killing  bool       // killCommands is waiting; new commands wait too
+}
+
+func NewLocalSandbox() *LocalSandbox {
+	return &LocalSandbox{
+		Limits: RLimits{
+			CPUSeconds:  60,
+			MemoryBytes: 2 << 30,
+			FileBytes:   1 << 30,
+			OpenFiles:   1024,
+		},
+	}
+}
+
+func (s *LocalSandbox) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running {
+		return nil
+	}
+
+	if s.Dir == "" {
+		dir, err := os.MkdirTemp("", "nodechain-sandbox-")
+		if err != nil {
+			return err
+		}
+		s.Dir, s.tempDir = dir, true
+	} else if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+
+	if s.User == "" && os.Geteuid() == 0 && !s.AllowRoot {
+		return fmt.Errorf("LocalSandbox: refusing to run commands as root; set User to an unprivileged user, or AllowRoot")
+	}
+	if s.User != "" {
+		u, err := user.Lookup(s.User)
+		if err != nil {
+			return fmt.Errorf("LocalSandbox: %w", err)
+		}
+		uid, _ := strconv.Atoi(u.Uid)
+		gid, _ := strconv.Atoi(u.Gid)
+		s.cred = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+		if err := os.Chown(s.Dir, uid, gid); err != nil {
+			return fmt.Errorf("LocalSandbox: handing %s to %s: %w", s.Dir, s.User, err)
+		}
+	}
+
+	s.running = true
+	return nil
+}
+
+func (s *LocalSandbox) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.running {
+		return nil
+	}
+	s.running = false
+	s.killCommands()
+	s.snapshots.clear()
+	if s.tempDir {
+		return os.RemoveAll(s.Dir)
+	}
+	return nil
+}
+
+// Snapshot saves the contents of Dir. Changes made outside it are not
+// captured.
+func (s *LocalSandbox) Snapshot(ctx context.Context, label string) (SandboxSnapshot, error) {
+	s.mu.Lock()
+	running, dir := s.running, s.Dir
+	s.mu.Unlock()
+
+	if !running {
+		return SandboxSnapshot{}, fmt.Errorf("local sandbox not running")
+	}
+	return s.snapshots.save(dir, label, nil)
+}
+
+// Restore replaces the contents of Dir with a snapshot. Running commands
+// are killed first, including ShellSession shells and PythonSession
+// interpreters, which start afresh on their next call.
+func (s *LocalSandbox) Restore(ctx context.Context, ref string) error {
+	snap, err := s.snapshots.find(ref)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.running {
+		return fmt.Errorf("local sandbox not running")
+	}
+	s.killCommands()
+	return restoreDir(s.Dir, snap.archive)
+}
+
+func (s *LocalSandbox) DeleteSnapshot(ctx context.Context, ref string) error {
+	_, err := s.snapshots.remove(ref)
+	return err
+}
+
+func (s *LocalSandbox) Snapshots() []SandboxSnapshot {
+	return s.snapshots.list()
+}
+
+func (s *LocalSandbox) Exec(ctx context.Context, cmdStr string, opts ExecOptions) (ExecResult, error) {
+	s.mu.Lock()
+	running, dir, cred := s.running, s.Dir, s.cred
+	s.mu.Unlock()
+
+	if !running {
+		return ExecResult{}, fmt.Errorf("local sandbox not running")
+	}
+
+	runCtx := ctx
+	if t := opts.timeout(); t > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, t)
+		defer cancel()
+	}
+
+	cmdCtx, done, err := s.track(runCtx, true)
+	if err != nil {
+		return ExecResult{}, err
+	}
+	defer done()
+
+	cmd := s.command(cmdCtx, dir, cred, `eval "$1"`, cmdStr)
+	cmd.WaitDelay = time.Second
+
+	stdout, stderr := newCappedBuffer(opts.maxOutput()), newCappedBuffer(opts.maxOutput())
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err = cmd.Run()
+	res := ExecResult{
+		Stdout:    stdout.String(),
+		Stderr:    stderr.String(),
+		Truncated: stdout.Truncated() || stderr.Truncated(),
+	}
+
+	if runCtx.Err() != nil {
+		res.ExitCode = -1
+		if ctx.Err() != nil {
+			return res, ctx.Err()
+		}
+		res.TimedOut = true
+		return res, nil
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		res.ExitCode = exitErr.ExitCode()
+		err = nil
+	}
+	return res, err
+}
+
+// Command returns an unstarted process running argv in the sandbox, for
+// long-lived processes such as ShellSession's shell. Cancelling ctx kills
+// its process group.
+func (s *LocalSandbox) Command(ctx context.Context, argv ...string) (*exec.Cmd, error) {
+	s.mu.Lock()
+	running, dir, cred := s.running, s.Dir, s.cred
+	s.mu.Unlock()
+
+	if !running {
+		return nil, fmt.Errorf("local sandbox not running")
+	}
+	// the caller waits for the process, so it stays tracked until ctx is
+	// done or Restore or Stop kills it
+	ctx, done, err := s.track(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	context.AfterFunc(ctx, done)
+	cmd := s.command(ctx, dir, cred, `exec "$@"`, argv...)
+	cmd.WaitDelay = time.Second
+	return cmd, nil
+}
+
+// track derives the context a command runs under, cancelled (killing the
+// command's process group) by killCommands. Exec calls are counted so
+// killCommands can wait for them; done must be called when one returns.
+// While killCommands is waiting, new commands wait for it to finish rather
+// than start unkilled under a Restore.
+func (s *LocalSandbox) track(ctx context.Context, isExec bool) (context.Context, func(), error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cmds == nil {
+		s.cmds = map[int]context.CancelFunc{}
+		s.execDone = sync.NewCond(&s.mu)
+	}
+	for s.killing {
+		s.execDone.Wait()
+	}
+	if !s.running {
+		return nil, nil, fmt.Errorf("local sandbox not running")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s.nextCmd++
+	id := s.nextCmd
+	s.cmds[id] = cancel
+	if isExec {
+		s.execs++
+	}
+
+	return ctx, func() {
+		s.mu.Lock()
+		delete(s.cmds, id)
+		if isExec {
+			s.execs--
+			s.execDone.Broadcast()
+		}
+		s.mu.Unlock()
+		cancel()
+	}, nil
+}
+
+// killCommands kills every running command and waits for the Exec calls
+// among them to return. s.mu must be held.
+func (s *LocalSandbox) killCommands() {
+	for id, cancel := range s.cmds {
+		cancel()
+		delete(s.cmds, id)
+	}
+	if s.execs == 0 {
+		return
+	}
+	s.killing = true
+	for s.execs > 0 {
+		s.execDone.Wait()
+	}
+	s.killing = false
+	s.execDone.Broadcast()
+}
+
+// command runs script under bash in dir with the sandbox's limits,
+// environment and user; args are its positional parameters.
+func (s *LocalSandbox) command(ctx context.Context, dir string, cred *syscall.Credential, script string, args ...string) *exec.Cmd {
+	// ulimits are applied by the wrapper shell and inherited by the command
+	script = s.ulimitScript() + `cd "$SANDBOX_DIR" && ` + script
+	cmd := exec.CommandContext(ctx, "bash", append([]string{"-c", script, "sandbox"}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append([]string{
+		"HOME=" + dir,
+		"SANDBOX_DIR=" + dir,
+		"WORKSPACE=" + dir,
+		"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+		"LANG=C.UTF-8",
+	}, s.Env...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: cred}
+	// kill the whole process group, not just bash
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	return cmd
+}
+
+func (s *LocalSandbox) ulimitScript() string {
+	var b strings.Builder
+	l := s.Limits
+	add := func(flag string, v int64) {
+		if v > 0 {
+			fmt.Fprintf(&b, "ulimit -%s %d || exit 125; ", flag, v)
+		}
+	}
+	add("t", int64(l.CPUSeconds))
+	add("v", l.MemoryBytes/1024)
+	add("f", l.FileBytes/512)
+	add("u", int64(l.Processes))
+	add("n", int64(l.OpenFiles))
+	add("s", l.StackBytes/1024)
+	if !l.CoreDumps {
+		b.WriteString("ulimit -c 0; ")
+	}
+	return b.String()
+}
+
+// resolve maps a sandbox path onto the host, refusing paths that escape Dir.
+func (s *LocalSandbox) resolve(p string) (string, error) {
+	s.mu.Lock()
+	dir := s.Dir
+	s.mu.Unlock()
+	return resolveIn(dir, p)
+}
+
+func (s *LocalSandbox) CopyIn(ctx context.Context, hostPath, sandboxPath string) error {
+	dst, err := s.resolve(sandboxPath)
+	if err != nil {
+		return err
+	}
+	if err := copyPath(hostPath, dst); err != nil {
+		return err
+	}
+	if s.cred != nil {
+		return filepath.WalkDir(dst, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			return os.Lchown(p, int(s.cred.Uid), int(s.cred.Gid))
+		})
+	}
+	return nil
+}
+
+func (s *LocalSandbox) CopyOut(ctx context.Context, sandboxPath, hostPath string) error {
+	src, err := s.resolve(sandboxPath)
+	if err != nil {
+		return err
+	}
+	return copyPath(src, hostPath)
+}
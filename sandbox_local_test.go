@@ -0,0 +1,143 @@
+//go:build unix
+
+package nodechain
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalSandboxRestoreKillsCommands(t *testing.T) {
+	sb := startLocalSandbox(t)
+	ctx := context.Background()
+	if _, err := sb.Snapshot(ctx, "clean"); err != nil {
+		t.Fatal(err)
+	}
+
+	shell := NewShellSession(sb)
+	defer shell.Close()
+	if _, err := shell.Exec(ctx, "export MARK=1", ExecOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		res ExecResult
+		err error
+	}
+	started := make(chan struct{})
+	done := make(chan result, 1)
+	go func() {
+		close(started)
+		res, err := sb.Exec(ctx, "touch running; sleep 30", ExecOptions{})
+		done <- result{res, err}
+	}()
+	<-started
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, ok := readTree(t, sb.Dir)["running"]; ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("command did not start")
+		}
+	}
+
+	start := time.Now()
+	if err := sb.Restore(ctx, "clean"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-done:
+		if r.err != nil || r.res.ExitCode != -1 {
+			t.Fatalf("killed command returned %+v, %v", r.res, r.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Restore left the command running")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("Restore waited for the command to finish")
+	}
+	if got := readTree(t, sb.Dir); len(got) != 0 {
+		t.Fatalf("workspace after restore: %v", got)
+	}
+
+	// the shell was killed too and starts afresh
+	if _, err := shell.Exec(ctx, "true", ExecOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if shell.Restarts() == 0 {
+		t.Fatal("shell survived the restore")
+	}
+}
+
+func TestLocalSandboxRefusesRootWithoutUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("not running as root")
+	}
+	sb := NewLocalSandbox()
+	sb.Dir = t.TempDir()
+	if err := sb.Start(context.Background()); err == nil || !strings.Contains(err.Error(), "root") {
+		sb.Stop(context.Background())
+		t.Fatalf("Start as root without User: %v", err)
+	}
+	if _, err := sb.Exec(context.Background(), "true", ExecOptions{}); err == nil {
+		t.Fatal("Exec ran after a refused Start")
+	}
+
+	sb.AllowRoot = true
+	if err := sb.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	sb.Stop(context.Background())
+}
+
+func TestLocalSandboxCommandsWaitForKill(t *testing.T) {
+	sb := startLocalSandbox(t)
+
+	// an Exec that is still returning when Restore kills commands
+	first, firstDone, err := sb.track(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	killed := make(chan struct{})
+	go func() {
+		sb.mu.Lock()
+		sb.killCommands()
+		sb.mu.Unlock()
+		close(killed)
+	}()
+	<-first.Done()
+
+	// a command started while the kill waits must not escape it
+	type tracked struct {
+		ctx  context.Context
+		done func()
+	}
+	second := make(chan tracked, 1)
+	go func() {
+		ctx, done, err := sb.track(context.Background(), true)
+		if err != nil {
+			t.Error(err)
+		}
+		second <- tracked{ctx, done}
+	}()
+	select {
+	case <-second:
+		t.Fatal("command registered while commands were being killed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	firstDone()
+	select {
+	case <-killed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("killCommands waited for a command it never cancelled")
+	}
+	s := <-second
+	defer s.done()
+	if s.ctx.Err() != nil {
+		t.Fatal("command that waited for the kill was cancelled by it")
+	}
+}
@@ -0,0 +1,361 @@
+package nodechain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SandboxPool hands out started sandboxes, one caller at a time each, so
+// concurrent flow runs or branches do not share a container. It keeps Warm
+// idle sandboxes ready, health-checks them before handing them out and
+// recycles each one after MaxUses acquisitions.
+//
+// Sandboxes must not share state through New: for DockerManager, give each
+// one its own Workspace.
+type SandboxPool struct {
+	New         func() Sandbox
+	Warm        int // idle sandboxes kept started
+	Max         int // total sandboxes, idle or leased; 0 is unlimited
+	MaxUses     int // recycle after this many acquisitions; 0 never recycles
+	HealthCheck func(ctx context.Context, sb Sandbox) error
+
+	mu       sync.Mutex
+	idle     []Sandbox
+	uses     map[Sandbox]int
+	leased   map[Sandbox]bool
+	total    int           // idle + leased + starting
+	changed  chan struct{} // closed when a sandbox is released or destroyed
+	closed   bool
+	warmErr  error
+	stopping sync.WaitGroup
+}
+
+func NewSandboxPool(newSandbox func() Sandbox, warm int) *SandboxPool {
+	return &SandboxPool{
+		New:         newSandbox,
+		Warm:        warm,
+		HealthCheck: DefaultSandboxHealthCheck,
+	}
+}
+
+// DefaultSandboxHealthCheck runs "true" in the sandbox.
+func DefaultSandboxHealthCheck(ctx context.Context, sb Sandbox) error {
+	res, err := sb.Exec(ctx, "true", ExecOptions{Timeout: 10 * time.Second})
+	if err == nil && res.ExitCode != 0 {
+		err = fmt.Errorf("health check exited %d", res.ExitCode)
+	}
+	return err
+}
+
+func (p *SandboxPool) init() {
+	if p.uses == nil {
+		p.uses = map[Sandbox]int{}
+		p.leased = map[Sandbox]bool{}
+		p.changed = make(chan struct{})
+	}
+}
+
+// Start fills the pool up to Warm, returning the first error.
+func (p *SandboxPool) Start(ctx context.Context) error {
+	for {
+		p.mu.Lock()
+		p.init()
+		if p.closed {
+			p.mu.Unlock()
+			return fmt.Errorf("SandboxPool: closed")
+		}
+		if len(p.idle) >= p.Warm || (p.Max > 0 && p.total >= p.Max) {
+			p.mu.Unlock()
+			return nil
+		}
+		p.total++
+		p.mu.Unlock()
+
+		sb, err := p.create(ctx)
+		if err != nil {
+			return err
+		}
+		p.putIdle(sb)
+	}
+}
+
+// Acquire returns a healthy started sandbox, waiting for one to be released
+// if the pool is at Max. Return it with Release, or Discard if it is broken.
+func (p *SandboxPool) Acquire(ctx context.Context) (Sandbox, error) {
+	for {
+		p.mu.Lock()
+		p.init()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, fmt.Errorf("SandboxPool: closed")
+		}
+
+		if n := len(p.idle); n > 0 {
+			sb := p.idle[n-1]
+			p.idle = p.idle[:n-1]
+			p.leased[sb] = true
+			p.mu.Unlock()
+
+			if p.HealthCheck != nil {
+				if err := p.HealthCheck(ctx, sb); err != nil {
+					if ctx.Err() != nil {
+						p.Release(sb)
+						return nil, ctx.Err()
+					}
+					p.Discard(sb)
+					continue
+				}
+			}
+			p.lease(sb)
+			return sb, nil
+		}
+
+		if p.Max == 0 || p.total < p.Max {
+			p.total++
+			p.mu.Unlock()
+
+			sb, err := p.create(ctx)
+			if err != nil {
+				return nil, err
+			}
+			p.mu.Lock()
+			p.leased[sb] = true
+			p.mu.Unlock()
+			p.lease(sb)
+			return sb, nil
+		}
+
+		changed := p.changed
+		p.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// lease counts an acquisition and tops the idle set back up.
+func (p *SandboxPool) lease(sb Sandbox) {
+	p.mu.Lock()
+	p.uses[sb]++
+	p.mu.Unlock()
+	go p.refill()
+}
+
+// Release returns sb to the pool, or stops it if it has reached MaxUses.
+func (p *SandboxPool) Release(sb Sandbox) {
+	p.mu.Lock()
+	if !p.leased[sb] {
+		p.mu.Unlock()
+		return
+	}
+	delete(p.leased, sb)
+	if p.closed || (p.MaxUses > 0 && p.uses[sb] >= p.MaxUses) {
+		done := p.beginStop()
+		p.mu.Unlock()
+		p.destroy(sb, done)
+		go p.refill()
+		return
+	}
+	p.idle = append(p.idle, sb)
+	p.signal()
+	p.mu.Unlock()
+}
+
+// Discard stops a leased sandbox instead of returning it, e.g. after it
+// has become unusable.
+func (p *SandboxPool) Discard(sb Sandbox) {
+	p.mu.Lock()
+	if !p.leased[sb] {
+		p.mu.Unlock()
+		return
+	}
+	delete(p.leased, sb)
+	done := p.beginStop()
+	p.mu.Unlock()
+	p.destroy(sb, done)
+	go p.refill()
+}
+
+// Close stops the idle sandboxes and makes Acquire fail. Leased sandboxes
+// are stopped as they are released.
+func (p *SandboxPool) Close(ctx context.Context) error {
+	p.mu.Lock()
+	p.init()
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.total -= len(idle)
+	p.signal()
+	p.mu.Unlock()
+
+	var errs []error
+	for _, sb := range idle {
+		errs = append(errs, sb.Stop(ctx))
+	}
+	p.stopping.Wait()
+	return errors.Join(errs...)
+}
+
+// WarmError returns the last error from starting a sandbox in the
+// background, if any.
+func (p *SandboxPool) WarmError() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.warmErr
+}
+
+// refill starts sandboxes in the background until Warm are idle.
+func (p *SandboxPool) refill() {
+	if err := p.Start(context.Background()); err != nil {
+		p.mu.Lock()
+		if !p.closed {
+			p.warmErr = err
+		}
+		p.mu.Unlock()
+	}
+}
+
+// create starts a new sandbox; the caller has already counted it in total.
+func (p *SandboxPool) create(ctx context.Context) (Sandbox, error) {
+	sb := p.New()
+	if err := sb.Start(ctx); err != nil {
+		sb.Stop(context.Background())
+		p.mu.Lock()
+		p.total--
+		p.signal()
+		p.mu.Unlock()
+		return nil, fmt.Errorf("SandboxPool: starting sandbox: %w", err)
+	}
+	return sb, nil
+}
+
+func (p *SandboxPool) putIdle(sb Sandbox) {
+	p.mu.Lock()
+	if p.closed {
+		done := p.beginStop()
+		p.mu.Unlock()
+		p.destroy(sb, done)
+		return
+	}
+	p.idle = append(p.idle, sb)
+	p.signal()
+	p.mu.Unlock()
+}
+
+// beginStop registers a sandbox stop that Close must wait for. It is
+// called under p.mu, so every stop counted before Close sets closed happens
+// before Close waits; stops begun after that run in the releasing caller and
+// are not counted. p.mu must be held.
+func (p *SandboxPool) beginStop() func() {
+	if p.closed {
+		return func() {}
+	}
+	p.stopping.Add(1)
+	return p.stopping.Done
+}
+
+// destroy stops sb and frees its slot; done comes from beginStop.
+func (p *SandboxPool) destroy(sb Sandbox, done func()) {
+	defer done()
+	sb.Stop(context.Background())
+
+	p.mu.Lock()
+	delete(p.uses, sb)
+	p.total--
+	p.signal()
+	p.mu.Unlock()
+}
+
+// signal wakes Acquire callers waiting for capacity. p.mu must be held.
+func (p *SandboxPool) signal() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// SandboxNode acquires a sandbox from Pool and stores it at Key, so tools
+// with a matching SandboxKey in this branch use it. Pair it with a
+// ReleaseSandboxNode at the end of the branch. If the flow fails, or ends
+// without reaching the ReleaseSandboxNode, the sandbox is released when
+// Flow.Run returns (see OnFlowExit).
+type SandboxNode struct {
+	BaseNode
+	Pool *SandboxPool
+	Key  string
+}
+
+func NewSandboxNode(pool *SandboxPool, key string) *SandboxNode {
+	return &SandboxNode{
+		BaseNode: NewBaseNode(),
+		Pool:     pool,
+		Key:      key,
+	}
+}
+
+func (n *SandboxNode) TypeName() string { return "SandboxNode" }
+
+func (n *SandboxNode) Run(ctx context.Context, mem *Memory) ([]Trigger, error) {
+	sb, err := n.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("SandboxNode: %w", err)
+	}
+
+	lease := &sandboxLease{pool: n.Pool, sb: sb}
+	OnFlowExit(ctx, lease.release)
+	mem.Local[n.Key] = sb
+	mem.Local[leaseKey(n.Key)] = lease
+
+	return []Trigger{
+		{Action: DefaultAction, ForkingData: map[string]any{}},
+	}, nil
+}
+
+// ReleaseSandboxNode returns the sandbox stored at Key to Pool.
+type ReleaseSandboxNode struct {
+	BaseNode
+	Pool *SandboxPool
+	Key  string
+}
+
+func NewReleaseSandboxNode(pool *SandboxPool, key string) *ReleaseSandboxNode {
+	return &ReleaseSandboxNode{
+		BaseNode: NewBaseNode(),
+		Pool:     pool,
+		Key:      key,
+	}
+}
+
+func (n *ReleaseSandboxNode) TypeName() string { return "ReleaseSandboxNode" }
+
+func (n *ReleaseSandboxNode) Run(ctx context.Context, mem *Memory) ([]Trigger, error) {
+	if lease, ok := mem.Local[leaseKey(n.Key)].(*sandboxLease); ok {
+		lease.release()
+	} else if sb, ok := mem.Local[n.Key].(Sandbox); ok {
+		n.Pool.Release(sb)
+	}
+	delete(mem.Local, n.Key)
+	delete(mem.Local, leaseKey(n.Key))
+
+	return []Trigger{
+		{Action: DefaultAction, ForkingData: map[string]any{}},
+	}, nil
+}
+
+// sandboxLease hands a sandbox acquired by SandboxNode back to its pool
+// once, from ReleaseSandboxNode or when the flow exits, whichever is first.
+// Releasing twice could return a sandbox someone else has since acquired.
+type sandboxLease struct {
+	once sync.Once
+	pool *SandboxPool
+	sb   Sandbox
+}
+
+func (l *sandboxLease) release() {
+	l.once.Do(func() { l.pool.Release(l.sb) })
+}
+
+func leaseKey(key string) string { return key + ".lease" }
@@ -0,0 +1,191 @@
+package nodechain
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSandbox is a Sandbox that runs nothing.
+type fakeSandbox struct {
+	mu      sync.Mutex
+	started bool
+	stopped bool
+}
+
+func (s *fakeSandbox) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.started = true
+	return nil
+}
+
+func (s *fakeSandbox) Exec(ctx context.Context, cmd string, opts ExecOptions) (ExecResult, error) {
+	return ExecResult{}, nil
+}
+
+func (s *fakeSandbox) CopyIn(ctx context.Context, hostPath, sandboxPath string) error  { return nil }
+func (s *fakeSandbox) CopyOut(ctx context.Context, sandboxPath, hostPath string) error { return nil }
+
+func (s *fakeSandbox) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+	return nil
+}
+
+// funcNode runs fn as its body.
+type funcNode struct {
+	BaseNode
+	fn func(ctx context.Context, mem *Memory) error
+}
+
+func newFuncNode(fn func(ctx context.Context, mem *Memory) error) *funcNode {
+	return &funcNode{BaseNode: NewBaseNode(), fn: fn}
+}
+
+func (n *funcNode) TypeName() string { return "funcNode" }
+
+func (n *funcNode) Run(ctx context.Context, mem *Memory) ([]Trigger, error) {
+	if err := n.fn(ctx, mem); err != nil {
+		return nil, err
+	}
+	return []Trigger{{Action: DefaultAction, ForkingData: map[string]any{}}}, nil
+}
+
+func newTestPool(t *testing.T, max int) *SandboxPool {
+	t.Helper()
+	pool := NewSandboxPool(func() Sandbox { return &fakeSandbox{} }, 0)
+	pool.Max = max
+	t.Cleanup(func() { pool.Close(context.Background()) })
+	return pool
+}
+
+func acquireWithin(pool *SandboxPool, d time.Duration) (Sandbox, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return pool.Acquire(ctx)
+}
+
+func TestSandboxNodeReleasesWhenFlowFails(t *testing.T) {
+	pool := newTestPool(t, 1)
+
+	acquire := NewSandboxNode(pool, "sandbox")
+	fail := newFuncNode(func(ctx context.Context, mem *Memory) error {
+		if _, ok := mem.Get("sandbox"); !ok {
+			t.Error("sandbox not in memory")
+		}
+		return errors.New("boom")
+	})
+	release := NewReleaseSandboxNode(pool, "sandbox")
+	acquire.On(DefaultAction, fail)
+	fail.On(DefaultAction, release)
+
+	if _, err := NewFlow(acquire).Run(context.Background(), nil); err == nil {
+		t.Fatal("flow succeeded")
+	}
+	if _, err := acquireWithin(pool, time.Second); err != nil {
+		t.Fatalf("sandbox leaked after a failed flow: %v", err)
+	}
+}
+
+func TestSandboxNodeReleasesOnce(t *testing.T) {
+	pool := newTestPool(t, 1)
+
+	var other Sandbox
+	acquire := NewSandboxNode(pool, "sandbox")
+	release := NewReleaseSandboxNode(pool, "sandbox")
+	// someone else leases the sandbox between the release and the flow's exit
+	reacquire := newFuncNode(func(ctx context.Context, mem *Memory) error {
+		var err error
+		other, err = acquireWithin(pool, time.Second)
+		return err
+	})
+	acquire.On(DefaultAction, release)
+	release.On(DefaultAction, reacquire)
+
+	if _, err := NewFlow(acquire).Run(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if other == nil {
+		t.Fatal("sandbox was not released by ReleaseSandboxNode")
+	}
+	// the flow's exit must not have released the other holder's lease
+	if sb, err := acquireWithin(pool, 50*time.Millisecond); err == nil {
+		t.Fatalf("sandbox released twice; acquired %p while still leased", sb)
+	}
+	pool.Release(other)
+}
+
+func TestSandboxPoolMaxUsesAndClose(t *testing.T) {
+	pool := newTestPool(t, 2)
+	pool.MaxUses = 1
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				sb, err := acquireWithin(pool, time.Second)
+				if err != nil {
+					return // closed
+				}
+				pool.Release(sb)
+			}
+		}()
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err := pool.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	if _, err := pool.Acquire(context.Background()); err == nil {
+		t.Fatal("Acquire succeeded on a closed pool")
+	}
+}
+
+func TestOnFlowExitOutsideFlow(t *testing.T) {
+	if OnFlowExit(context.Background(), func() {}) {
+		t.Fatal("OnFlowExit registered without a flow")
+	}
+}
+
+func TestToolsRequireSandboxAtKey(t *testing.T) {
+	shared := startLocalSandbox(t)
+	tools := []struct {
+		tool  MemoryTool
+		input any
+	}{
+		{&DockerExecTool{Sandbox: shared, SandboxKey: "sandbox"}, "true"},
+		{&ReadFileTool{Sandbox: shared, SandboxKey: "sandbox"}, map[string]any{"path": "f"}},
+		{&WriteFileTool{Sandbox: shared, SandboxKey: "sandbox"}, map[string]any{"path": "f", "content": "x"}},
+		{&ListDirTool{Sandbox: shared, SandboxKey: "sandbox"}, map[string]any{"path": "."}},
+		{&PythonExecTool{Sandbox: shared, SandboxKey: "sandbox"}, "1"},
+		{&SnapshotTool{Sandbox: shared, SandboxKey: "sandbox"}, map[string]any{}},
+	}
+
+	tests := []struct {
+		name    string
+		mem     *Memory
+		wantErr string
+	}{
+		{"released sandbox", NewMemory(map[string]any{}), "no sandbox at key 'sandbox'"},
+		{"no memory", nil, "no sandbox at key 'sandbox'"},
+		{"wrong type", NewMemory(map[string]any{"sandbox": "box"}), "is not a Sandbox"},
+	}
+	for _, tt := range tests {
+		for _, tc := range tools {
+			t.Run(tt.name+"/"+tc.tool.Name(), func(t *testing.T) {
+				_, err := tc.tool.RunWithMemory(context.Background(), tt.mem, tc.input)
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+				}
+			})
+		}
+	}
+}
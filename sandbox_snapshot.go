@@ -0,0 +1,507 @@
+package nodechain
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SandboxSnapshot is a saved state of a sandbox that it can be rolled back
+// to. The workspace is always captured; Image is set when the container's
+// own filesystem was committed too.
+type SandboxSnapshot struct {
+	ID      string    `json:"id"`
+	Label   string    `json:"label,omitempty"`
+	Created time.Time `json:"created"`
+	Image   string    `json:"image,omitempty"`
+
+	archive string // workspace tarball on the host
+}
+
+// internalSnapshotPrefix starts the labels of snapshots the library takes
+// for itself, such as RetryNode's. They are not the "" snapshot and are
+// hidden from agents.
+const internalSnapshotPrefix = "nodechain:"
+
+func (s SandboxSnapshot) internal() bool {
+	return strings.HasPrefix(s.Label, internalSnapshotPrefix)
+}
+
+// Snapshotter is a Sandbox that can save its state and roll back to it.
+// Restore and DeleteSnapshot take a snapshot ID or label; a label refers to
+// the most recent snapshot with it, and "" to the most recent snapshot not
+// taken internally (by RetryNode).
+// Snapshots live until they are deleted or the sandbox is stopped.
+type Snapshotter interface {
+	Sandbox
+	Snapshot(ctx context.Context, label string) (SandboxSnapshot, error)
+	Restore(ctx context.Context, ref string) error
+	DeleteSnapshot(ctx context.Context, ref string) error
+	Snapshots() []SandboxSnapshot
+}
+
+// ErrNoSnapshot is returned when a snapshot reference matches nothing.
+var ErrNoSnapshot = errors.New("no such snapshot")
+
+// snapshotStore keeps a sandbox's workspace archives in a private temp
+// directory. It is safe for concurrent use.
+type snapshotStore struct {
+	mu    sync.Mutex
+	dir   string
+	snaps []SandboxSnapshot
+	seq   int
+}
+
+// save archives workspace as a new snapshot. prepare, if set, runs before
+// the snapshot is recorded and may fill in other fields such as Image.
+func (st *snapshotStore) save(workspace, label string, prepare func(*SandboxSnapshot) error) (SandboxSnapshot, error) {
+	st.mu.Lock()
+	if st.dir == "" {
+		dir, err := os.MkdirTemp("", "nodechain-snapshots-")
+		if err != nil {
+			st.mu.Unlock()
+			return SandboxSnapshot{}, err
+		}
+		st.dir = dir
+	}
+	st.seq++
+	snap := SandboxSnapshot{
+		ID:      "snap-" + strconv.Itoa(st.seq),
+		Label:   label,
+		Created: time.Now(),
+	}
+	snap.archive = filepath.Join(st.dir, snap.ID+".tar.gz")
+	st.mu.Unlock()
+
+	if prepare != nil {
+		if err := prepare(&snap); err != nil {
+			return SandboxSnapshot{}, err
+		}
+	}
+	if err := archiveDir(workspace, snap.archive); err != nil {
+		os.Remove(snap.archive)
+		return snap, fmt.Errorf("archiving workspace: %w", err)
+	}
+
+	st.mu.Lock()
+	st.snaps = append(st.snaps, snap)
+	st.mu.Unlock()
+	return snap, nil
+}
+
+func (st *snapshotStore) find(ref string) (SandboxSnapshot, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if i := st.index(ref); i >= 0 {
+		return st.snaps[i], nil
+	}
+	return SandboxSnapshot{}, st.notFound(ref)
+}
+
+// remove forgets a snapshot and deletes its archive.
+func (st *snapshotStore) remove(ref string) (SandboxSnapshot, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	i := st.index(ref)
+	if i < 0 {
+		return SandboxSnapshot{}, st.notFound(ref)
+	}
+	snap := st.snaps[i]
+	st.snaps = append(st.snaps[:i], st.snaps[i+1:]...)
+	os.Remove(snap.archive)
+	return snap, nil
+}
+
+// clear deletes every snapshot's archive and returns the snapshots.
+func (st *snapshotStore) clear() []SandboxSnapshot {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	snaps := st.snaps
+	st.snaps = nil
+	if st.dir != "" {
+		os.RemoveAll(st.dir)
+		st.dir = ""
+	}
+	return snaps
+}
+
+func (st *snapshotStore) list() []SandboxSnapshot {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return append([]SandboxSnapshot(nil), st.snaps...)
+}
+
+// index returns the position of the newest snapshot matching ref, or -1.
+// st.mu must be held.
+func (st *snapshotStore) index(ref string) int {
+	return latestSnapshot(st.snaps, ref)
+}
+
+// latestSnapshot returns the position of the newest snapshot in snaps
+// matching ref, or -1. "" matches any snapshot that is not internal.
+func latestSnapshot(snaps []SandboxSnapshot, ref string) int {
+	for i := len(snaps) - 1; i >= 0; i-- {
+		s := snaps[i]
+		if ref == "" && !s.internal() || s.ID == ref || s.Label == ref {
+			return i
+		}
+	}
+	return -1
+}
+
+func (st *snapshotStore) notFound(ref string) error {
+	if ref == "" {
+		return ErrNoSnapshot
+	}
+	return fmt.Errorf("%w %q", ErrNoSnapshot, ref)
+}
+
+// archiveDir writes the tree under dir to a gzipped tarball at file,
+// keeping modes, ownership and symlinks.
+func archiveDir(dir, file string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	zw, _ := gzip.NewWriterLevel(f, gzip.BestSpeed)
+	tw := tar.NewWriter(zw)
+
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == dir {
+			return nil
+		}
+		if d.IsDir() && filepath.Dir(p) == dir && strings.HasPrefix(d.Name(), restorePrefix) {
+			return fs.SkipDir // left behind by an interrupted restore
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var link string
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		case !info.IsDir() && !info.Mode().IsRegular():
+			return nil // sockets, fifos and devices are not restorable
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		hdr.Name = filepath.ToSlash(rel)
+		hdr.Format = tar.FormatPAX // keeps sub-second mtimes; ustar rounds them
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			in, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer in.Close()
+			if _, err := io.CopyN(tw, in, hdr.Size); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if cerr := tw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// restorePrefix names the scratch directories a restore creates inside
+// the workspace; snapshots skip them.
+const restorePrefix = ".nodechain-restore-"
+
+// restoreDir replaces the contents of dir with the tarball at file. The
+// workspace is only touched once the whole snapshot has been extracted.
+func restoreDir(dir, file string) error {
+	r, err := stageRestore(dir, file)
+	if err != nil {
+		return err
+	}
+	if err := r.swap(); err != nil {
+		r.abort()
+		return err
+	}
+	r.finish()
+	return nil
+}
+
+// workspaceRestore is a snapshot extracted into a scratch directory inside
+// the workspace, so swapping it in is a few renames on one filesystem even
+// when the workspace is a mount point.
+type workspaceRestore struct {
+	dir     string
+	staging string // the extracted snapshot
+	old     string // the replaced contents, kept until finish
+	swapped bool
+}
+
+// stageRestore extracts the tarball at file next to the live contents of
+// dir. A corrupt archive fails here, before anything in dir changes.
+// Ownership is restored when running as root.
+func stageRestore(dir, file string) (*workspaceRestore, error) {
+	staging, err := os.MkdirTemp(dir, restorePrefix)
+	if err != nil {
+		return nil, err
+	}
+	r := &workspaceRestore{dir: dir, staging: staging}
+	if err := extractArchive(staging, file); err != nil {
+		r.abort()
+		return nil, fmt.Errorf("extracting snapshot: %w", err)
+	}
+	return r, nil
+}
+
+// swap moves the live contents of dir aside and the staged snapshot into
+// place. If a rename fails, dir is put back as it was.
+func (r *workspaceRestore) swap() error {
+	old, err := os.MkdirTemp(r.dir, restorePrefix)
+	if err != nil {
+		return err
+	}
+	r.old = old
+
+	live, err := moveEntries(r.dir, r.old)
+	if err != nil {
+		moveNames(r.old, r.dir, live)
+		return err
+	}
+	staged, err := moveEntries(r.staging, r.dir)
+	if err != nil {
+		moveNames(r.dir, r.staging, staged)
+		moveNames(r.old, r.dir, live)
+		return err
+	}
+	r.swapped = true
+	return nil
+}
+
+// undo puts the contents swap replaced back.
+func (r *workspaceRestore) undo() error {
+	if !r.swapped {
+		return nil
+	}
+	staged, err := moveEntries(r.dir, r.staging)
+	if err != nil {
+		moveNames(r.staging, r.dir, staged)
+		return err
+	}
+	if _, err := moveEntries(r.old, r.dir); err != nil {
+		return err
+	}
+	r.swapped = false
+	return nil
+}
+
+// finish deletes the replaced contents and the scratch directories.
+func (r *workspaceRestore) finish() {
+	removeTree(r.staging)
+	if r.old != "" {
+		removeTree(r.old)
+	}
+}
+
+// abort gives up on a restore that was not swapped in, or was undone.
+func (r *workspaceRestore) abort() {
+	r.finish()
+}
+
+// moveEntries renames every entry of from into to, except the restore's
+// own scratch directories, and returns the names moved.
+func moveEntries(from, to string) ([]string, error) {
+	entries, err := os.ReadDir(from)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), restorePrefix) {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	return moveNames(from, to, names)
+}
+
+func moveNames(from, to string, names []string) ([]string, error) {
+	for i, name := range names {
+		if err := os.Rename(filepath.Join(from, name), filepath.Join(to, name)); err != nil {
+			return names[:i], err
+		}
+	}
+	return names, nil
+}
+
+// removeTree deletes p, first making its directories writable so
+// read-only ones do not stop RemoveAll.
+func removeTree(p string) error {
+	filepath.WalkDir(p, func(file string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			os.Chmod(file, 0o700)
+		}
+		return nil
+	})
+	return os.RemoveAll(p)
+}
+
+// extractArchive unpacks a tarball written by archiveDir into the empty
+// directory dir.
+func extractArchive(dir, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+
+	chown := os.Geteuid() == 0
+	type dirMode struct {
+		path  string
+		mode  fs.FileMode
+		mtime time.Time
+	}
+	var dirs []dirMode // applied last, so read-only directories can be filled
+
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if !within(dir, target) || target == dir {
+			return fmt.Errorf("snapshot entry %q escapes the workspace", hdr.Name)
+		}
+		mode := hdr.FileInfo().Mode()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o700); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirMode{target, mode.Perm(), hdr.ModTime})
+		case tar.TypeSymlink:
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL|oNoFollow, 0o600)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close()
+				return err
+			}
+			if err := out.Close(); err != nil {
+				return err
+			}
+			if err := os.Chmod(target, mode.Perm()); err != nil {
+				return err
+			}
+			os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+		default:
+			continue
+		}
+		if chown {
+			os.Lchown(target, hdr.Uid, hdr.Gid)
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Chmod(dirs[i].path, dirs[i].mode)
+		os.Chtimes(dirs[i].path, dirs[i].mtime, dirs[i].mtime)
+	}
+	return nil
+}
+
+// snapshotterFromMemory resolves the sandbox at key (or fallback, when key
+// is empty) and checks that it supports snapshots.
+func snapshotterFromMemory(mem *Memory, key string, fallback Sandbox) (Snapshotter, error) {
+	sb, err := sandboxFromMemory(mem, key, fallback)
+	if err != nil {
+		return nil, err
+	}
+	if sb == nil {
+		return nil, fmt.Errorf("no sandbox")
+	}
+	snap, ok := sb.(Snapshotter)
+	if !ok {
+		return nil, fmt.Errorf("sandbox %T does not support snapshots", sb)
+	}
+	return snap, nil
+}
+
+// SnapshotNode snapshots the sandbox at this point in the flow and stores
+// the snapshot's ID at Key, for a later rollback.
+type SnapshotNode struct {
+	BaseNode
+	Sandbox    Sandbox
+	SandboxKey string // memory key holding the sandbox; Sandbox is used when it is empty
+	Label      string
+	Key        string
+}
+
+func NewSnapshotNode(sb Sandbox, label, key string) *SnapshotNode {
+	return &SnapshotNode{
+		BaseNode: NewBaseNode(),
+		Sandbox:  sb,
+		Label:    label,
+		Key:      key,
+	}
+}
+
+func (n *SnapshotNode) TypeName() string { return "SnapshotNode" }
+
+func (n *SnapshotNode) Run(ctx context.Context, mem *Memory) ([]Trigger, error) {
+	sb, err := snapshotterFromMemory(mem, n.SandboxKey, n.Sandbox)
+	if err != nil {
+		return nil, fmt.Errorf("SnapshotNode: %w", err)
+	}
+	snap, err := sb.Snapshot(ctx, n.Label)
+	if err != nil {
+		return nil, fmt.Errorf("SnapshotNode: %w", err)
+	}
+
+	if n.Key != "" {
+		mem.Local[n.Key] = snap.ID
+	}
+
+	return []Trigger{
+		{Action: DefaultAction, ForkingData: map[string]any{}},
+	}, nil
+}
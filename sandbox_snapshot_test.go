@@ -0,0 +1,200 @@
+package nodechain
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeTree creates files (path -> content) under dir.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readTree returns every regular file under dir as path -> content.
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	out := map[string]string{}
+	err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			data, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(dir, p)
+			out[filepath.ToSlash(rel)] = string(data)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func sameTree(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRestoreDirRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	saved := map[string]string{"a.txt": "a", "sub/b.txt": "b", "sub/deep/c.txt": "c"}
+	writeTree(t, dir, saved)
+	if err := os.Chmod(filepath.Join(dir, "sub", "deep"), 0o555); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(t.TempDir(), "snap.tar.gz")
+	if err := archiveDir(dir, archive); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Chmod(filepath.Join(dir, "sub", "deep"), 0o755)
+	writeTree(t, dir, map[string]string{"a.txt": "changed", "new.txt": "new"})
+	os.RemoveAll(filepath.Join(dir, "sub"))
+
+	if err := restoreDir(dir, archive); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTree(t, dir); !sameTree(got, saved) {
+		t.Fatalf("restored %v, want %v", got, saved)
+	}
+	info, err := os.Stat(filepath.Join(dir, "sub", "deep"))
+	if err != nil || info.Mode().Perm() != 0o555 {
+		t.Fatalf("directory mode not restored: %v, %v", info, err)
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), restorePrefix) {
+			t.Fatalf("scratch directory %s left behind", e.Name())
+		}
+	}
+}
+
+func TestRestoreDirCorruptArchiveKeepsWorkspace(t *testing.T) {
+	dir := t.TempDir()
+	live := map[string]string{"keep.txt": "live", "sub/x.txt": "x"}
+	writeTree(t, dir, live)
+
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"big.txt": strings.Repeat("data ", 1<<16)})
+	archive := filepath.Join(t.TempDir(), "snap.tar.gz")
+	if err := archiveDir(src, archive); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(archive)
+	if err := os.WriteFile(archive, data[:len(data)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := restoreDir(dir, archive); err == nil {
+		t.Fatal("restoring a truncated archive succeeded")
+	}
+	if got := readTree(t, dir); !sameTree(got, live) {
+		t.Fatalf("workspace changed by a failed restore: %v", got)
+	}
+}
+
+func TestWorkspaceRestoreUndo(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"saved.txt": "saved"})
+	archive := filepath.Join(t.TempDir(), "snap.tar.gz")
+	if err := archiveDir(dir, archive); err != nil {
+		t.Fatal(err)
+	}
+	live := map[string]string{"live.txt": "live", "sub/y.txt": "y"}
+	os.Remove(filepath.Join(dir, "saved.txt"))
+	writeTree(t, dir, live)
+
+	r, err := stageRestore(dir, archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.swap(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTree(t, dir); got["saved.txt"] != "saved" || got["live.txt"] != "" {
+		t.Fatalf("after swap: %v", got)
+	}
+	if err := r.undo(); err != nil {
+		t.Fatal(err)
+	}
+	r.finish()
+
+	if got := readTree(t, dir); !sameTree(got, live) {
+		t.Fatalf("after undo: %v, want %v", got, live)
+	}
+}
+
+func TestSnapshotStoreRefs(t *testing.T) {
+	var st snapshotStore
+	t.Cleanup(func() { st.clear() })
+	dir := t.TempDir()
+
+	var ids []string
+	for _, label := range []string{"a", "b", "a", internalSnapshotPrefix + "retry"} {
+		snap, err := st.save(dir, label, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, snap.ID)
+	}
+
+	tests := []struct {
+		ref  string
+		want string // "" means not found
+	}{
+		{"", ids[2]}, // skips the internal snapshot
+		{internalSnapshotPrefix + "retry", ids[3]},
+		{"a", ids[2]},
+		{"b", ids[1]},
+		{ids[0], ids[0]},
+		{"missing", ""},
+	}
+	for _, tt := range tests {
+		snap, err := st.find(tt.ref)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("find(%q) = %s, want error", tt.ref, snap.ID)
+			}
+			continue
+		}
+		if err != nil || snap.ID != tt.want {
+			t.Errorf("find(%q) = %s, %v; want %s", tt.ref, snap.ID, err, tt.want)
+		}
+	}
+
+	if _, err := st.remove("a"); err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	for _, s := range st.list() {
+		left = append(left, s.ID)
+	}
+	sort.Strings(left)
+	if strings.Join(left, ",") != ids[0]+","+ids[1]+","+ids[3] {
+		t.Fatalf("after remove: %v", left)
+	}
+}
@@ -0,0 +1,63 @@
+package nodechain
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveIn(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "sub", "file"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"dangling": filepath.Join(outside, "missing"),
+		"escape":   outside,
+		"inner":    filepath.Join(root, "sub", "file"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skipf("symlinks unsupported: %v", err)
+		}
+	}
+
+	tests := []struct {
+		path    string
+		want    string // relative to root; "" means an error
+		errText string
+	}{
+		{path: "/workspace", want: "."},
+		{path: "/workspace/sub/file", want: "sub/file"},
+		{path: "sub/new", want: "sub/new"},
+		{path: "/sub/file", want: "sub/file"},
+		{path: "/workspace/../etc/passwd", errText: "escapes"},
+		{path: "../x", errText: "escapes"},
+		{path: "escape/file", errText: "symlink"},
+		{path: "dangling", errText: "symlink"},
+		{path: "escape", errText: "symlink"},
+		{path: "inner", errText: "symlink"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := resolveIn(root, tt.path)
+			if tt.errText != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errText) {
+					t.Fatalf("resolveIn(%q) = %q, %v; want error containing %q", tt.path, got, err, tt.errText)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveIn(%q): %v", tt.path, err)
+			}
+			if want := filepath.Join(root, filepath.FromSlash(tt.want)); got != want {
+				t.Fatalf("resolveIn(%q) = %q, want %q", tt.path, got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,394 @@
+package nodechain
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ShellSandbox is a Sandbox that can run a long-lived process with piped
+// stdio. Cancelling ctx kills the process and its children.
+type ShellSandbox interface {
+	Sandbox
+	Command(ctx context.Context, argv ...string) (*exec.Cmd, error)
+}
+
+// ShellSession keeps one shell running in a sandbox, so the working
+// directory, variables and activated virtualenvs carry over from one Exec to
+// the next. Each command's output is delimited by a random sentinel, and its
+// stdin is /dev/null.
+//
+// If the shell dies (the command ran exit, or was killed by its timeout),
+// the next Exec starts a new one and restores the last working directory and
+// exported variables; unexported variables and functions are lost.
+//
+// ShellSession is itself a Sandbox: Exec runs in the shell and the other
+// methods are delegated, so it can stand in for the sandbox in tools.
+// Commands run one at a time.
+type ShellSession struct {
+	Sandbox ShellSandbox
+	Shell   []string // default bash -l
+
+	mu       sync.Mutex
+	proc     *shellProc
+	id       string
+	cwd      string
+	restarts int
+}
+
+func NewShellSession(sb ShellSandbox) *ShellSession {
+	return &ShellSession{
+		Sandbox: sb,
+		Shell:   []string{"bash", "-l"},
+	}
+}
+
+// shellProc is one running shell.
+type shellProc struct {
+	cmd    *exec.Cmd
+	cancel context.CancelFunc
+	stdin  io.WriteCloser
+	stdout *shellStream
+	stderr *shellStream
+	dead   chan struct{} // closed when the shell's stdout ends
+}
+
+func (s *ShellSession) Start(ctx context.Context) error {
+	if err := s.Sandbox.Start(ctx); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ensure()
+}
+
+func (s *ShellSession) Stop(ctx context.Context) error {
+	s.Close()
+	return s.Sandbox.Stop(ctx)
+}
+
+func (s *ShellSession) CopyIn(ctx context.Context, hostPath, sandboxPath string) error {
+	return s.Sandbox.CopyIn(ctx, hostPath, sandboxPath)
+}
+
+func (s *ShellSession) CopyOut(ctx context.Context, sandboxPath, hostPath string) error {
+	return s.Sandbox.CopyOut(ctx, sandboxPath, hostPath)
+}
+
+// Close ends the shell but leaves the sandbox running.
+func (s *ShellSession) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.proc == nil {
+		return
+	}
+	p := s.proc
+	s.proc = nil
+
+	io.WriteString(p.stdin, "rm -f "+s.stateFile()+"\nexit 0\n")
+	p.stdin.Close()
+	select {
+	case <-p.dead:
+	case <-time.After(time.Second):
+	}
+	p.kill()
+}
+
+// Restarts returns how many times the shell has been restarted after dying.
+func (s *ShellSession) Restarts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.restarts
+}
+
+func (s *ShellSession) Exec(ctx context.Context, cmdStr string, opts ExecOptions) (ExecResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sentinel := "__NODECHAIN_" + randomSuffix() + "__"
+
+	// The trailing newline after the command keeps a final comment from
+	// swallowing the closing brace. $PWD is reported so a restarted shell
+	// can return to it.
+	script := "{ eval " + shellQuote(cmdStr) + "\n} </dev/null\n" +
+		"__nc_rc=$?\n" +
+		"export -p >" + s.stateFile() + " 2>/dev/null\n" +
+		"printf '\\n%s %d %s\\n' " + sentinel + " \"$__nc_rc\" \"$PWD\"\n" +
+		"printf '\\n%s\\n' " + sentinel + " >&2\n"
+
+	var p *shellProc
+	for attempt := 0; ; attempt++ {
+		if err := s.ensure(); err != nil {
+			return ExecResult{}, err
+		}
+		p = s.proc
+		p.stdout.arm(sentinel, opts.maxOutput())
+		p.stderr.arm(sentinel, opts.maxOutput())
+
+		_, err := io.WriteString(p.stdin, script)
+		if err == nil {
+			break
+		}
+		s.discard()
+		// the shell was killed (e.g. by a sandbox Restore) before its exit
+		// was noticed; the command never reached it, so retry in a new one
+		if attempt > 0 {
+			return ExecResult{}, fmt.Errorf("shell session: %w", err)
+		}
+	}
+
+	var timeout <-chan time.Time
+	if t := opts.timeout(); t > 0 {
+		timer := time.NewTimer(t)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var status string
+	var out, errOut *cappedBuffer
+	for out == nil || errOut == nil {
+		select {
+		case d := <-p.stdout.done:
+			out, status = d.buf, d.rest
+		case d := <-p.stderr.done:
+			errOut = d.buf
+		case <-p.dead:
+			// the command ended the shell; report what it printed
+			s.proc = nil
+			p.cancel()
+			res := s.collect(p)
+			var exitErr *exec.ExitError
+			if err := p.cmd.Wait(); errors.As(err, &exitErr) {
+				res.ExitCode = exitErr.ExitCode()
+			} else if err == nil {
+				res.ExitCode = 0
+			}
+			return res, nil
+		case <-timeout:
+			res := s.abandon(p)
+			res.TimedOut = true
+			return res, nil
+		case <-ctx.Done():
+			res := s.abandon(p)
+			return res, ctx.Err()
+		}
+	}
+
+	res := ExecResult{
+		Stdout:    out.String(),
+		Stderr:    errOut.String(),
+		Truncated: out.Truncated() || errOut.Truncated(),
+	}
+	rc, cwd, _ := strings.Cut(status, " ")
+	res.ExitCode, _ = strconv.Atoi(rc)
+	if cwd != "" {
+		s.cwd = cwd
+	}
+	return res, nil
+}
+
+// ensure starts a shell if there is none or the last one died. s.mu must be
+// held.
+func (s *ShellSession) ensure() error {
+	if s.proc != nil {
+		select {
+		case <-s.proc.dead:
+			s.discard()
+		default:
+			return nil
+		}
+	}
+
+	if s.id == "" {
+		s.id = randomSuffix()
+	}
+	shell := s.Shell
+	if len(shell) == 0 {
+		shell = []string{"bash", "-l"}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd, err := s.Sandbox.Command(ctx, shell...)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("shell session: %w", err)
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		cancel()
+		return err
+	}
+	stdoutPipe, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return err
+	}
+	stderrPipe, err := cmd.StderrPipe()
+	if err != nil {
+		cancel()
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return fmt.Errorf("shell session: starting shell: %w", err)
+	}
+
+	p := &shellProc{
+		cmd:    cmd,
+		cancel: cancel,
+		stdin:  stdin,
+		stdout: newShellStream(),
+		stderr: newShellStream(),
+		dead:   make(chan struct{}),
+	}
+	go func() {
+		p.stdout.read(stdoutPipe)
+		close(p.dead)
+	}()
+	go p.stderr.read(stderrPipe)
+
+	// a restarted shell picks up where the last one left off
+	if s.cwd != "" {
+		s.restarts++
+		restore := "cd " + shellQuote(s.cwd) + " 2>/dev/null\n" +
+			"[ -f " + s.stateFile() + " ] && . " + s.stateFile() + " 2>/dev/null\n"
+		if _, err := io.WriteString(stdin, restore); err != nil {
+			p.kill()
+			return fmt.Errorf("shell session: %w", err)
+		}
+	}
+
+	s.proc = p
+	return nil
+}
+
+// stateFile is where the shell saves its exported variables after each
+// command, for restoring after a restart. It is a double-quoted shell word.
+func (s *ShellSession) stateFile() string {
+	return `"${TMPDIR:-/tmp}/.nodechain-shell-` + s.id + `.env"`
+}
+
+// abandon kills a shell whose command overran and returns what it printed.
+// s.mu must be held.
+func (s *ShellSession) abandon(p *shellProc) ExecResult {
+	s.discard()
+	return s.collect(p)
+}
+
+// collect returns the output of a command that did not reach its sentinel.
+func (s *ShellSession) collect(p *shellProc) ExecResult {
+	res := ExecResult{ExitCode: -1}
+	var truncated bool
+	res.Stdout, res.Truncated = p.stdout.partial()
+	res.Stderr, truncated = p.stderr.partial()
+	res.Truncated = res.Truncated || truncated
+	return res
+}
+
+// discard kills the current shell and forgets it. s.mu must be held.
+func (s *ShellSession) discard() {
+	if s.proc != nil {
+		s.proc.kill()
+		s.proc = nil
+	}
+}
+
+func (p *shellProc) kill() {
+	p.cancel()
+	go p.cmd.Wait()
+}
+
+// shellStream reads one of the shell's output streams, copying lines into
+// the armed buffer until the sentinel line appears.
+type shellStream struct {
+	mu        sync.Mutex
+	buf       *cappedBuffer
+	sentinel  []byte
+	pendingNL bool // newline held back in case it is the one before the sentinel
+	done      chan shellDone
+}
+
+type shellDone struct {
+	buf  *cappedBuffer
+	rest string // the sentinel line after the sentinel
+}
+
+func newShellStream() *shellStream {
+	return &shellStream{done: make(chan shellDone, 1)}
+}
+
+func (st *shellStream) arm(sentinel string, max int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.buf = newCappedBuffer(max)
+	st.sentinel = []byte(sentinel)
+	st.pendingNL = false
+	select {
+	case <-st.done: // stale result from an abandoned command
+	default:
+	}
+}
+
+// partial detaches the buffer of a command that did not finish.
+func (st *shellStream) partial() (string, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.buf == nil {
+		return "", false
+	}
+	buf := st.buf
+	st.buf, st.sentinel = nil, nil
+	return buf.String(), buf.Truncated()
+}
+
+func (st *shellStream) read(r io.Reader) {
+	br := bufio.NewReaderSize(r, 64<<10)
+	atLineStart := true
+	for {
+		line, err := br.ReadSlice('\n')
+		if len(line) > 0 {
+			st.consume(line, atLineStart)
+			atLineStart = line[len(line)-1] == '\n'
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			return
+		}
+	}
+}
+
+func (st *shellStream) consume(line []byte, atLineStart bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if atLineStart && st.sentinel != nil && bytes.HasPrefix(line, st.sentinel) {
+		// the held-back newline was the one printed before the sentinel
+		st.done <- shellDone{
+			buf:  st.buf,
+			rest: strings.TrimSpace(string(line[len(st.sentinel):])),
+		}
+		st.buf, st.sentinel, st.pendingNL = nil, nil, false
+		return
+	}
+	if st.buf == nil {
+		return // output from background jobs between commands
+	}
+
+	if st.pendingNL {
+		st.buf.Write([]byte{'\n'})
+		st.pendingNL = false
+	}
+	if line[len(line)-1] == '\n' {
+		st.buf.Write(line[:len(line)-1])
+		st.pendingNL = true
+	} else {
+		st.buf.Write(line)
+	}
+}
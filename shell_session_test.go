@@ -0,0 +1,85 @@
+//go:build unix
+
+package nodechain
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShellSessionKeepsState(t *testing.T) {
+	shell := NewShellSession(startLocalSandbox(t))
+	defer shell.Close()
+	ctx := context.Background()
+
+	steps := []struct {
+		cmd        string
+		wantStdout string
+		wantExit   int
+	}{
+		{"mkdir -p sub && cd sub", "", 0},
+		{"export GREETING=hi; LOCAL=x", "", 0},
+		{`basename "$PWD"; echo "$GREETING $LOCAL"`, "sub\nhi x\n", 0},
+		{"false", "", 1},
+		{"echo out; echo err >&2", "out\n", 0},
+		{"echo no newline at end # trailing comment", "no newline at end\n", 0},
+	}
+	for _, st := range steps {
+		res, err := shell.Exec(ctx, st.cmd, ExecOptions{})
+		if err != nil {
+			t.Fatalf("%q: %v", st.cmd, err)
+		}
+		if res.Stdout != st.wantStdout || res.ExitCode != st.wantExit {
+			t.Fatalf("%q: got %+v, want stdout %q exit %d", st.cmd, res, st.wantStdout, st.wantExit)
+		}
+	}
+	if shell.Restarts() != 0 {
+		t.Fatalf("shell restarted %d times", shell.Restarts())
+	}
+}
+
+func TestShellSessionRestartsAfterExit(t *testing.T) {
+	shell := NewShellSession(startLocalSandbox(t))
+	defer shell.Close()
+	ctx := context.Background()
+
+	for _, cmd := range []string{"mkdir -p sub && cd sub", "export KEPT=yes; UNEXPORTED=gone"} {
+		if _, err := shell.Exec(ctx, cmd, ExecOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	res, err := shell.Exec(ctx, "exit 3", ExecOptions{})
+	if err != nil || res.ExitCode != 3 {
+		t.Fatalf("exit: %+v, %v", res, err)
+	}
+
+	res, err = shell.Exec(ctx, `basename "$PWD"; echo "$KEPT/$UNEXPORTED"`, ExecOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Stdout != "sub\nyes/\n" {
+		t.Fatalf("restarted shell: %q", res.Stdout)
+	}
+	if shell.Restarts() != 1 {
+		t.Fatalf("Restarts() = %d, want 1", shell.Restarts())
+	}
+}
+
+func TestShellSessionTimeout(t *testing.T) {
+	shell := NewShellSession(startLocalSandbox(t))
+	defer shell.Close()
+
+	res, err := shell.Exec(context.Background(), "echo started; sleep 30", ExecOptions{Timeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.TimedOut || !strings.Contains(res.Stdout, "started") {
+		t.Fatalf("got %+v", res)
+	}
+	res, err = shell.Exec(context.Background(), "echo alive", ExecOptions{})
+	if err != nil || res.Stdout != "alive\n" {
+		t.Fatalf("after timeout: %+v, %v", res, err)
+	}
+}
@@ -0,0 +1,126 @@
+package nodechain
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+const snapshotFormat = "nodechain-vectors"
+
+// SnapshotHeader is the first line of a vector snapshot. It is followed by
+// one JSON-encoded Document per line.
+type SnapshotHeader struct {
+	Format    string `json:"format"`
+	Version   int    `json:"version"`
+	Embedder  string `json:"embedder,omitempty"`
+	Dimension int    `json:"dimension"`
+	Count     int    `json:"count"`
+}
+
+// ExportJSONL writes docs as a snapshot: a header line, then one document
+// per line. Every document must have an embedding of the header's
+// dimension. Note that JSON does not preserve metadata value types (ints are
+// read back as float64).
+func ExportJSONL(w io.Writer, embedder string, dimension int, docs []Document) error {
+	for _, d := range docs {
+		if len(d.Embedding) != dimension {
+			return fmt.Errorf("ExportJSONL: document '%s' has dimension %d, want %d", d.ID, len(d.Embedding), dimension)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	hdr := SnapshotHeader{
+		Format:    snapshotFormat,
+		Version:   1,
+		Embedder:  embedder,
+		Dimension: dimension,
+		Count:     len(docs),
+	}
+	if err := enc.Encode(hdr); err != nil {
+		return err
+	}
+	for _, d := range docs {
+		if err := enc.Encode(d); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ImportJSONL reads a snapshot written by ExportJSONL. It fails if the
+// header is missing or any document's embedding length differs from the
+// header's dimension.
+func ImportJSONL(r io.Reader) (SnapshotHeader, []Document, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+
+	var hdr SnapshotHeader
+	if err := dec.Decode(&hdr); err != nil {
+		return hdr, nil, fmt.Errorf("ImportJSONL: reading header: %w", err)
+	}
+	if hdr.Format != snapshotFormat {
+		return hdr, nil, fmt.Errorf("ImportJSONL: not a vector snapshot (format %q)", hdr.Format)
+	}
+	if hdr.Version != 1 {
+		return hdr, nil, fmt.Errorf("ImportJSONL: unsupported snapshot version %d", hdr.Version)
+	}
+
+	docs := make([]Document, 0, hdr.Count)
+	for {
+		var d Document
+		if err := dec.Decode(&d); err == io.EOF {
+			break
+		} else if err != nil {
+			return hdr, nil, fmt.Errorf("ImportJSONL: document %d: %w", len(docs)+1, err)
+		}
+		if len(d.Embedding) != hdr.Dimension {
+			return hdr, nil, fmt.Errorf("ImportJSONL: document '%s' has dimension %d, header says %d", d.ID, len(d.Embedding), hdr.Dimension)
+		}
+		docs = append(docs, d)
+	}
+	if len(docs) != hdr.Count {
+		return hdr, nil, fmt.Errorf("ImportJSONL: header says %d documents, read %d", hdr.Count, len(docs))
+	}
+	return hdr, docs, nil
+}
+
+// Save writes the store's contents as a JSONL snapshot.
+func (s *InMemoryVectorStore) Save(w io.Writer) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	dim := s.dimension
+	if dim == 0 && len(s.docs) > 0 {
+		dim = len(s.docs[0].Embedding)
+	}
+	return ExportJSONL(w, s.embedder, dim, s.docs)
+}
+
+// Load replaces the store's contents with a snapshot. If the store already
+// knows its embedder or dimension, the snapshot must match them.
+func (s *InMemoryVectorStore) Load(r io.Reader) error {
+	hdr, docs, err := ImportJSONL(r)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dimension != 0 && hdr.Dimension != s.dimension {
+		return fmt.Errorf("Load: snapshot dimension %d does not match store dimension %d", hdr.Dimension, s.dimension)
+	}
+	if s.embedder != "" && hdr.Embedder != "" && hdr.Embedder != s.embedder {
+		return fmt.Errorf("Load: snapshot embedder %q does not match store embedder %q", hdr.Embedder, s.embedder)
+	}
+
+	s.docs = docs
+	s.dimension = hdr.Dimension
+	if hdr.Embedder != "" {
+		s.embedder = hdr.Embedder
+	}
+	return nil
+}
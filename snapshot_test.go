@@ -0,0 +1,84 @@
+package nodechain
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestInMemoryVectorStoreSaveLoad(t *testing.T) {
+	e := NewHashingEmbedder(32)
+	docs := embedDocs(t, e, "first document", "second document")
+	for i := range docs {
+		docs[i].Metadata = map[string]any{MetadataEmbedder: e.Name(), "source": "doc.txt"}
+	}
+
+	src := NewInMemoryVectorStore()
+	if err := src.Add(context.Background(), docs); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := src.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := NewInMemoryVectorStore()
+	if err := dst.Load(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Dimension() != 32 || dst.Embedder() != e.Name() {
+		t.Fatalf("loaded store records %d/%q", dst.Dimension(), dst.Embedder())
+	}
+
+	query := embedQuery(t, e, "second document")
+	got, err := dst.Search(context.Background(), query, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0].ID != "1" || got[0].Metadata["source"] != "doc.txt" {
+		t.Fatalf("search after load returned %+v", got[0])
+	}
+	for i, v := range got[0].Embedding {
+		if v != docs[1].Embedding[i] {
+			t.Fatalf("embedding changed in the round trip at %d", i)
+		}
+	}
+
+	mismatched := NewInMemoryVectorStore()
+	if err := mismatched.Add(context.Background(), embedDocs(t, NewHashingEmbedder(16), "short")); err != nil {
+		t.Fatal(err)
+	}
+	if err := mismatched.Load(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Fatal("loaded a 32-dimensional snapshot into a 16-dimensional store")
+	}
+}
+
+func TestImportJSONLErrors(t *testing.T) {
+	header := `{"format":"nodechain-vectors","version":1,"dimension":2,"count":1}` + "\n"
+	tests := []struct {
+		name, input, want string
+	}{
+		{"empty", "", "reading header"},
+		{"wrong format", `{"format":"other","version":1}` + "\n", "not a vector snapshot"},
+		{"wrong version", `{"format":"nodechain-vectors","version":2}` + "\n", "unsupported snapshot version"},
+		{"wrong dimension", header + `{"id":"a","embedding":[1,2,3]}` + "\n", "dimension 3"},
+		{"short", header, "read 0"},
+		{"corrupt line", header + "{not json\n", "document 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := ImportJSONL(strings.NewReader(tt.input))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportJSONLRejectsMixedDimensions(t *testing.T) {
+	docs := []Document{{ID: "a", Embedding: []float32{1, 0}}, {ID: "b", Embedding: []float32{1}}}
+	if err := ExportJSONL(&bytes.Buffer{}, "", 2, docs); err == nil {
+		t.Fatal("exported documents of mixed dimensions")
+	}
+}
@@ -1,15 +1,20 @@
 package nodechain
 
-// SplitText splits a long text into chunks with overlap.
-// If you chunk by token count later, we can plug in a tokenizer.
-func SplitText(text string, chunkSize, overlap int) []string {
-	runes := []rune(text)
-	n := len(runes)
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
 
-	if chunkSize <= 0 || overlap < 0 || overlap >= chunkSize {
-		panic("invalid chunk or overlap size")
+// SplitText splits a long text into chunks of chunkSize runes with overlap.
+func SplitText(text string, chunkSize, overlap int) ([]string, error) {
+	if err := validateChunking(chunkSize, overlap); err != nil {
+		return nil, err
 	}
 
+	runes := []rune(text)
+	n := len(runes)
+
 	var chunks []string
 	start := 0
 
@@ -27,5 +32,357 @@ func SplitText(text string, chunkSize, overlap int) []string {
 		start = end - overlap
 	}
 
-	return chunks
+	return chunks, nil
+}
+
+func validateChunking(chunkSize, overlap int) error {
+	if chunkSize <= 0 {
+		return fmt.Errorf("split: chunk size must be positive, got %d", chunkSize)
+	}
+	if overlap < 0 || overlap >= chunkSize {
+		return fmt.Errorf("split: overlap must be in [0, %d), got %d", chunkSize, overlap)
+	}
+	return nil
+}
+
+// Chunk is one piece of split text. Metadata holds splitter-specific
+// information (e.g. Markdown header path) and may be nil.
+type Chunk struct {
+	Text     string
+	Metadata map[string]any
+}
+
+// Splitter breaks text into chunks.
+type Splitter interface {
+	Split(text string) ([]Chunk, error)
+}
+
+// CharacterSplitter is a Splitter over SplitText.
+type CharacterSplitter struct {
+	ChunkSize int // in runes
+	Overlap   int
+}
+
+func (s *CharacterSplitter) Split(text string) ([]Chunk, error) {
+	parts, err := SplitText(text, s.ChunkSize, s.Overlap)
+	if err != nil {
+		return nil, err
+	}
+	return textChunks(parts), nil
+}
+
+// Tokenizer breaks text into tokens. Concatenating the tokens must reproduce
+// the input, so token windows can be turned back into text.
+type Tokenizer interface {
+	Tokenize(text string) []string
+}
+
+var wordTokenRe = regexp.MustCompile(`\S+\s*`)
+
+// WordTokenizer treats each whitespace-delimited word, with its trailing
+// whitespace, as one token. It is a rough stand-in for a model tokenizer
+// (English text averages ~1.3 BPE tokens per word).
+type WordTokenizer struct{}
+
+func (WordTokenizer) Tokenize(text string) []string {
+	locs := wordTokenRe.FindAllStringIndex(text, -1)
+	if len(locs) == 0 {
+		if text == "" {
+			return nil
+		}
+		return []string{text}
+	}
+
+	out := make([]string, len(locs))
+	for i, l := range locs {
+		out[i] = text[l[0]:l[1]]
+	}
+	// keep leading whitespace so the tokens concatenate back to text
+	out[0] = text[:locs[0][0]] + out[0]
+	return out
+}
+
+// CountTokens returns the number of tokens tok produces for text.
+func CountTokens(tok Tokenizer, text string) int {
+	return len(tok.Tokenize(text))
+}
+
+// TokenSplitter splits text into windows of ChunkSize tokens.
+type TokenSplitter struct {
+	Tokenizer Tokenizer
+	ChunkSize int // in tokens
+	Overlap   int
+}
+
+func NewTokenSplitter(tok Tokenizer, chunkSize, overlap int) *TokenSplitter {
+	return &TokenSplitter{Tokenizer: tok, ChunkSize: chunkSize, Overlap: overlap}
+}
+
+func (s *TokenSplitter) Split(text string) ([]Chunk, error) {
+	if err := validateChunking(s.ChunkSize, s.Overlap); err != nil {
+		return nil, err
+	}
+	if s.Tokenizer == nil {
+		return nil, fmt.Errorf("TokenSplitter: no tokenizer")
+	}
+
+	tokens := s.Tokenizer.Tokenize(text)
+
+	var out []string
+	for start := 0; start < len(tokens); start += s.ChunkSize - s.Overlap {
+		end := min(start+s.ChunkSize, len(tokens))
+		if t := strings.TrimSpace(strings.Join(tokens[start:end], "")); t != "" {
+			out = append(out, t)
+		}
+		if end == len(tokens) {
+			break
+		}
+	}
+	return textChunks(out), nil
+}
+
+// RecursiveSplitter splits on the first separator, merges the pieces back
+// into chunks of up to ChunkSize, and recurses with the next separator into
+// any piece that is still too large. The default separators go
+// paragraph → line → sentence → word → character.
+type RecursiveSplitter struct {
+	ChunkSize  int
+	Overlap    int
+	Separators []string // "" splits into single characters
+	// SeparatorAtStart attaches each separator to the piece that follows it
+	// rather than the one before (e.g. "\nfunc " belongs to the next function).
+	SeparatorAtStart bool
+	// Length measures text; defaults to rune count. Use a tokenizer-backed
+	// function to size chunks in tokens.
+	Length func(string) int
+}
+
+var DefaultSeparators = []string{"\n\n", "\n", ". ", "? ", "! ", "; ", " ", ""}
+
+func NewRecursiveSplitter(chunkSize, overlap int) *RecursiveSplitter {
+	return &RecursiveSplitter{
+		ChunkSize:  chunkSize,
+		Overlap:    overlap,
+		Separators: DefaultSeparators,
+	}
+}
+
+// TokenLength returns a Length function counting tokens with tok.
+func TokenLength(tok Tokenizer) func(string) int {
+	return func(s string) int { return CountTokens(tok, s) }
+}
+
+func (s *RecursiveSplitter) Split(text string) ([]Chunk, error) {
+	if err := validateChunking(s.ChunkSize, s.Overlap); err != nil {
+		return nil, err
+	}
+	seps := s.Separators
+	if len(seps) == 0 {
+		seps = DefaultSeparators
+	}
+	return textChunks(s.split(text, seps)), nil
+}
+
+func (s *RecursiveSplitter) length(text string) int {
+	if s.Length != nil {
+		return s.Length(text)
+	}
+	return len([]rune(text))
+}
+
+func (s *RecursiveSplitter) split(text string, seps []string) []string {
+	// pick the first separator present in text
+	sep, rest := "", []string(nil)
+	for i, candidate := range seps {
+		if candidate == "" || strings.Contains(text, candidate) {
+			sep, rest = candidate, seps[i+1:]
+			break
+		}
+	}
+
+	var pieces []string
+	switch {
+	case sep == "":
+		for _, r := range text {
+			pieces = append(pieces, string(r))
+		}
+	case s.SeparatorAtStart:
+		parts := strings.Split(text, sep)
+		pieces = append(pieces, parts[0])
+		for _, p := range parts[1:] {
+			pieces = append(pieces, sep+p)
+		}
+	default:
+		pieces = strings.SplitAfter(text, sep)
+	}
+
+	var out, pending []string
+	flush := func() {
+		if len(pending) > 0 {
+			out = append(out, s.merge(pending)...)
+			pending = nil
+		}
+	}
+
+	for _, p := range pieces {
+		if s.length(p) <= s.ChunkSize || sep == "" {
+			pending = append(pending, p)
+			continue
+		}
+		flush()
+		if len(rest) == 0 {
+			out = append(out, p)
+		} else {
+			out = append(out, s.split(p, rest)...)
+		}
+	}
+	flush()
+
+	return out
+}
+
+// merge joins consecutive pieces into chunks no longer than ChunkSize, with
+// roughly Overlap worth of trailing pieces repeated at the start of the next.
+func (s *RecursiveSplitter) merge(pieces []string) []string {
+	var out []string
+	var window []string
+	var lengths []int
+	total := 0
+
+	emit := func() {
+		if t := strings.TrimSpace(strings.Join(window, "")); t != "" {
+			out = append(out, t)
+		}
+	}
+
+	for _, p := range pieces {
+		l := s.length(p)
+		if total+l > s.ChunkSize && len(window) > 0 {
+			emit()
+			for len(window) > 0 && (total > s.Overlap || total+l > s.ChunkSize) {
+				total -= lengths[0]
+				window, lengths = window[1:], lengths[1:]
+			}
+		}
+		window = append(window, p)
+		lengths = append(lengths, l)
+		total += l
+	}
+	if len(window) > 0 {
+		emit()
+	}
+	return out
+}
+
+var codeSeparators = map[string][]string{
+	"go":         {"\nfunc ", "\ntype ", "\nvar ", "\nconst ", "\n\n", "\n", " ", ""},
+	"python":     {"\nclass ", "\ndef ", "\n\tdef ", "\n    def ", "\n\n", "\n", " ", ""},
+	"javascript": {"\nfunction ", "\nclass ", "\nconst ", "\nlet ", "\nexport ", "\n\n", "\n", " ", ""},
+	"typescript": {"\nfunction ", "\nclass ", "\ninterface ", "\ntype ", "\nconst ", "\nexport ", "\n\n", "\n", " ", ""},
+}
+
+// NewCodeSplitter returns a RecursiveSplitter that prefers to cut source code
+// at top-level declarations. Unknown languages fall back to blank lines and
+// lines.
+func NewCodeSplitter(language string, chunkSize, overlap int) *RecursiveSplitter {
+	seps, ok := codeSeparators[strings.ToLower(language)]
+	if !ok {
+		seps = []string{"\n\n", "\n", " ", ""}
+	}
+	return &RecursiveSplitter{
+		ChunkSize:        chunkSize,
+		Overlap:          overlap,
+		Separators:       seps,
+		SeparatorAtStart: true,
+	}
+}
+
+var (
+	mdHeaderRe = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdFenceRe  = regexp.MustCompile("^\\s*(```|~~~)")
+)
+
+// MarkdownSplitter splits a Markdown document at headers. Each chunk's
+// metadata carries "headers" ([]string, outermost first) and "header_path"
+// ("A > B > C"). Headers inside fenced code blocks are ignored. Sections
+// longer than Inner's chunk size are split further by Inner, if set.
+type MarkdownSplitter struct {
+	Inner Splitter
+}
+
+func NewMarkdownSplitter(chunkSize, overlap int) *MarkdownSplitter {
+	return &MarkdownSplitter{Inner: NewRecursiveSplitter(chunkSize, overlap)}
+}
+
+func (s *MarkdownSplitter) Split(text string) ([]Chunk, error) {
+	var out []Chunk
+	var path []string
+	var section strings.Builder
+	inFence := false
+
+	flush := func() error {
+		body := strings.TrimSpace(section.String())
+		section.Reset()
+		if body == "" {
+			return nil
+		}
+
+		var headers []string
+		for _, h := range path {
+			if h != "" {
+				headers = append(headers, h)
+			}
+		}
+		parts := []Chunk{{Text: body}}
+		if s.Inner != nil {
+			var err error
+			if parts, err = s.Inner.Split(body); err != nil {
+				return err
+			}
+		}
+		for _, p := range parts {
+			meta := map[string]any{
+				"headers":     headers,
+				"header_path": strings.Join(headers, " > "),
+			}
+			for k, v := range p.Metadata {
+				meta[k] = v
+			}
+			out = append(out, Chunk{Text: p.Text, Metadata: meta})
+		}
+		return nil
+	}
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		trimmed := strings.TrimRight(line, "\r\n")
+		if mdFenceRe.MatchString(trimmed) {
+			inFence = !inFence
+		}
+
+		if !inFence {
+			if m := mdHeaderRe.FindStringSubmatch(trimmed); m != nil {
+				if err := flush(); err != nil {
+					return nil, err
+				}
+				level := len(m[1])
+				for len(path) < level-1 {
+					path = append(path, "")
+				}
+				path = append(path[:level-1], m[2])
+			}
+		}
+		section.WriteString(line)
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func textChunks(texts []string) []Chunk {
+	out := make([]Chunk, len(texts))
+	for i, t := range texts {
+		out[i] = Chunk{Text: t}
+	}
+	return out
 }
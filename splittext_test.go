@@ -0,0 +1,160 @@
+package nodechain
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func chunkTexts(chunks []Chunk) []string {
+	out := make([]string, len(chunks))
+	for i, c := range chunks {
+		out[i] = c.Text
+	}
+	return out
+}
+
+func TestSplitText(t *testing.T) {
+	tests := []struct {
+		text          string
+		size, overlap int
+		want          []string
+	}{
+		{"abcdefgh", 3, 0, []string{"abc", "def", "gh"}},
+		{"abcdefgh", 4, 2, []string{"abcd", "cdef", "efgh"}},
+		{"héllo wörld", 6, 1, []string{"héllo ", " wörld"}},
+		{"", 3, 0, nil},
+	}
+	for _, tt := range tests {
+		got, err := SplitText(tt.text, tt.size, tt.overlap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("SplitText(%q, %d, %d) = %q, want %q", tt.text, tt.size, tt.overlap, got, tt.want)
+		}
+	}
+}
+
+func TestSplittersRejectBadChunking(t *testing.T) {
+	tests := []struct {
+		name string
+		s    Splitter
+	}{
+		{"character zero size", &CharacterSplitter{ChunkSize: 0}},
+		{"character overlap too large", &CharacterSplitter{ChunkSize: 4, Overlap: 4}},
+		{"token negative overlap", NewTokenSplitter(WordTokenizer{}, 4, -1)},
+		{"token no tokenizer", &TokenSplitter{ChunkSize: 4}},
+		{"recursive overlap too large", NewRecursiveSplitter(4, 5)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.s.Split("some text"); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestWordTokenizerRoundTrip(t *testing.T) {
+	for _, text := range []string{"", "one", "  leading and trailing  ", "tabs\tand\nnewlines"} {
+		tokens := WordTokenizer{}.Tokenize(text)
+		if got := strings.Join(tokens, ""); got != text {
+			t.Errorf("tokens of %q join to %q", text, got)
+		}
+	}
+}
+
+func TestTokenSplitter(t *testing.T) {
+	s := NewTokenSplitter(WordTokenizer{}, 3, 1)
+	chunks, err := s.Split("one two three four five six")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"one two three", "three four five", "five six"}
+	if got := chunkTexts(chunks); !slices.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestRecursiveSplitter(t *testing.T) {
+	tests := []struct {
+		name string
+		s    *RecursiveSplitter
+		text string
+		want []string
+	}{
+		{
+			"paragraphs fit",
+			NewRecursiveSplitter(30, 0),
+			"First paragraph here.\n\nSecond paragraph here.",
+			[]string{"First paragraph here.", "Second paragraph here."},
+		},
+		{
+			"falls back to sentences",
+			NewRecursiveSplitter(20, 0),
+			"One short sentence. Another short one. A third.",
+			[]string{"One short sentence.", "Another short one.", "A third."},
+		},
+		{
+			"code at declarations",
+			NewCodeSplitter("go", 25, 0),
+			"package x\n\nfunc A() {\n\treturn\n}\n\nfunc B() {\n\treturn\n}\n",
+			[]string{"package x", "func A() {\n\treturn\n}", "func B() {\n\treturn\n}"},
+		},
+		{
+			"token length",
+			&RecursiveSplitter{ChunkSize: 2, Separators: DefaultSeparators, Length: TokenLength(WordTokenizer{})},
+			"a b c d e",
+			[]string{"a b", "c d", "e"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks, err := tt.s.Split(tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := chunkTexts(chunks)
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			for _, c := range got {
+				if tt.s.length(c) > tt.s.ChunkSize {
+					t.Errorf("chunk %q longer than %d", c, tt.s.ChunkSize)
+				}
+			}
+		})
+	}
+}
+
+func TestMarkdownSplitter(t *testing.T) {
+	text := "# Guide\nIntro.\n## Install\nRun it.\n```sh\n# not a header\n```\n### Linux\napt install\n# Other\nEnd.\n"
+	chunks, err := NewMarkdownSplitter(200, 0).Split(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		path, prefix string
+	}{
+		{"Guide", "# Guide"},
+		{"Guide > Install", "## Install"},
+		{"Guide > Install > Linux", "### Linux"},
+		{"Other", "# Other"},
+	}
+	if len(chunks) != len(want) {
+		t.Fatalf("got %d chunks: %q", len(chunks), chunkTexts(chunks))
+	}
+	for i, w := range want {
+		if got := chunks[i].Metadata["header_path"]; got != w.path {
+			t.Errorf("chunk %d header_path %q, want %q", i, got, w.path)
+		}
+		if !strings.HasPrefix(chunks[i].Text, w.prefix) {
+			t.Errorf("chunk %d = %q, want prefix %q", i, chunks[i].Text, w.prefix)
+		}
+	}
+	if !strings.Contains(chunks[1].Text, "# not a header") {
+		t.Errorf("fenced header split the section: %q", chunks[1].Text)
+	}
+}
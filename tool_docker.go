@@ -1,30 +1,81 @@
 package nodechain
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+)
 
+// DockerExecTool runs shell commands in a Sandbox. It keeps its historical
+// name because agents call it as "docker_exec" whatever the backend.
 type DockerExecTool struct {
-	Docker *DockerManager
+	Sandbox Sandbox
+	Options ExecOptions // per-command timeout and output cap
+
+	// SandboxKey, if set, names a memory key holding the Sandbox to use
+	// (see SandboxNode). RunWithMemory fails if the key holds no sandbox
+	// rather than falling back to Sandbox, which another branch may share.
+	SandboxKey string
 }
 
 func (t *DockerExecTool) Name() string { return "docker_exec" }
 
+func (t *DockerExecTool) Description() string {
+	return `docker_exec(cmd: string) -> {"stdout", "stderr", "exit_code", "timed_out", "truncated"}: ` +
+		`runs a shell command in a persistent sandbox; the working directory is /workspace`
+}
+
 func (t *DockerExecTool) Run(input any) (any, error) {
+	return t.exec(context.Background(), t.Sandbox, input)
+}
+
+func (t *DockerExecTool) RunWithMemory(ctx context.Context, mem *Memory, input any) (any, error) {
+	sb, err := sandboxFromMemory(mem, t.SandboxKey, t.Sandbox)
+	if err != nil {
+		return nil, fmt.Errorf("docker_exec: %w", err)
+	}
+	return t.exec(ctx, sb, input)
+}
+
+func (t *DockerExecTool) exec(ctx context.Context, sb Sandbox, input any) (any, error) {
 	cmdStr, ok := input.(string)
 	if !ok {
 		return nil, fmt.Errorf("docker_exec: input must be string")
 	}
+	if sb == nil {
+		return nil, fmt.Errorf("docker_exec: no sandbox")
+	}
 
-	stdout, stderr, err := t.Docker.Run(cmdStr)
+	res, err := sb.Exec(ctx, cmdStr, t.Options)
+	out := map[string]any{
+		"stdout":    res.Stdout,
+		"stderr":    res.Stderr,
+		"exit_code": res.ExitCode,
+		"timed_out": res.TimedOut,
+		"truncated": res.Truncated,
+	}
 	if err != nil {
-		return map[string]any{
-			"stdout": stdout,
-			"stderr": stderr,
-			"error":  err.Error(),
-		}, nil
+		out["error"] = err.Error()
 	}
+	return out, nil
+}
 
-	return map[string]any{
-		"stdout": stdout,
-		"stderr": stderr,
-	}, nil
+// sandboxFromMemory returns the Sandbox stored at key, or fallback when key
+// is empty. A key that holds no Sandbox is an error: the branch's own
+// sandbox was never acquired or already released.
+func sandboxFromMemory(mem *Memory, key string, fallback Sandbox) (Sandbox, error) {
+	if key == "" {
+		return fallback, nil
+	}
+	if mem == nil {
+		return nil, fmt.Errorf("no sandbox at key '%s'", key)
+	}
+	v, ok := mem.Get(key)
+	if !ok {
+		return nil, fmt.Errorf("no sandbox at key '%s'", key)
+	}
+	sb, ok := v.(Sandbox)
+	if !ok {
+		return nil, fmt.Errorf("value at key '%s' is not a Sandbox", key)
+	}
+	return sb, nil
 }
@@ -0,0 +1,492 @@
+package nodechain
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	DefaultFileToolMaxBytes = 1 << 20   // read_file and write_file
+	DefaultArtifactMaxBytes = 100 << 20 // download_artifact
+	DefaultListDirEntries   = 1000
+)
+
+// ReadFileTool returns a file from the sandbox workspace. UTF-8 text comes
+// back as is and anything else base64-encoded.
+type ReadFileTool struct {
+	Sandbox    Sandbox
+	SandboxKey string // see DockerExecTool
+	MaxBytes   int64  // default DefaultFileToolMaxBytes
+}
+
+func (t *ReadFileTool) Name() string { return "read_file" }
+
+func (t *ReadFileTool) Description() string {
+	return `read_file({"path": string, "encoding"?: "base64"}) -> {"path", "size", "encoding", "content"}: ` +
+		`reads a file under /workspace; binary files are returned base64-encoded`
+}
+
+func (t *ReadFileTool) Run(input any) (any, error) {
+	return t.read(context.Background(), t.Sandbox, input)
+}
+
+func (t *ReadFileTool) RunWithMemory(ctx context.Context, mem *Memory, input any) (any, error) {
+	sb, err := sandboxFromMemory(mem, t.SandboxKey, t.Sandbox)
+	if err != nil {
+		return nil, fmt.Errorf("read_file: %w", err)
+	}
+	return t.read(ctx, sb, input)
+}
+
+func (t *ReadFileTool) read(ctx context.Context, sb Sandbox, input any) (any, error) {
+	args, err := toolArgs(input, "path")
+	if err != nil {
+		return nil, fmt.Errorf("read_file: %w", err)
+	}
+	p, err := workspacePath(argString(args, "path"))
+	if err != nil {
+		return nil, fmt.Errorf("read_file: %w", err)
+	}
+	if sb == nil {
+		return nil, fmt.Errorf("read_file: no sandbox")
+	}
+
+	limit := t.MaxBytes
+	if limit <= 0 {
+		limit = DefaultFileToolMaxBytes
+	}
+	if err := checkRegularFile(ctx, sb, p, limit); err != nil {
+		return nil, fmt.Errorf("read_file: %w", err)
+	}
+
+	data, err := copyOutBytes(ctx, sb, p)
+	if err != nil {
+		return nil, fmt.Errorf("read_file: %w", err)
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("read_file: %s is %d bytes, limit is %d", p, len(data), limit)
+	}
+
+	out := map[string]any{
+		"path": p,
+		"size": len(data),
+	}
+	if argString(args, "encoding") != "base64" && isText(data) {
+		out["encoding"] = "utf-8"
+		out["content"] = string(data)
+	} else {
+		out["encoding"] = "base64"
+		out["content"] = base64.StdEncoding.EncodeToString(data)
+	}
+	return out, nil
+}
+
+// WriteFileTool creates or replaces a file in the sandbox workspace,
+// creating parent directories as needed.
+type WriteFileTool struct {
+	Sandbox    Sandbox
+	SandboxKey string // see DockerExecTool
+	MaxBytes   int64  // default DefaultFileToolMaxBytes
+}
+
+func (t *WriteFileTool) Name() string { return "write_file" }
+
+func (t *WriteFileTool) Description() string {
+	return `write_file({"path": string, "content": string, "encoding"?: "utf-8"|"base64"}) -> {"path", "size"}: ` +
+		`creates or replaces a file under /workspace`
+}
+
+func (t *WriteFileTool) Run(input any) (any, error) {
+	return t.write(context.Background(), t.Sandbox, input)
+}
+
+func (t *WriteFileTool) RunWithMemory(ctx context.Context, mem *Memory, input any) (any, error) {
+	sb, err := sandboxFromMemory(mem, t.SandboxKey, t.Sandbox)
+	if err != nil {
+		return nil, fmt.Errorf("write_file: %w", err)
+	}
+	return t.write(ctx, sb, input)
+}
+
+func (t *WriteFileTool) write(ctx context.Context, sb Sandbox, input any) (any, error) {
+	args, err := toolArgs(input, "path")
+	if err != nil {
+		return nil, fmt.Errorf("write_file: %w", err)
+	}
+	p, err := workspacePath(argString(args, "path"))
+	if err != nil {
+		return nil, fmt.Errorf("write_file: %w", err)
+	}
+	if p == "/workspace" {
+		return nil, fmt.Errorf("write_file: path must name a file")
+	}
+	if sb == nil {
+		return nil, fmt.Errorf("write_file: no sandbox")
+	}
+
+	content, ok := args["content"].(string)
+	if !ok {
+		return nil, fmt.Errorf("write_file: content must be a string")
+	}
+	data := []byte(content)
+	switch enc := argString(args, "encoding"); enc {
+	case "", "utf-8", "text":
+	case "base64":
+		if data, err = base64.StdEncoding.DecodeString(content); err != nil {
+			return nil, fmt.Errorf("write_file: invalid base64: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("write_file: unknown encoding %q", enc)
+	}
+
+	limit := t.MaxBytes
+	if limit <= 0 {
+		limit = DefaultFileToolMaxBytes
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("write_file: content is %d bytes, limit is %d", len(data), limit)
+	}
+
+	// create parents as the sandbox user, and never write over a directory
+	check := fmt.Sprintf("mkdir -p -- %s && test ! -d %s", shellPath(path.Dir(p)), shellPath(p))
+	res, err := sb.Exec(ctx, check, ExecOptions{Timeout: fileToolTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("write_file: %w", err)
+	}
+	if res.ExitCode != 0 {
+		msg := strings.TrimSpace(res.Stderr)
+		if msg == "" {
+			msg = "is a directory"
+		}
+		return nil, fmt.Errorf("write_file: %s: %s", p, msg)
+	}
+
+	tmp, err := os.MkdirTemp("", "nodechain-write-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	local := filepath.Join(tmp, path.Base(p))
+	if err := os.WriteFile(local, data, 0o644); err != nil {
+		return nil, err
+	}
+	if err := sb.CopyIn(ctx, local, p); err != nil {
+		return nil, fmt.Errorf("write_file: %w", err)
+	}
+
+	return map[string]any{
+		"path": p,
+		"size": len(data),
+	}, nil
+}
+
+// ListDirTool lists a directory in the sandbox workspace. It relies on GNU
+// find in the sandbox.
+type ListDirTool struct {
+	Sandbox    Sandbox
+	SandboxKey string // see DockerExecTool
+	MaxEntries int    // default DefaultListDirEntries
+}
+
+func (t *ListDirTool) Name() string { return "list_dir" }
+
+func (t *ListDirTool) Description() string {
+	return `list_dir({"path"?: string, "depth"?: number}) -> {"path", "entries": [{"name", "type", "size"}], "truncated"}: ` +
+		`lists a directory under /workspace (default /workspace, depth 1)`
+}
+
+func (t *ListDirTool) Run(input any) (any, error) {
+	return t.list(context.Background(), t.Sandbox, input)
+}
+
+func (t *ListDirTool) RunWithMemory(ctx context.Context, mem *Memory, input any) (any, error) {
+	sb, err := sandboxFromMemory(mem, t.SandboxKey, t.Sandbox)
+	if err != nil {
+		return nil, fmt.Errorf("list_dir: %w", err)
+	}
+	return t.list(ctx, sb, input)
+}
+
+func (t *ListDirTool) list(ctx context.Context, sb Sandbox, input any) (any, error) {
+	args, err := toolArgs(input, "path")
+	if err != nil {
+		return nil, fmt.Errorf("list_dir: %w", err)
+	}
+	dir := argString(args, "path")
+	if dir == "" {
+		dir = "/workspace"
+	}
+	p, err := workspacePath(dir)
+	if err != nil {
+		return nil, fmt.Errorf("list_dir: %w", err)
+	}
+	if sb == nil {
+		return nil, fmt.Errorf("list_dir: no sandbox")
+	}
+
+	depth := 1
+	switch d := args["depth"].(type) {
+	case float64:
+		depth = max(int(d), 1)
+	case int:
+		depth = max(d, 1)
+	}
+	limit := t.MaxEntries
+	if limit <= 0 {
+		limit = DefaultListDirEntries
+	}
+
+	cmd := fmt.Sprintf(
+		`test -d %[1]s || { echo "not a directory" >&2; exit 2; }; `+
+			`find %[1]s -mindepth 1 -maxdepth %[2]d -printf '%%y\t%%s\t%%P\n' | head -n %[3]d`,
+		shellPath(p), depth, limit+1)
+	res, err := sb.Exec(ctx, cmd, ExecOptions{Timeout: fileToolTimeout, MaxOutputBytes: -1})
+	if err != nil {
+		return nil, fmt.Errorf("list_dir: %w", err)
+	}
+	if res.ExitCode != 0 {
+		return nil, fmt.Errorf("list_dir: %s: %s", p, strings.TrimSpace(res.Stderr))
+	}
+
+	var entries []map[string]any
+	for _, line := range strings.Split(strings.TrimRight(res.Stdout, "\n"), "\n") {
+		parts := strings.SplitN(line, "\t", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		size, _ := strconv.ParseInt(parts[1], 10, 64)
+		entries = append(entries, map[string]any{
+			"name": parts[2],
+			"type": findTypeName(parts[0]),
+			"size": size,
+		})
+	}
+	truncated := len(entries) > limit
+	if truncated {
+		entries = entries[:limit]
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i]["name"].(string) < entries[j]["name"].(string)
+	})
+
+	return map[string]any{
+		"path":      p,
+		"entries":   entries,
+		"truncated": truncated,
+	}, nil
+}
+
+func findTypeName(t string) string {
+	switch t {
+	case "f":
+		return "file"
+	case "d":
+		return "dir"
+	case "l":
+		return "symlink"
+	}
+	return "other"
+}
+
+// DownloadArtifactTool copies a file from the sandbox workspace into Dir on
+// the host, so it outlives the sandbox. An existing artifact of the same name
+// is replaced.
+type DownloadArtifactTool struct {
+	Sandbox    Sandbox
+	SandboxKey string // see DockerExecTool
+	Dir        string // host directory for artifacts
+	MaxBytes   int64  // default DefaultArtifactMaxBytes
+}
+
+func (t *DownloadArtifactTool) Name() string { return "download_artifact" }
+
+func (t *DownloadArtifactTool) Description() string {
+	return `download_artifact({"path": string, "name"?: string}) -> {"path", "artifact", "size", "sha256"}: ` +
+		`saves a file from /workspace as a result artifact outside the sandbox`
+}
+
+func (t *DownloadArtifactTool) Run(input any) (any, error) {
+	return t.download(context.Background(), t.Sandbox, input)
+}
+
+func (t *DownloadArtifactTool) RunWithMemory(ctx context.Context, mem *Memory, input any) (any, error) {
+	sb, err := sandboxFromMemory(mem, t.SandboxKey, t.Sandbox)
+	if err != nil {
+		return nil, fmt.Errorf("download_artifact: %w", err)
+	}
+	return t.download(ctx, sb, input)
+}
+
+func (t *DownloadArtifactTool) download(ctx context.Context, sb Sandbox, input any) (any, error) {
+	args, err := toolArgs(input, "path")
+	if err != nil {
+		return nil, fmt.Errorf("download_artifact: %w", err)
+	}
+	p, err := workspacePath(argString(args, "path"))
+	if err != nil {
+		return nil, fmt.Errorf("download_artifact: %w", err)
+	}
+	if sb == nil {
+		return nil, fmt.Errorf("download_artifact: no sandbox")
+	}
+	if t.Dir == "" {
+		return nil, fmt.Errorf("download_artifact: no artifact directory configured")
+	}
+
+	name := argString(args, "name")
+	if name == "" {
+		name = path.Base(p)
+	}
+	name = filepath.Base(filepath.FromSlash(name))
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		return nil, fmt.Errorf("download_artifact: invalid name %q", name)
+	}
+
+	limit := t.MaxBytes
+	if limit <= 0 {
+		limit = DefaultArtifactMaxBytes
+	}
+	if err := checkRegularFile(ctx, sb, p, limit); err != nil {
+		return nil, fmt.Errorf("download_artifact: %w", err)
+	}
+
+	if err := os.MkdirAll(t.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	dst := filepath.Join(t.Dir, name)
+	os.Remove(dst) // never write through an existing symlink
+	if err := sb.CopyOut(ctx, p, dst); err != nil {
+		return nil, fmt.Errorf("download_artifact: %w", err)
+	}
+
+	data, err := os.ReadFile(dst)
+	if err != nil {
+		return nil, fmt.Errorf("download_artifact: %w", err)
+	}
+	sum := sha256.Sum256(data)
+
+	return map[string]any{
+		"path":     p,
+		"artifact": dst,
+		"size":     len(data),
+		"sha256":   hex.EncodeToString(sum[:]),
+	}, nil
+}
+
+const fileToolTimeout = 30 * time.Second
+
+// workspacePath turns an agent-supplied path into a clean absolute path
+// under /workspace. Relative paths are taken relative to /workspace.
+func workspacePath(p string) (string, error) {
+	p = strings.TrimSpace(p)
+	if p == "" {
+		return "", fmt.Errorf("path is required")
+	}
+	if !path.IsAbs(p) {
+		p = path.Join("/workspace", p)
+	}
+	p = path.Clean(p)
+	if p != "/workspace" && !strings.HasPrefix(p, "/workspace/") {
+		return "", fmt.Errorf("path %q is outside /workspace", p)
+	}
+	return p, nil
+}
+
+// toolArgs accepts tool input as a JSON object (decoded or as a string) or
+// as a bare string, which is taken as the value of key.
+func toolArgs(input any, key string) (map[string]any, error) {
+	switch v := input.(type) {
+	case map[string]any:
+		return v, nil
+	case string:
+		if s := strings.TrimSpace(v); strings.HasPrefix(s, "{") {
+			var args map[string]any
+			if err := json.Unmarshal([]byte(s), &args); err == nil {
+				return args, nil
+			}
+		}
+		return map[string]any{key: v}, nil
+	case nil:
+		return map[string]any{}, nil
+	}
+	return nil, fmt.Errorf("input must be a JSON object or a string")
+}
+
+func argString(args map[string]any, key string) string {
+	s, _ := args[key].(string)
+	return s
+}
+
+// shellPath quotes a workspacePath result for sandbox shell commands,
+// rooted at $WORKSPACE where the backend sets it (LocalSandbox) and at
+// /workspace otherwise.
+func shellPath(p string) string {
+	rest := strings.TrimPrefix(p, "/workspace")
+	if rest == "" {
+		return `"${WORKSPACE:-/workspace}"`
+	}
+	return `"${WORKSPACE:-/workspace}"` + shellQuote(rest)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// checkRegularFile fails unless p is a regular file of at most limit bytes,
+// checked inside the sandbox before anything is copied.
+func checkRegularFile(ctx context.Context, sb Sandbox, p string, limit int64) error {
+	res, err := sb.Exec(ctx, "stat -c '%F|%s' -- "+shellPath(p), ExecOptions{Timeout: fileToolTimeout})
+	if err != nil {
+		return err
+	}
+	if res.ExitCode != 0 {
+		return fmt.Errorf("%s: %s", p, strings.TrimSpace(res.Stderr))
+	}
+
+	kind, sizeStr, _ := strings.Cut(strings.TrimSpace(res.Stdout), "|")
+	if kind != "regular file" && kind != "regular empty file" {
+		return fmt.Errorf("%s is a %s, not a regular file", p, kind)
+	}
+	if size, _ := strconv.ParseInt(sizeStr, 10, 64); size > limit {
+		return fmt.Errorf("%s is %d bytes, limit is %d", p, size, limit)
+	}
+	return nil
+}
+
+func copyOutBytes(ctx context.Context, sb Sandbox, p string) ([]byte, error) {
+	tmp, err := os.MkdirTemp("", "nodechain-read-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	local := filepath.Join(tmp, "file")
+	if err := sb.CopyOut(ctx, p, local); err != nil {
+		return nil, err
+	}
+	info, err := os.Lstat(local)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", p)
+	}
+	return os.ReadFile(local)
+}
+
+// isText reports whether data can be returned as a JSON string unchanged.
+func isText(data []byte) bool {
+	return utf8.Valid(data) && !strings.ContainsRune(string(data), 0)
+}
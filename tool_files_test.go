@@ -0,0 +1,109 @@
+//go:build unix
+
+package nodechain
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func startLocalSandbox(t *testing.T) *LocalSandbox {
+	t.Helper()
+	sb := NewLocalSandbox()
+	sb.Dir = t.TempDir()
+	sb.AllowRoot = true // tests may run as root, e.g. in CI containers
+	if err := sb.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sb.Stop(context.Background()) })
+	return sb
+}
+
+func TestWriteFileRefusesSymlinkEscape(t *testing.T) {
+	outside := t.TempDir()
+	existing := filepath.Join(outside, "existing.txt")
+	if err := os.WriteFile(existing, []byte("original"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		target string
+	}{
+		{"dangling", filepath.Join(outside, "escaped.txt")},
+		{"existing", existing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sb := startLocalSandbox(t)
+			if err := os.Symlink(tt.target, filepath.Join(sb.Dir, "evil")); err != nil {
+				t.Fatal(err)
+			}
+
+			tool := &WriteFileTool{Sandbox: sb}
+			if _, err := tool.Run(`{"path": "evil", "content": "pwned"}`); err == nil {
+				t.Fatal("write_file through a symlink succeeded")
+			}
+
+			data, err := os.ReadFile(tt.target)
+			switch {
+			case os.IsNotExist(err) && tt.target != existing:
+			case err == nil && string(data) == "original":
+			default:
+				t.Fatalf("target outside the sandbox was written: %q, %v", data, err)
+			}
+		})
+	}
+}
+
+func TestCopyInRefusesPlantedSymlinkInTree(t *testing.T) {
+	sb := startLocalSandbox(t)
+	outside := t.TempDir()
+	victim := filepath.Join(outside, "victim")
+
+	// the sandbox plants workspace/dir/file -> victim before the host copies dir in
+	if err := os.MkdirAll(filepath.Join(sb.Dir, "dir"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(victim, filepath.Join(sb.Dir, "dir", "file")); err != nil {
+		t.Fatal(err)
+	}
+
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "file"), []byte("pwned"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := sb.CopyIn(context.Background(), src, "/workspace/dir"); err == nil {
+		t.Fatal("CopyIn wrote through a planted symlink")
+	}
+	if _, err := os.Stat(victim); !os.IsNotExist(err) {
+		t.Fatalf("file outside the sandbox was created: %v", err)
+	}
+
+	// seeding a reused workspace goes through the same path
+	spec := &ProvisionSpec{Files: map[string]string{"/workspace/dir": src}}
+	if err := spec.seedWorkspace(sb.Dir); err == nil {
+		t.Fatal("seedWorkspace wrote through a planted symlink")
+	}
+	if _, err := os.Stat(victim); !os.IsNotExist(err) {
+		t.Fatalf("file outside the sandbox was created: %v", err)
+	}
+}
+
+func TestReadFileRefusesSymlink(t *testing.T) {
+	sb := startLocalSandbox(t)
+	secret := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secret, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(sb.Dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	tool := &ReadFileTool{Sandbox: sb}
+	if out, err := tool.Run("link"); err == nil {
+		t.Fatalf("read_file followed a symlink out of the sandbox: %v", out)
+	}
+}
@@ -0,0 +1,137 @@
+package nodechain
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SnapshotTool lets an agent checkpoint its sandbox before a risky step.
+// The sandbox must be a Snapshotter.
+type SnapshotTool struct {
+	Sandbox    Sandbox
+	SandboxKey string // see DockerExecTool
+}
+
+func (t *SnapshotTool) Name() string { return "snapshot" }
+
+func (t *SnapshotTool) Description() string {
+	return `snapshot(label?: string) -> {"id", "label", "snapshots"}: ` +
+		`saves the sandbox state (the /workspace files, and installed packages where supported) ` +
+		`so rollback can return to it; take one before risky commands`
+}
+
+func (t *SnapshotTool) Run(input any) (any, error) {
+	return t.snapshot(context.Background(), nil, input)
+}
+
+func (t *SnapshotTool) RunWithMemory(ctx context.Context, mem *Memory, input any) (any, error) {
+	return t.snapshot(ctx, mem, input)
+}
+
+func (t *SnapshotTool) snapshot(ctx context.Context, mem *Memory, input any) (any, error) {
+	args, err := toolArgs(input, "label")
+	if err != nil {
+		return nil, fmt.Errorf("snapshot: %w", err)
+	}
+	sb, err := snapshotterFromMemory(mem, t.SandboxKey, t.Sandbox)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot: %w", err)
+	}
+
+	label := strings.TrimSpace(argString(args, "label"))
+	if strings.HasPrefix(label, internalSnapshotPrefix) {
+		return nil, fmt.Errorf("snapshot: labels starting with %q are reserved", internalSnapshotPrefix)
+	}
+	snap, err := sb.Snapshot(ctx, label)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot: %w", err)
+	}
+	return map[string]any{
+		"id":        snap.ID,
+		"label":     snap.Label,
+		"snapshots": describeSnapshots(sb.Snapshots()),
+	}, nil
+}
+
+// RollbackTool restores the sandbox to a snapshot taken by SnapshotTool,
+// SnapshotNode or the application. The sandbox must be a Snapshotter.
+type RollbackTool struct {
+	Sandbox    Sandbox
+	SandboxKey string // see DockerExecTool
+}
+
+func (t *RollbackTool) Name() string { return "rollback" }
+
+func (t *RollbackTool) Description() string {
+	return `rollback(snapshot?: string) -> {"restored", "snapshots"}: ` +
+		`restores the sandbox to a snapshot, by id or label (the latest if omitted); ` +
+		`everything changed since is lost and running processes are stopped`
+}
+
+func (t *RollbackTool) Run(input any) (any, error) {
+	return t.rollback(context.Background(), nil, input)
+}
+
+func (t *RollbackTool) RunWithMemory(ctx context.Context, mem *Memory, input any) (any, error) {
+	return t.rollback(ctx, mem, input)
+}
+
+func (t *RollbackTool) rollback(ctx context.Context, mem *Memory, input any) (any, error) {
+	args, err := toolArgs(input, "snapshot")
+	if err != nil {
+		return nil, fmt.Errorf("rollback: %w", err)
+	}
+	sb, err := snapshotterFromMemory(mem, t.SandboxKey, t.Sandbox)
+	if err != nil {
+		return nil, fmt.Errorf("rollback: %w", err)
+	}
+
+	// resolve ref among the snapshots the agent can see, so it cannot roll
+	// back to one a RetryNode is holding
+	ref := strings.TrimSpace(argString(args, "snapshot"))
+	snaps := visibleSnapshots(sb.Snapshots())
+	i := latestSnapshot(snaps, ref)
+	if i < 0 {
+		err = ErrNoSnapshot
+		if ref != "" {
+			err = fmt.Errorf("%w %q", ErrNoSnapshot, ref)
+		}
+	} else {
+		err = sb.Restore(ctx, snaps[i].ID)
+	}
+	if err != nil {
+		// let the agent pick a snapshot that exists
+		return nil, fmt.Errorf("rollback: %w (snapshots: %v)", err, describeSnapshots(snaps))
+	}
+	return map[string]any{
+		"restored":  snaps[i].ID,
+		"snapshots": describeSnapshots(snaps),
+	}, nil
+}
+
+// visibleSnapshots drops the internal snapshots from snaps.
+func visibleSnapshots(snaps []SandboxSnapshot) []SandboxSnapshot {
+	var out []SandboxSnapshot
+	for _, s := range snaps {
+		if !s.internal() {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// describeSnapshots lists snapshots for an agent, oldest first, leaving out
+// internal ones.
+func describeSnapshots(snaps []SandboxSnapshot) []map[string]any {
+	out := []map[string]any{}
+	for _, s := range visibleSnapshots(snaps) {
+		out = append(out, map[string]any{
+			"id":      s.ID,
+			"label":   s.Label,
+			"created": s.Created.Format(time.RFC3339),
+		})
+	}
+	return out
+}
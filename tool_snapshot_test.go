@@ -0,0 +1,158 @@
+//go:build unix
+
+package nodechain
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRetryNodeRestoresSandbox(t *testing.T) {
+	sb := startLocalSandbox(t)
+	writeTree(t, sb.Dir, map[string]string{"state.txt": "clean"})
+
+	var seen []string
+	attempt := newFuncNode(func(ctx context.Context, mem *Memory) error {
+		data, _ := os.ReadFile(filepath.Join(sb.Dir, "state.txt"))
+		seen = append(seen, string(data))
+		if len(seen) < 3 {
+			writeTree(t, sb.Dir, map[string]string{"state.txt": "dirty", "junk.txt": "junk"})
+			return errors.New("failed")
+		}
+		return nil
+	})
+	retry := NewRetryNode(attempt, 3, time.Millisecond)
+	retry.Sandbox = sb
+
+	if _, err := NewFlow(retry).Run(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range seen {
+		if s != "clean" {
+			t.Errorf("attempt %d saw state %q", i, s)
+		}
+	}
+	if _, ok := readTree(t, sb.Dir)["junk.txt"]; ok {
+		t.Error("a failed attempt's files leaked into the last one")
+	}
+	if snaps := sb.Snapshots(); len(snaps) != 0 {
+		t.Errorf("RetryNode left snapshots behind: %v", snaps)
+	}
+}
+
+func TestRollbackIgnoresRetrySnapshots(t *testing.T) {
+	sb := startLocalSandbox(t)
+	ctx := context.Background()
+	writeTree(t, sb.Dir, map[string]string{"state.txt": "checkpoint"})
+
+	rollback := &RollbackTool{Sandbox: sb}
+	snapshot := &SnapshotTool{Sandbox: sb}
+	if _, err := snapshot.Run(`{"label": "checkpoint"}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := snapshot.Run(`{"label": "nodechain:retry"}`); err == nil {
+		t.Error("snapshot accepted a reserved label")
+	}
+
+	// the agent rolls back inside a RetryNode, which has its own snapshot
+	var out any
+	attempt := newFuncNode(func(ctx context.Context, mem *Memory) error {
+		writeTree(t, sb.Dir, map[string]string{"state.txt": "broken"})
+		var err error
+		out, err = rollback.Run(`{}`)
+		return err
+	})
+	retry := NewRetryNode(attempt, 1, 0)
+	retry.Sandbox = sb
+	if _, err := NewFlow(retry).Run(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readTree(t, sb.Dir)["state.txt"]; got != "checkpoint" {
+		t.Fatalf("rollback restored %q, want the agent's checkpoint", got)
+	}
+	res := out.(map[string]any)
+	snaps := res["snapshots"].([]map[string]any)
+	if len(snaps) != 1 || snaps[0]["label"] != "checkpoint" || res["restored"] != snaps[0]["id"] {
+		t.Fatalf("rollback result %v exposes or restored the wrong snapshot", res)
+	}
+}
+
+func TestRollbackUnknownSnapshot(t *testing.T) {
+	sb := startLocalSandbox(t)
+	tests := []struct {
+		name, input string
+	}{
+		{"latest with none", `{}`},
+		{"missing label", `{"snapshot": "nope"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := (&RollbackTool{Sandbox: sb}).Run(tt.input); !errors.Is(err, ErrNoSnapshot) {
+				t.Fatalf("got %v, want ErrNoSnapshot", err)
+			}
+		})
+	}
+}
+
+func TestRetryNodeWrapsErrors(t *testing.T) {
+	errAttempt := errors.New("attempt failed")
+
+	tests := []struct {
+		name    string
+		retries int
+		attempt func(ctx context.Context, sb *LocalSandbox, retry *RetryNode, cancel func()) error
+		want    error
+	}{
+		{
+			name:    "attempt error",
+			retries: 2,
+			attempt: func(ctx context.Context, sb *LocalSandbox, retry *RetryNode, cancel func()) error {
+				return errAttempt
+			},
+			want: errAttempt,
+		},
+		{
+			name:    "cancelled attempt",
+			retries: 1,
+			attempt: func(ctx context.Context, sb *LocalSandbox, retry *RetryNode, cancel func()) error {
+				cancel()
+				return ctx.Err()
+			},
+			want: context.Canceled,
+		},
+		{
+			name:    "retry snapshot gone",
+			retries: 2,
+			attempt: func(ctx context.Context, sb *LocalSandbox, retry *RetryNode, cancel func()) error {
+				if err := sb.DeleteSnapshot(ctx, internalSnapshotPrefix+"retry "+retry.TypeName()); err != nil {
+					return err
+				}
+				return errAttempt
+			},
+			want: ErrNoSnapshot,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sb := startLocalSandbox(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var retry *RetryNode
+			retry = NewRetryNode(newFuncNode(func(ctx context.Context, mem *Memory) error {
+				return tt.attempt(ctx, sb, retry, cancel)
+			}), tt.retries, time.Millisecond)
+			retry.Sandbox = sb
+
+			_, err := retry.Run(ctx, NewMemory(map[string]any{}))
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want it to wrap %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -12,6 +12,10 @@ type SerperSearchTool struct{}
 
 func (t *SerperSearchTool) Name() string { return "web_search" }
 
+func (t *SerperSearchTool) Description() string {
+	return "web_search(query: string) -> returns JSON search results with links and image URLs"
+}
+
 type serperRequest struct {
 	Query string `json:"q"`
 }
@@ -10,6 +10,21 @@ type Tool interface {
 	Run(input any) (any, error)
 }
 
+// DescribedTool is a Tool that can explain its input and output to an
+// agent. AgentNode lists the descriptions in its prompt.
+type DescribedTool interface {
+	Tool
+	Description() string
+}
+
+// MemoryTool is a Tool that needs the flow's context and memory, e.g. to
+// use the sandbox a SandboxNode acquired for the current branch. ToolNode
+// calls RunWithMemory instead of Run for these.
+type MemoryTool interface {
+	Tool
+	RunWithMemory(ctx context.Context, mem *Memory, input any) (any, error)
+}
+
 type ToolNode struct {
 	BaseNode
 	Tools        map[string]Tool
@@ -50,7 +65,13 @@ func (n *ToolNode) Run(ctx context.Context, mem *Memory) ([]Trigger, error) {
 	}
 
 	input := mem.Local[n.ToolInputKey]
-	result, err := tool.Run(input)
+	var result any
+	var err error
+	if mt, ok := tool.(MemoryTool); ok {
+		result, err = mt.RunWithMemory(ctx, mem, input)
+	} else {
+		result, err = tool.Run(input)
+	}
 	if err != nil {
 		// still record the error as an observation
 		result = map[string]any{"error": err.Error()}
@@ -9,10 +9,16 @@ import (
 )
 
 type Document struct {
-	ID        string
-	Text      string
-	Metadata  map[string]any
-	Embedding []float32
+	ID        string         `json:"id"`
+	Text      string         `json:"text"`
+	Metadata  map[string]any `json:"metadata,omitempty"`
+	Embedding []float32      `json:"embedding,omitempty"`
+}
+
+// ScoredDocument pairs a document with the score a retriever assigned to it.
+type ScoredDocument struct {
+	Document
+	Score float64
 }
 
 type VectorStore interface {
@@ -20,9 +26,64 @@ type VectorStore interface {
 	Search(ctx context.Context, query []float32, k int) ([]Document, error)
 }
 
+// EmbeddingMismatchError is returned when vectors from different embedders
+// or of different dimensions are mixed in one store.
+type EmbeddingMismatchError struct {
+	Op           string // "add", "search" or "check"
+	DocumentID   string // for adds, the offending document
+	WantEmbedder string
+	GotEmbedder  string
+	WantDim      int
+	GotDim       int
+}
+
+func (e *EmbeddingMismatchError) Error() string {
+	what := "embedding"
+	if e.DocumentID != "" {
+		what = fmt.Sprintf("document '%s'", e.DocumentID)
+	}
+	if e.WantDim != e.GotDim {
+		return fmt.Sprintf("%s: %s has dimension %d, store holds %d-dimensional embeddings (%s)",
+			e.Op, what, e.GotDim, e.WantDim, orUnknown(e.WantEmbedder))
+	}
+	return fmt.Sprintf("%s: %s comes from embedder %s, store holds embeddings from %s",
+		e.Op, what, orUnknown(e.GotEmbedder), orUnknown(e.WantEmbedder))
+}
+
+func orUnknown(name string) string {
+	if name == "" {
+		return "unknown embedder"
+	}
+	return "'" + name + "'"
+}
+
+// EmbeddingChecker is implemented by stores that know which embedder
+// produced their vectors, so query embeddings can be validated up front.
+type EmbeddingChecker interface {
+	CheckEmbedding(embedder string, dimension int) error
+}
+
+// MetadataEmbedder is the Document.Metadata key naming the embedder that
+// produced a document's vector. IngestPipeline sets it.
+const MetadataEmbedder = "embedder"
+
+// InMemoryVectorStore holds documents in memory and searches them by cosine
+// similarity. The first Add records the embedding dimension and the
+// embedder (from MetadataEmbedder, if present); later adds and searches that
+// do not match fail with *EmbeddingMismatchError.
+//
+// Only documents naming their embedder, as IngestPipeline's chunks do, can
+// be told apart from another model of the same dimension. A store whose
+// first document was unnamed rejects named ones, and unnamed documents added
+// to a named store are checked by dimension only.
 type InMemoryVectorStore struct {
 	mu   sync.RWMutex
 	docs []Document
+
+	// embedder and dimension describe the embeddings held by the store and
+	// are written to snapshots. Empty/zero means not yet known.
+	embedder  string
+	dimension int
 }
 
 func NewInMemoryVectorStore() *InMemoryVectorStore {
@@ -31,13 +92,86 @@ func NewInMemoryVectorStore() *InMemoryVectorStore {
 	}
 }
 
+// Embedder returns the name of the embedder whose vectors the store holds,
+// or "" if it is not known.
+func (s *InMemoryVectorStore) Embedder() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.embedder
+}
+
+// Dimension returns the dimension of the stored embeddings, or 0 before
+// the first Add or Load.
+func (s *InMemoryVectorStore) Dimension() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.dimension
+}
+
+// Add appends docs. The batch is rejected as a whole if any document does
+// not match the store's embedder and dimension.
 func (s *InMemoryVectorStore) Add(ctx context.Context, docs []Document) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+
+	dim, embedder, err := checkEmbeddings(s.dimension, s.embedder, docs)
+	if err != nil {
+		return err
+	}
+
+	s.dimension, s.embedder = dim, embedder
 	s.docs = append(s.docs, docs...)
 	return nil
 }
 
+// checkEmbeddings validates a batch against a store's recorded dimension and
+// embedder (zero/empty if not yet known) and returns the values the store
+// should record after accepting it.
+func checkEmbeddings(dim int, embedder string, docs []Document) (int, string, error) {
+	for _, d := range docs {
+		name, _ := d.Metadata[MetadataEmbedder].(string)
+		if dim == 0 {
+			// the first document decides, so a store of unnamed vectors
+			// never adopts the name of a later batch
+			dim, embedder = len(d.Embedding), name
+		}
+		if len(d.Embedding) != dim || len(d.Embedding) == 0 || (name != "" && name != embedder) {
+			return 0, "", &EmbeddingMismatchError{
+				Op:           "add",
+				DocumentID:   d.ID,
+				WantEmbedder: embedder,
+				GotEmbedder:  name,
+				WantDim:      dim,
+				GotDim:       len(d.Embedding),
+			}
+		}
+	}
+	return dim, embedder, nil
+}
+
+// CheckEmbedding reports whether vectors from embedder with the given
+// dimension can be compared with the stored ones. An empty embedder name
+// skips the name check.
+func (s *InMemoryVectorStore) CheckEmbedding(embedder string, dimension int) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return checkQueryEmbedding(s.dimension, s.embedder, embedder, dimension)
+}
+
+func checkQueryEmbedding(wantDim int, wantEmbedder, embedder string, dimension int) error {
+	if (wantDim != 0 && dimension != wantDim) ||
+		(wantEmbedder != "" && embedder != "" && embedder != wantEmbedder) {
+		return &EmbeddingMismatchError{
+			Op:           "check",
+			WantEmbedder: wantEmbedder,
+			GotEmbedder:  embedder,
+			WantDim:      wantDim,
+			GotDim:       dimension,
+		}
+	}
+	return nil
+}
+
 func (s *InMemoryVectorStore) Search(ctx context.Context, query []float32, k int) ([]Document, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -45,6 +179,14 @@ func (s *InMemoryVectorStore) Search(ctx context.Context, query []float32, k int
 	if len(query) == 0 {
 		return nil, fmt.Errorf("Search: empty query embedding")
 	}
+	if s.dimension != 0 && len(query) != s.dimension {
+		return nil, &EmbeddingMismatchError{
+			Op:           "search",
+			WantEmbedder: s.embedder,
+			WantDim:      s.dimension,
+			GotDim:       len(query),
+		}
+	}
 
 	type scored struct {
 		doc   Document
@@ -53,9 +195,6 @@ func (s *InMemoryVectorStore) Search(ctx context.Context, query []float32, k int
 
 	var results []scored
 	for _, d := range s.docs {
-		if len(d.Embedding) != len(query) {
-			continue
-		}
 		score := cosineSimilarity(query, d.Embedding)
 		results = append(results, scored{doc: d, score: score})
 	}
@@ -0,0 +1,95 @@
+package nodechain
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestInMemoryVectorStoreAccessorsDuringAdd(t *testing.T) {
+	e := NewHashingEmbedder(16)
+	s := NewInMemoryVectorStore()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for range 50 {
+			if err := s.Add(context.Background(), embedDocs(t, e, "a", "b")); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range 50 {
+			if d := s.Dimension(); d != 0 && d != 16 {
+				t.Errorf("dimension %d", d)
+			}
+			_ = s.Embedder()
+		}
+	}()
+	wg.Wait()
+}
+
+func TestInMemoryVectorStoreRecordsEmbedder(t *testing.T) {
+	e := NewHashingEmbedder(16)
+	docs := embedDocs(t, e, "a")
+	docs[0].Metadata = map[string]any{MetadataEmbedder: e.Name()}
+
+	s := NewInMemoryVectorStore()
+	if s.Dimension() != 0 || s.Embedder() != "" {
+		t.Fatalf("empty store records %d/%q", s.Dimension(), s.Embedder())
+	}
+	if err := s.Add(context.Background(), docs); err != nil {
+		t.Fatal(err)
+	}
+	if s.Dimension() != 16 || s.Embedder() != e.Name() {
+		t.Fatalf("store records %d/%q", s.Dimension(), s.Embedder())
+	}
+
+	var mismatch *EmbeddingMismatchError
+	if err := s.CheckEmbedding("other", 16); !errors.As(err, &mismatch) {
+		t.Fatalf("CheckEmbedding with another embedder: %v", err)
+	}
+}
+
+func TestInMemoryVectorStoreMixedEmbedders(t *testing.T) {
+	named := func(name string, dim int) []Document {
+		docs := embedDocs(t, NewHashingEmbedder(dim), "text")
+		if name != "" {
+			docs[0].Metadata = map[string]any{MetadataEmbedder: name}
+		}
+		return docs
+	}
+
+	tests := []struct {
+		name    string
+		batches [][]Document
+		wantErr bool // from the last batch
+	}{
+		{"same name", [][]Document{named("a", 8), named("a", 8)}, false},
+		{"other name", [][]Document{named("a", 8), named("b", 8)}, true},
+		{"unnamed into named", [][]Document{named("a", 8), named("", 8)}, false},
+		{"named into unnamed", [][]Document{named("", 8), named("a", 8)}, true},
+		{"unnamed then named in one batch", [][]Document{append(named("", 8), named("a", 8)...)}, true},
+		{"other dimension", [][]Document{named("", 8), named("", 16)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewInMemoryVectorStore()
+			var err error
+			for i, batch := range tt.batches {
+				if err = s.Add(context.Background(), batch); err != nil && i < len(tt.batches)-1 {
+					t.Fatal(err)
+				}
+			}
+			var mismatch *EmbeddingMismatchError
+			if tt.wantErr != errors.As(err, &mismatch) {
+				t.Fatalf("err = %v, want mismatch: %v", err, tt.wantErr)
+			}
+		})
+	}
+}